@@ -1,7 +1,6 @@
 package main
 import(
-	"bytes"
-	"encoding/gob"
+	"context"
 	"log"
 	"time"
 )
@@ -14,56 +13,77 @@ type Block struct{
 	Hash []byte
 	Nonce int
 	Height int
+	Bits uint32
 }
 
-//Serialize serializes the block
+//Serialize returns the canonical encoding of the block
 func (b *Block) Serialize() []byte{
-	var result bytes.Buffer
-	encoder:=gob.NewEncoder(&result)
+	var w canonicalWriter
+	b.encode(&w)
 
-	err:=encoder.Encode(b)
+	return w.data
+}
+
+//ParseBlock decodes a canonically encoded block
+func ParseBlock(data []byte) (*Block,error){
+	var block Block
+
+	r:=canonicalReader{data:data}
+	block.decode(&r)
+	err:=r.finish()
 	if err!=nil{
-		log.Panic(err)
+		return nil,err
 	}
 
-	return result.Bytes()
+	return &block,nil
 }
 
 //DeserializeBlock deserializes a block
 func DeserializeBlock(d []byte) *Block{
-	var block Block
+	block,err:=ParseBlock(d)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return block
+}
 
-	decoder:=gob.NewDecoder(bytes.NewReader(d))
-	err:=decoder.Decode(&block)
+//NewBlock creates and returns Block mined with the compact target bits
+func NewBlock(transactions []*Transaction,prevBlockHash []byte,height int,bits uint32)*Block{
+	block,err:=NewBlockWithContext(context.Background(),transactions,prevBlockHash,height,bits)
 	if err!=nil{
 		log.Panic(err)
 	}
 
-	return &block
+	return block
 }
 
-//NewBlock creates and returns Block
-func NewBlock(transactions []*Transaction,prevBlockHash []byte,height int)*Block{
+//NewBlockWithContext creates and returns Block, mining stops with an error when ctx is canceled
+func NewBlockWithContext(ctx context.Context,transactions []*Transaction,prevBlockHash []byte,height int,bits uint32)(*Block,error){
 	block:=&Block{
 		Timestamp:time.Now().Unix(),
 		Transactions:transactions,
 		PrevBlockHash:prevBlockHash,
 		Hash:[]byte{},
 		Nonce:0,
-		Height:height}
+		Height:height,
+		Bits:bits}
 
 	pow:=NewProofOfWork(block)
-	nonce,hash:=pow.Run()
+	nonce,hash,err:=pow.Run(ctx)
+	if err!=nil{
+		return nil,err
+	}
 
 	block.Hash=hash[:]
 	block.Nonce=nonce
 
-	return block
+	return block,nil
 }
 
 //NewGenesisBlock creates and returns genesis Block
 func NewGenesisBlock(coinbase *Transaction)*Block{
-	return NewBlock([]*Transaction{coinbase},[]byte{},0)
+	return NewBlock([]*Transaction{coinbase},[]byte{},0,initialBits())
 }
 
 //HashTransactions returns a hash of the transactions in the block
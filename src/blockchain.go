@@ -2,35 +2,39 @@ package main
 
 import(
 	"bytes"
-	"crypto/ecdsa"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"log"
 	"github.com/boltdb/bolt"
 	"os"
 	"errors"
+	"sync"
 )
 
 const dbFile="blockchain_%s.db"	
 const blocksBucket="blocks"
+const chainworkBucket="chainwork"
+const invalidBucket="invalid"
 const genesisCoinbaseData="The Times 8/Jan/2018"
 
+//errUnsupportedFormat is returned when the blocks weren't saved in the canonical encoding
+var errUnsupportedFormat=errors.New("blockchain database uses an unsupported encoding")
+
 //Blockchain implements interactions with a DB
 type Blockchain struct{
 	tip []byte
 	db *bolt.DB
+	//tipMutex guards tip and serializes changes of the main chain
+	tipMutex sync.RWMutex
 }
 
-//MineBlock mines a new block with the provided transaction
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block{
+//MineBlock mines a new block with the provided transactions and adds it to the chain.
+//Mining is abandoned when ctx is canceled.
+func (bc *Blockchain) MineBlock(ctx context.Context,transactions []*Transaction) (*Block,error){
 	var lastHash []byte
 	var lastHeight int
-
-	for _,tx:=range transactions{
-		if bc.VerifyTransaction(tx)!=true{
-			log.Panic("ERROR: Invaild transaction")
-		}
-	}
+	var bits uint32
 
 	err:=bc.db.View(func(tx *bolt.Tx) error{
 		b:=tx.Bucket([]byte(blocksBucket))
@@ -41,33 +45,28 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block{
 
 		lastHeight=block.Height
 
-		return nil
+		var err error
+		bits,err=nextBits(tx,block)
+		if err!=nil{
+			return err
+		}
+
+		return checkTransactions(tx,transactions,lastHeight+1)
 	})
 	if err!=nil{
-		log.Panic(err)
+		return nil,err
 	}
 
-	newBlock:=NewBlock(transactions,lastHash,lastHeight+1)
-
-	err=bc.db.Update(func(tx *bolt.Tx)error {
-		b:=tx.Bucket([]byte(blocksBucket))
-		err:=b.Put(newBlock.Hash,newBlock.Serialize())
-		if err!=nil{
-			log.Panic(err)
-		}
-
-		err=b.Put([]byte("1"),newBlock.Hash)
-		if err!=nil{
-			log.Panic(err)
-		}
+	newBlock,err:=NewBlockWithContext(ctx,transactions,lastHash,lastHeight+1,bits)
+	if err!=nil{
+		return nil,err
+	}
 
-		bc.tip=newBlock.Hash
-		return nil
-	})
+	_,err=bc.AddBlock(newBlock)
 	if err!=nil{
-		log.Panic(err)
+		return nil,err
 	}
-	return newBlock
+	return newBlock,nil
 }
 
 //Just db exist
@@ -96,18 +95,55 @@ func NewBlockchain(nodeID string) *Blockchain {
 	err=db.Update(func(tx *bolt.Tx) error{
 		b:=tx.Bucket([]byte(blocksBucket))
 		tip=b.Get([]byte("1"))
+
+		if _,err:=ParseBlock(b.Get(tip));err!=nil{
+			return errUnsupportedFormat
+		}
+
+		for _,bucket:=range []string{chainworkBucket,undoBucket,invalidBucket}{
+			_,err:=tx.CreateBucketIfNotExists([]byte(bucket))
+			if err!=nil{
+				return err
+			}
+		}
 		return nil
 	})
 
+	if err==errUnsupportedFormat{
+		fmt.Println("Blockchain uses an unsupported encoding,Create a new one")
+		os.Exit(1)
+	}
 	if err!=nil{
 		log.Panic(err)
 	}
 
-	bc:=Blockchain{tip,db}
+	bc:=Blockchain{tip:tip,db:db}
+
+	if !bc.hasIndexes(){
+		bc.Reindex()
+	}else if !bc.hasUTXOSet(){
+		UTXOSet:=UTXOSet{&bc}
+		UTXOSet.Reindex()
+	}
 
 	return &bc
 }
 
+//hasUTXOSet reports whether the chainstate bucket has been built
+func (bc *Blockchain) hasUTXOSet() bool{
+	exists:=false
+
+	err:=bc.db.View(func(tx *bolt.Tx) error{
+		exists=tx.Bucket([]byte(utxoBucket))!=nil
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return exists
+}
+
 //CreateBlockchain creates a new blockchain DB
 func CreateBlockchain(address,nodeID string) *Blockchain{
 	dbFile:=fmt.Sprintf(dbFile,nodeID)
@@ -123,7 +159,7 @@ func CreateBlockchain(address,nodeID string) *Blockchain{
 	}
 
 	err=db.Update(func(tx *bolt.Tx) error{
-		cbtx:=NewCoinbaseTX(address,genesisCoinbaseData)	
+		cbtx:=NewCoinbaseTX(address,genesisCoinbaseData,blockSubsidy(0))	
 		genesis:=NewGenesisBlock(cbtx)
 
 		b,err:=tx.CreateBucket([]byte(blocksBucket))
@@ -141,6 +177,28 @@ func CreateBlockchain(address,nodeID string) *Blockchain{
 			log.Panic(err)
 		}
 		tip=genesis.Hash
+
+		w,err:=tx.CreateBucket([]byte(chainworkBucket))
+		if err!=nil{
+			log.Panic(err)
+		}
+
+		err=w.Put(genesis.Hash,NewProofOfWork(genesis).Work().Bytes())
+		if err!=nil{
+			log.Panic(err)
+		}
+
+		for _,bucket:=range append([]string{undoBucket,invalidBucket},indexBuckets...){
+			_,err=tx.CreateBucket([]byte(bucket))
+			if err!=nil{
+				log.Panic(err)
+			}
+		}
+
+		err=indexBlock(tx,genesis)
+		if err!=nil{
+			log.Panic(err)
+		}
 		
 		return nil
 	})
@@ -148,7 +206,7 @@ func CreateBlockchain(address,nodeID string) *Blockchain{
 	if err!=nil{
 		log.Panic(err)
 	}
-	bc:=Blockchain{tip,db}
+	bc:=Blockchain{tip:tip,db:db}
 
 	return &bc
 }
@@ -156,27 +214,19 @@ func CreateBlockchain(address,nodeID string) *Blockchain{
 
 //FindTransaction finds a transaction by its ID
 func (bc *Blockchain) FindTransaction(ID []byte) (Transaction,error) {
-	bci:=bc.Iterator()
-
-	for{
-		block:=bci.Next()
+	var transaction Transaction
 
-		for _,tx:=range block.Transactions{
-			if bytes.Compare(tx.ID,ID)==0{
-				return *tx,nil
-			}
-		}
-		if len(block.PrevBlockHash)==0{
-			break
-		}
-	}
+	err:=bc.db.View(func(tx *bolt.Tx) error{
+		var err error
+		transaction,err=findTransaction(tx,ID)
+		return err
+	})
 
-	return Transaction{},errors.New("Transaction is not found")
+	return transaction,err
 }
 
-
-//SignTransaction signs inputs of Transaction
-func (bc *Blockchain) SignTransaction(tx *Transaction,privKey ecdsa.PrivateKey){
+//SignTransaction signs inputs of Transaction with the wallet's key
+func (bc *Blockchain) SignTransaction(tx *Transaction,wallet *Wallet){
 	prevTXs:=make(map[string]Transaction)
 
 	for _,vin:=range tx.Vin{
@@ -187,7 +237,7 @@ func (bc *Blockchain) SignTransaction(tx *Transaction,privKey ecdsa.PrivateKey){
 		prevTXs[hex.EncodeToString(prevTX.ID)]=prevTX
 	}
 
-	tx.Sign(privKey,prevTXs)
+	tx.Sign(wallet,prevTXs)
 }
 
 
@@ -202,7 +252,7 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool{
 	for _,vin:=range tx.Vin{
 		prevTX,err:=bc.FindTransaction(vin.Txid)
 		if err!=nil{
-			log.Panic(err)
+			return false
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)]=prevTX
 	}
@@ -236,7 +286,10 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs{
 					continue
 				}
 				outs:=UTXO[txID]
-				outs.Outputs=append(outs.Outputs,out)
+				if outs.Outputs==nil{
+					outs=TXOutputs{make(map[int]TXOutput),block.Height,tx.IsCoinbase()}
+				}
+				outs.Outputs[outIdx]=out
 				UTXO[txID]=outs			
 			}
 
@@ -256,72 +309,144 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs{
 	return UTXO
 }
 
-//AddBlock saves the block into the blockchain
-func (bc *Blockchain) AddBlock(block *Block){
-	err:=bc.db.Update(func(tx *bolt.Tx) error {
+//AddBlock validates the block and saves it into the blockchain.
+//The block becomes the new tip if its branch has the most cumulative work;
+//transactions of blocks dropped from the main chain by a reorganization are returned.
+func (bc *Blockchain) AddBlock(block *Block) ([]*Transaction,error){
+	var evicted []*Transaction
+	var newTip []byte
+	known:=false
+
+	err:=bc.db.View(func(tx *bolt.Tx) error {
+		b:=tx.Bucket([]byte(blocksBucket))
+		known=b.Get(block.Hash)!=nil
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+	if known{
+		return nil,nil
+	}
+
+	err=checkBlock(block)
+	if err!=nil{
+		return nil,&BlockError{block.Hash,err}
+	}
+
+	bc.tipMutex.Lock()
+	defer bc.tipMutex.Unlock()
+
+	err=bc.db.Update(func(tx *bolt.Tx) error {
 		b:=tx.Bucket([]byte(blocksBucket))
-		blockInDb:=b.Get(block.Hash)
 
-		if blockInDb!=nil{
-			return nil
+		parentData:=b.Get(block.PrevBlockHash)
+		if parentData==nil{
+			return &BlockError{block.Hash,ErrOrphanBlock}
+		}
+		if isInvalid(tx,block.PrevBlockHash){
+			return &BlockError{block.Hash,ErrInvalidBlock}
+		}
+		parent:=DeserializeBlock(parentData)
+
+		err:=checkBlockContext(tx,block,parent)
+		if err!=nil{
+			return &BlockError{block.Hash,err}
 		}
 
-		blockData:=block.Serialize()
-		err:=b.Put(block.Hash,blockData)
+		err=b.Put(block.Hash,block.Serialize())
 		if err!=nil{
-			log.Panic(err)
+			return err
+		}
+
+		work:=NewProofOfWork(block).Work()
+		work.Add(work,chainWork(tx,parent.Hash))
+		err=tx.Bucket([]byte(chainworkBucket)).Put(block.Hash,work.Bytes())
+		if err!=nil{
+			return err
 		}
 
 		lastHash:=b.Get([]byte("1"))
-		lastBlockData:=b.Get(lastHash)
-		lastBlock:=DeserializeBlock(lastBlockData)
+		if bytes.Compare(block.PrevBlockHash,lastHash)==0{
+			newTip=block.Hash
+			return connectTip(tx,block)
+		}
 
-		if block.Height>lastBlock.Height{
-			err=b.Put([]byte("1"),block.Hash)
-			if err!=nil{
-				log.Panic(err)
-			}
-			bc.tip=block.Hash
+		if work.Cmp(chainWork(tx,lastHash))>0{
+			newTip=block.Hash
+			evicted,err=reorganize(tx,lastHash,block)
+			return err
 		}
+
+		fmt.Printf("Block %x is on a side chain\n",block.Hash)
 		return nil
 	})
+	if blockErr,ok:=err.(*BlockError);ok{
+		if newTip!=nil{
+			//the branch failed to connect, so the main chain is kept. The block is saved with the failing block
+			//and the blocks between them marked invalid, and blocks building on them are rejected.
+			markErr:=bc.db.Update(func(tx *bolt.Tx) error{
+				err:=tx.Bucket([]byte(blocksBucket)).Put(block.Hash,block.Serialize())
+				if err!=nil{
+					return err
+				}
+				return invalidateBranch(tx,block,blockErr.Hash)
+			})
+			if markErr!=nil{
+				log.Panic(markErr)
+			}
+		}
+		return nil,err
+	}
 	if err!=nil{
 		log.Panic(err)
 	}
+
+	if newTip!=nil{
+		bc.tip=newTip
+	}
+	return evicted,nil
 }
 
 //GetBestHeight returns the height of the latest block
 func (bc *Blockchain) GetBestHeight() int{
-	var lastBlock Block
+	var height int
 
 	err:=bc.db.View(func(tx *bolt.Tx) error {
-		b:=tx.Bucket([]byte(blocksBucket))
-		lastHash:=b.Get([]byte("1"))
-		blockData:=b.Get(lastHash)
-
-		lastBlock=*DeserializeBlock(blockData)
+		height=bestHeight(tx)
 		return nil
 	})
 	if err!=nil{
 		log.Panic(err)
 	}
 
-	return lastBlock.Height
+	return height
+}
+
+//bestHeight returns the height of the tip of the main chain
+func bestHeight(tx *bolt.Tx) int{
+	b:=tx.Bucket([]byte(blocksBucket))
+	lastHash:=b.Get([]byte("1"))
+
+	return DeserializeBlock(b.Get(lastHash)).Height
 }
 
 //GetBlockHashes returns a list of hashes of  all the blocks in the chain
 func (bc *Blockchain) GetBlockHashes() [][]byte {
 	var blocks [][]byte
-	bci:=bc.Iterator()
 
-	for {
-		block:=bci.Next()
+	err:=bc.db.View(func(tx *bolt.Tx) error{
+		c:=tx.Bucket([]byte(heightIndexBucket)).Cursor()
 
-		blocks=append(blocks,block.Hash)
-		if len(block.PrevBlockHash)==0{
-			break
+		for k,v:=c.Last();k!=nil;k,v=c.Prev(){
+			blocks=append(blocks,v)
 		}
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
 	}
+
 	return blocks
 }
 
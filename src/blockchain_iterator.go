@@ -13,7 +13,7 @@ type BlockchainIterator struct{
 
 //Iterator return a iterator of Blockchain
 func (bc *Blockchain) Iterator() *BlockchainIterator{
-	bci:=&BlockchainIterator{bc.tip,bc.db}
+	bci:=&BlockchainIterator{bc.Tip(),bc.db}
 
 	return bci
 }
@@ -36,4 +36,12 @@ func (i *BlockchainIterator)Next()*Block{
 	i.currentHash=block.PrevBlockHash
 
 	return block
-}
\ No newline at end of file
+}
+
+//Tip returns the hash of the last block of the main chain
+func (bc *Blockchain) Tip() []byte{
+	bc.tipMutex.RLock()
+	defer bc.tipMutex.RUnlock()
+
+	return bc.tip
+}
@@ -5,22 +5,57 @@ import(
 	"fmt"
 	"log"
 	"os"
+	"strings"
 )
 
 type CLI struct{
 }
 
+//listFlag collects the values of a flag given many times
+type listFlag []string
+
+func (f *listFlag) String() string{
+	return strings.Join(*f,",")
+}
+
+func (f *listFlag) Set(value string) error{
+	*f=append(*f,value)
+	return nil
+}
+
 
 func (cli *CLI) printUsage(){
 	fmt.Println("Usage:")
-	fmt.Println("	createwallet - Create a wallet with address")
-	fmt.Println("	getbalance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("	createwallet -words N -passphrase PASSPHRASE - Create an address derived from the wallet's seed, a new seed is backed up by a mnemonic of N words (default 12)")
+	fmt.Println("	restorewallet -mnemonic PHRASE -gaplimit N -passphrase PASSPHRASE - Restore the seed of the mnemonic PHRASE and its used addresses, until N unused addresses in a row (default 20)")
+	fmt.Println("	encryptwallet -passphrase PASSPHRASE - Encrypt the private keys and the seed of the wallet file, commands that sign then need -passphrase")
+	fmt.Println("	getbalance -address ADDRESS - Get balance of ADDRESS, or of the wallet without -address")
 	fmt.Println("	listaddresses - Lists all addresses from the wallet file")
+	fmt.Println("	importaddress -address ADDRESS - Watch ADDRESS in the wallet without its private key")
+	fmt.Println("	dumpprivkey -address ADDRESS -passphrase PASSPHRASE - Print the private key of ADDRESS in Base58Check")
+	fmt.Println("	importprivkey -key KEY -rescan -passphrase PASSPHRASE - Add the private key KEY printed by dumpprivkey, -rescan=false skips looking up its balance")
+	fmt.Println("	importpubkey -pubkey PUBKEY - Watch the address of the public key PUBKEY in hex, which can be used in multisig addresses")
+	fmt.Println("	createmultisig -required M -keys KEY,KEY... - Create a pay-to-script-hash address spendable with M signatures of the KEYs, which are wallet addresses or public keys in hex")
 	fmt.Println("	createblockchain -address ADDRESS - Create a blcokchain and send genesis block reward to ADDRESS")
 	fmt.Println("	printchain - Print all the blocks of the blockchain")
-	fmt.Println("	send -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
+	fmt.Println("	send -from FROM -to TO -amount AMOUNT -fee FEE -feerate RATE -coinselect STRATEGY -locktime LOCKTIME -passphrase PASSPHRASE - Send AMOUNT of coins from FROM address to TO, or to each -to ADDRESS:AMOUNT and the recipients of -file FILE in CSV or JSON, and pay FEE plus RATE per 1000 bytes to the miner, not before block LOCKTIME+1 or, from 500000000, Unix time LOCKTIME. STRATEGY picks the outputs to spend: bnb (exact match, the default), largest, smallest or random")
+	fmt.Println("	createrawtransaction -in TXID:VOUT -to ADDRESS:AMOUNT -locktime LOCKTIME - Print the hex of an unsigned transaction spending each -in output and paying each -to, change isn't added")
+	fmt.Println("	signrawtransaction -hex HEX -prevout TXID:VOUT:SCRIPTPUBKEY[:REDEEMSCRIPT] -passphrase PASSPHRASE - Sign the inputs of raw transaction HEX with the wallet's keys, the outputs they spend come from -prevout or the UTXO set")
+	fmt.Println("	decoderawtransaction -hex HEX - Print raw transaction HEX as JSON")
+	fmt.Println("	sendrawtransaction -hex HEX - Send signed raw transaction HEX to the network")
 	fmt.Println("	reindexutxo - Rebuilds the UTXO set")
-	fmt.Println("	startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env,-miner enables mining")
+	fmt.Println("	reindex - Rebuilds the block, transaction and address indexes and the UTXO set")
+	fmt.Println("	gethistory -address ADDRESS - Lists the transactions of ADDRESS, or of the wallet without -address")
+	fmt.Println("	rollback -blocks N - Disconnects the last N blocks of the chain and removes them")
+	fmt.Println("	invalidateblock -hash HASH - Marks block HASH invalid and rewinds the chain to its parent")
+	fmt.Println("	startnode -miner ADDRESS -rpc ADDRESS - Start a node with ID specified in NODE_ID env,-miner enables mining,-rpc enables JSON-RPC on ADDRESS")
+	fmt.Println("	rpc -connect ADDRESS METHOD [PARAMS...] - Call a JSON-RPC METHOD of a running node")
+	fmt.Println("	getmempool -connect ADDRESS - Lists the pending transactions of a running node")
+	fmt.Println("	walletpassphrase -connect ADDRESS -passphrase PASSPHRASE -timeout SECONDS - Unlock the encrypted wallet of a running node for SECONDS")
+	fmt.Println("	walletlock -connect ADDRESS - Lock the wallet of a running node")
+	fmt.Println("JSON-RPC requests authenticate with RPC_USER and RPC_PASSWORD when both are set, otherwise with the rpc_NODE_ID.cookie file the node writes at start")
+	fmt.Println("Difficulty is retargeted every RETARGET_INTERVAL blocks (default 10) towards one block per BLOCK_INTERVAL seconds (default 10)")
+	fmt.Println("The block reward halves every HALVING_INTERVAL blocks (default 210) and can be spent after COINBASE_MATURITY blocks (default 10)")
 
 }
 
@@ -42,21 +77,94 @@ func (cli *CLI)Run(){
 		os.Exit(1)
 	}
 
+	err:=loadDifficultyParams()
+	if err!=nil{
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err=loadRewardParams()
+	if err!=nil{
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	getBalanceCmd:=flag.NewFlagSet("getbalance",flag.ExitOnError)
 	createBlockchainCmd:=flag.NewFlagSet("createblockchain",flag.ExitOnError)
 	sendCmd:=flag.NewFlagSet("send",flag.ExitOnError)
 	printChainCmd:=flag.NewFlagSet("printchain",flag.ExitOnError)
 	createWalletCmd:=flag.NewFlagSet("createwallet",flag.ExitOnError)
+	restoreWalletCmd:=flag.NewFlagSet("restorewallet",flag.ExitOnError)
+	encryptWalletCmd:=flag.NewFlagSet("encryptwallet",flag.ExitOnError)
 	listAddressesCmd:=flag.NewFlagSet("listaddresses",flag.ExitOnError)
+	importAddressCmd:=flag.NewFlagSet("importaddress",flag.ExitOnError)
+	importPubKeyCmd:=flag.NewFlagSet("importpubkey",flag.ExitOnError)
+	dumpPrivKeyCmd:=flag.NewFlagSet("dumpprivkey",flag.ExitOnError)
+	importPrivKeyCmd:=flag.NewFlagSet("importprivkey",flag.ExitOnError)
+	createMultiSigCmd:=flag.NewFlagSet("createmultisig",flag.ExitOnError)
 	startNodeCmd:=flag.NewFlagSet("startnode",flag.ExitOnError)
+	rpcCmd:=flag.NewFlagSet("rpc",flag.ExitOnError)
+	getMempoolCmd:=flag.NewFlagSet("getmempool",flag.ExitOnError)
+	walletPassphraseCmd:=flag.NewFlagSet("walletpassphrase",flag.ExitOnError)
+	walletLockCmd:=flag.NewFlagSet("walletlock",flag.ExitOnError)
+	createRawTransactionCmd:=flag.NewFlagSet("createrawtransaction",flag.ExitOnError)
+	signRawTransactionCmd:=flag.NewFlagSet("signrawtransaction",flag.ExitOnError)
+	decodeRawTransactionCmd:=flag.NewFlagSet("decoderawtransaction",flag.ExitOnError)
+	sendRawTransactionCmd:=flag.NewFlagSet("sendrawtransaction",flag.ExitOnError)
+	reindexUTXOCmd:=flag.NewFlagSet("reindexutxo",flag.ExitOnError)
+	reindexCmd:=flag.NewFlagSet("reindex",flag.ExitOnError)
+	getHistoryCmd:=flag.NewFlagSet("gethistory",flag.ExitOnError)
+	rollbackCmd:=flag.NewFlagSet("rollback",flag.ExitOnError)
+	invalidateBlockCmd:=flag.NewFlagSet("invalidateblock",flag.ExitOnError)
 
 	getBalanceAddress:=getBalanceCmd.String("address","","The address to get balance for")
+	getHistoryAddress:=getHistoryCmd.String("address","","The address to list transactions for")
 	createBlockchainAddress:=createBlockchainCmd.String("address","","The address to send genesis block reward to")
 	sendFrom:=sendCmd.String("from","","Source wallet address")	
-	sendTo:=sendCmd.String("to","","Destination wallet address")
-	sendAmount:=sendCmd.Int("amount",0,"Amount to send")
+	var sendTo listFlag
+	sendCmd.Var(&sendTo,"to","Destination wallet address, or ADDRESS:AMOUNT repeated for many recipients")
+	sendAmount:=sendCmd.Int("amount",0,"Amount to send to a single -to address")
+	sendFile:=sendCmd.String("file","","CSV or JSON file of recipient addresses and amounts")
+	sendFee:=sendCmd.Int("fee",0,"Fee paid to the miner")
+	sendFeeRate:=sendCmd.Int("feerate",0,"Fee per 1000 bytes of the transaction paid to the miner on top of -fee")
+	sendCoinSelect:=sendCmd.String("coinselect",DefaultCoinSelection,"Coin selection strategy: bnb, largest, smallest or random")
 	sendMine:=sendCmd.Bool("mine",false,"Mine immediately on the same node")
+	sendLockTime:=sendCmd.Uint("locktime",0,"Last block height, or Unix time from 500000000, the transaction is locked for")
+	sendPassphrase:=sendCmd.String("passphrase","","Passphrase of an encrypted wallet")
+	createWalletWords:=createWalletCmd.Int("words",defaultMnemonicWords,"Number of words of a new mnemonic: 12, 15, 18, 21 or 24")
+	restoreWalletMnemonic:=restoreWalletCmd.String("mnemonic","","Mnemonic of the seed")
+	restoreWalletGapLimit:=restoreWalletCmd.Int("gaplimit",hdGapLimit,"Number of unused addresses in a row that ends the scan")
+	createWalletPassphrase:=createWalletCmd.String("passphrase","","Passphrase of an encrypted wallet")
+	restoreWalletPassphrase:=restoreWalletCmd.String("passphrase","","Passphrase of an encrypted wallet")
+	encryptWalletPassphrase:=encryptWalletCmd.String("passphrase","","New passphrase of the wallet")
+	importAddressAddress:=importAddressCmd.String("address","","The address to watch")
+	importPubKeyPubKey:=importPubKeyCmd.String("pubkey","","The public key in hex to watch")
+	dumpPrivKeyAddress:=dumpPrivKeyCmd.String("address","","The address to print the private key of")
+	dumpPrivKeyPassphrase:=dumpPrivKeyCmd.String("passphrase","","Passphrase of an encrypted wallet")
+	importPrivKeyKey:=importPrivKeyCmd.String("key","","The private key printed by dumpprivkey")
+	importPrivKeyRescan:=importPrivKeyCmd.Bool("rescan",true,"Look up the balance of the key in the UTXO set")
+	importPrivKeyPassphrase:=importPrivKeyCmd.String("passphrase","","Passphrase of an encrypted wallet")
+	createMultiSigRequired:=createMultiSigCmd.Int("required",0,"Number of signatures needed to spend")
+	createMultiSigKeys:=createMultiSigCmd.String("keys","","Comma separated wallet addresses or public keys")
 	startNodeMiner:=startNodeCmd.String("miner","","Enable mining node and send reward to ADDRESS")
+	startNodeRPC:=startNodeCmd.String("rpc","","JSON-RPC listen address, such as "+defaultRPCAddress(nodeID)+", off by default")
+	rpcConnect:=rpcCmd.String("connect",defaultRPCAddress(nodeID),"JSON-RPC address of the node")
+	getMempoolConnect:=getMempoolCmd.String("connect",defaultRPCAddress(nodeID),"JSON-RPC address of the node")
+	walletPassphraseConnect:=walletPassphraseCmd.String("connect",defaultRPCAddress(nodeID),"JSON-RPC address of the node")
+	walletPassphrasePassphrase:=walletPassphraseCmd.String("passphrase","","Passphrase of the wallet")
+	walletPassphraseTimeout:=walletPassphraseCmd.Int("timeout",60,"Seconds the wallet stays unlocked")
+	walletLockConnect:=walletLockCmd.String("connect",defaultRPCAddress(nodeID),"JSON-RPC address of the node")
+	var createRawTransactionIn,createRawTransactionTo,signRawTransactionPrevOut listFlag
+	createRawTransactionCmd.Var(&createRawTransactionIn,"in","Output TXID:VOUT to spend, repeated for many inputs")
+	createRawTransactionCmd.Var(&createRawTransactionTo,"to","Recipient ADDRESS:AMOUNT, repeated for many recipients")
+	createRawTransactionLockTime:=createRawTransactionCmd.Uint("locktime",0,"Last block height, or Unix time from 500000000, the transaction is locked for")
+	signRawTransactionHex:=signRawTransactionCmd.String("hex","","Raw transaction in hex")
+	signRawTransactionCmd.Var(&signRawTransactionPrevOut,"prevout","Spent output TXID:VOUT:SCRIPTPUBKEY[:REDEEMSCRIPT] with the scripts in hex, repeated for many inputs")
+	signRawTransactionPassphrase:=signRawTransactionCmd.String("passphrase","","Passphrase of an encrypted wallet")
+	decodeRawTransactionHex:=decodeRawTransactionCmd.String("hex","","Raw transaction in hex")
+	sendRawTransactionHex:=sendRawTransactionCmd.String("hex","","Signed raw transaction in hex")
+	rollbackBlocks:=rollbackCmd.Int("blocks",0,"Number of blocks to disconnect")
+	invalidateBlockHash:=invalidateBlockCmd.String("hash","","Hash of the block to invalidate")
 
 	switch os.Args[1]{
 	case "getbalance":
@@ -84,16 +192,116 @@ func (cli *CLI)Run(){
 		if err!=nil{
 			log.Panic(err)
 		}
+	case "restorewallet":
+		err:=restoreWalletCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err:=encryptWalletCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "walletpassphrase":
+		err:=walletPassphraseCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "walletlock":
+		err:=walletLockCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "importaddress":
+		err:=importAddressCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "importpubkey":
+		err:=importPubKeyCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "dumpprivkey":
+		err:=dumpPrivKeyCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "importprivkey":
+		err:=importPrivKeyCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
 	case "listaddresses":
 		err:=listAddressesCmd.Parse(os.Args[2:])
 		if err!=nil{
 			log.Panic(err)
 		}
+	case "createmultisig":
+		err:=createMultiSigCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
 	case "startnode":
 		err:=startNodeCmd.Parse(os.Args[2:])
 		if err!=nil{
 			log.Panic(err)
 		}
+	case "rpc":
+		err:=rpcCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "getmempool":
+		err:=getMempoolCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "createrawtransaction":
+		err:=createRawTransactionCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "signrawtransaction":
+		err:=signRawTransactionCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "decoderawtransaction":
+		err:=decodeRawTransactionCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "sendrawtransaction":
+		err:=sendRawTransactionCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "reindexutxo":
+		err:=reindexUTXOCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "reindex":
+		err:=reindexCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "gethistory":
+		err:=getHistoryCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "rollback":
+		err:=rollbackCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
+	case "invalidateblock":
+		err:=invalidateBlockCmd.Parse(os.Args[2:])
+		if err!=nil{
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		os.Exit(1)
@@ -101,10 +309,10 @@ func (cli *CLI)Run(){
 
 	if getBalanceCmd.Parsed(){
 		if *getBalanceAddress==""{
-			getBalanceCmd.Usage()
-			os.Exit(1)
+			cli.getWalletBalance(nodeID)
+		}else{
+			cli.getBalance(*getBalanceAddress,nodeID)
 		}
-		cli.getBalance(*getBalanceAddress,nodeID)
 	}
 
 	if createBlockchainCmd.Parsed(){
@@ -116,11 +324,29 @@ func (cli *CLI)Run(){
 	}
 
 	if sendCmd.Parsed(){
-		if *sendFrom==""||*sendTo==""||*sendAmount<=0{
+		_,knownCoinSelect:=CoinSelectors[*sendCoinSelect]
+		if *sendFrom==""||len(sendTo)==0&&*sendFile==""||*sendFee<0||*sendFeeRate<0||!knownCoinSelect||*sendLockTime>maxSequence{
+			sendCmd.Usage()
+			os.Exit(1)
+		}
+
+		var recipients []Recipient
+		if len(sendTo)>0{
+			recipients,err=RecipientsFromFlags(sendTo,*sendAmount)
+		}
+		if err==nil&&*sendFile!=""{
+			var fileRecipients []Recipient
+			fileRecipients,err=LoadRecipients(*sendFile)
+			recipients=append(recipients,fileRecipients...)
+		}
+		if err!=nil{
+			fmt.Println(err)
 			sendCmd.Usage()
 			os.Exit(1)
 		}
-		cli.send(*sendFrom,*sendTo,*sendAmount,nodeID,*sendMine)
+
+		options:=TxOptions{*sendFee,*sendFeeRate,uint32(*sendLockTime),*sendCoinSelect}
+		cli.send(*sendFrom,recipients,options,*sendPassphrase,nodeID,*sendMine)
 	}
 
 	if printChainCmd.Parsed(){
@@ -128,19 +354,171 @@ func (cli *CLI)Run(){
 	}
 
 	if createWalletCmd.Parsed(){
-		cli.createWallet(nodeID)
+		cli.createWallet(*createWalletWords,*createWalletPassphrase,nodeID)
+	}
+
+	if restoreWalletCmd.Parsed(){
+		if *restoreWalletMnemonic==""||*restoreWalletGapLimit<=0{
+			restoreWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.restoreWallet(*restoreWalletMnemonic,*restoreWalletGapLimit,*restoreWalletPassphrase,nodeID)
+	}
+
+	if encryptWalletCmd.Parsed(){
+		if *encryptWalletPassphrase==""{
+			encryptWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.encryptWallet(*encryptWalletPassphrase,nodeID)
 	}
 
 	if listAddressesCmd.Parsed(){
 		cli.listAddresses(nodeID)
 	}
 
+	if importAddressCmd.Parsed(){
+		if *importAddressAddress==""{
+			importAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importAddress(*importAddressAddress,nodeID)
+	}
+
+	if dumpPrivKeyCmd.Parsed(){
+		if *dumpPrivKeyAddress==""{
+			dumpPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpPrivKey(*dumpPrivKeyAddress,*dumpPrivKeyPassphrase,nodeID)
+	}
+
+	if importPrivKeyCmd.Parsed(){
+		if *importPrivKeyKey==""{
+			importPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPrivKey(*importPrivKeyKey,*importPrivKeyRescan,*importPrivKeyPassphrase,nodeID)
+	}
+
+	if importPubKeyCmd.Parsed(){
+		if *importPubKeyPubKey==""{
+			importPubKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPubKey(*importPubKeyPubKey,nodeID)
+	}
+
+	if createMultiSigCmd.Parsed(){
+		if *createMultiSigRequired<=0||*createMultiSigKeys==""{
+			createMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultiSig(*createMultiSigRequired,strings.Split(*createMultiSigKeys,","),nodeID)
+	}
+
+	if reindexUTXOCmd.Parsed(){
+		cli.reindexUTXO(nodeID)
+	}
+
+	if reindexCmd.Parsed(){
+		cli.reindex(nodeID)
+	}
+
+	if getHistoryCmd.Parsed(){
+		if *getHistoryAddress==""{
+			cli.getWalletHistory(nodeID)
+		}else{
+			cli.getHistory(*getHistoryAddress,nodeID)
+		}
+	}
+
+	if rollbackCmd.Parsed(){
+		if *rollbackBlocks<=0{
+			rollbackCmd.Usage()
+			os.Exit(1)
+		}
+		cli.rollback(*rollbackBlocks,nodeID)
+	}
+
+	if invalidateBlockCmd.Parsed(){
+		if *invalidateBlockHash==""{
+			invalidateBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.invalidateBlock(*invalidateBlockHash,nodeID)
+	}
+
 	if startNodeCmd.Parsed(){
 		nodeID:=os.Getenv("NODE_ID")
 		if nodeID==""{
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID,*startNodeMiner)
+		cli.startNode(nodeID,*startNodeMiner,*startNodeRPC)
+	}
+
+	if rpcCmd.Parsed(){
+		if rpcCmd.NArg()==0||*rpcConnect==""{
+			rpcCmd.Usage()
+			os.Exit(1)
+		}
+		cli.rpc(*rpcConnect,rpcCmd.Arg(0),rpcCmd.Args()[1:],nodeID)
 	}
-}
\ No newline at end of file
+
+	if getMempoolCmd.Parsed(){
+		if *getMempoolConnect==""{
+			getMempoolCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getMempool(*getMempoolConnect,nodeID)
+	}
+
+	if walletPassphraseCmd.Parsed(){
+		if *walletPassphraseConnect==""||*walletPassphrasePassphrase==""||*walletPassphraseTimeout<=0{
+			walletPassphraseCmd.Usage()
+			os.Exit(1)
+		}
+		cli.walletPassphrase(*walletPassphraseConnect,*walletPassphrasePassphrase,*walletPassphraseTimeout,nodeID)
+	}
+
+	if walletLockCmd.Parsed(){
+		if *walletLockConnect==""{
+			walletLockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.walletLock(*walletLockConnect,nodeID)
+	}
+
+	if createRawTransactionCmd.Parsed(){
+		if len(createRawTransactionIn)==0||len(createRawTransactionTo)==0||*createRawTransactionLockTime>maxSequence{
+			createRawTransactionCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createRawTransaction(createRawTransactionIn,createRawTransactionTo,uint32(*createRawTransactionLockTime))
+	}
+
+	if signRawTransactionCmd.Parsed(){
+		if *signRawTransactionHex==""{
+			signRawTransactionCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signRawTransaction(*signRawTransactionHex,signRawTransactionPrevOut,*signRawTransactionPassphrase,nodeID)
+	}
+
+	if decodeRawTransactionCmd.Parsed(){
+		if *decodeRawTransactionHex==""{
+			decodeRawTransactionCmd.Usage()
+			os.Exit(1)
+		}
+		cli.decodeRawTransaction(*decodeRawTransactionHex)
+	}
+
+	if sendRawTransactionCmd.Parsed(){
+		if *sendRawTransactionHex==""{
+			sendRawTransactionCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendRawTransaction(*sendRawTransactionHex,nodeID)
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import(
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"strconv"
+	"time"
 )
 
 //startNode
-func (cli *CLI) startNode(nodeID,minerAddress string){
+func (cli *CLI) startNode(nodeID,minerAddress,rpcAddress string){
 	fmt.Printf("Starting node %s\n",nodeID)
 
 	if len(minerAddress)>0{
@@ -17,7 +23,91 @@ func (cli *CLI) startNode(nodeID,minerAddress string){
 			log.Panic("Wrong miner address!")
 		}
 	}
-	StartServer(nodeID,minerAddress)
+	StartServer(nodeID,minerAddress,rpcAddress)
+}
+
+//callNodeRPC calls a method of a running node with the credentials of the node NODE_ID
+func callNodeRPC(address,nodeID,method string,params []interface{}) (json.RawMessage,error){
+	credentials,err:=LoadRPCCredentials(nodeID)
+	if err!=nil{
+		return nil,err
+	}
+	return CallRPC(address,credentials,method,params)
+}
+
+//rpc calls a method of a running node and prints the result.
+//Params that aren't valid JSON are sent as strings.
+func (cli *CLI) rpc(address,method string,args []string,nodeID string){
+	var params []interface{}
+
+	for _,arg:=range args{
+		var param interface{}
+		if json.Unmarshal([]byte(arg),&param)!=nil{
+			param=arg
+		}
+		params=append(params,param)
+	}
+
+	result,err:=callNodeRPC(address,nodeID,method,params)
+	if err!=nil{
+		fmt.Println("ERROR:",err)
+		os.Exit(1)
+	}
+
+	var out bytes.Buffer
+	if json.Indent(&out,result,"","  ")!=nil{
+		out.Write(result)
+	}
+	fmt.Println(out.String())
+}
+
+//getMempool prints the pending transactions of a running node in the order they would be mined
+func (cli *CLI) getMempool(address,nodeID string){
+	result,err:=callNodeRPC(address,nodeID,"getmempool",nil)
+	if err!=nil{
+		fmt.Println("ERROR:",err)
+		os.Exit(1)
+	}
+
+	var infos []mempoolInfo
+	err=json.Unmarshal(result,&infos)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	if len(infos)==0{
+		fmt.Println("Mempool is empty")
+		return
+	}
+	for _,info:=range infos{
+		fmt.Printf("Transaction %s\n",info.ID)
+		fmt.Printf("  Fee: %d\n",info.Fee)
+		fmt.Printf("  Size: %d\n",info.Size)
+		fmt.Printf("  Added: %s\n",time.Unix(info.Time,0).Format(time.RFC3339))
+		for _,parent:=range info.Depends{
+			fmt.Printf("  Depends on: %s\n",parent)
+		}
+	}
+}
+
+//walletPassphrase unlocks the encrypted wallet of a running node for timeout seconds
+func (cli *CLI) walletPassphrase(address,passphrase string,timeout int,nodeID string){
+	_,err:=callNodeRPC(address,nodeID,"walletpassphrase",[]interface{}{passphrase,timeout})
+	if err!=nil{
+		fmt.Println("ERROR:",err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wallet is unlocked for %d seconds\n",timeout)
+}
+
+//walletLock locks the wallet of a running node
+func (cli *CLI) walletLock(address,nodeID string){
+	_,err:=callNodeRPC(address,nodeID,"walletlock",nil)
+	if err!=nil{
+		fmt.Println("ERROR:",err)
+		os.Exit(1)
+	}
+	fmt.Println("Wallet is locked")
 }
 
 //createBlockchain create a new blockchain
@@ -35,41 +125,232 @@ func (cli *CLI) createBlockchain(address,nodeID string) {
 	fmt.Println("Done!")
 }
 
-//send send amount from FROM to TO
-func (cli *CLI) send(from,to string,amount int,nodeID string,mineNow bool){
+//reindexUTXO rebuilds the UTXO set from the blocks
+func (cli *CLI) reindexUTXO(nodeID string){
+	bc:=NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	UTXOSet:=UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	fmt.Println("Done!")
+}
+
+//reindex rebuilds the indexes and the UTXO set from the blocks
+func (cli *CLI) reindex(nodeID string){
+	bc:=NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	bc.Reindex()
+
+	fmt.Println("Done!")
+}
+
+//getHistory lists the transactions of address
+func (cli *CLI) getHistory(address,nodeID string){
+	if !ValidateAddress(address){
+		log.Panic("ERROR: Address is not valid")
+	}
+	bc:=NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	for _,tx:=range bc.FindAddressTransactions(AddressToScript(address)){
+		fmt.Println(tx)
+	}
+}
+
+//getWalletHistory lists the transactions of the wallet's addresses, watch-only ones included,
+//with what they paid to and spent from the wallet
+func (cli *CLI) getWalletHistory(nodeID string){
+	wallets,err:=NewWallets(nodeID)
+	if err!=nil{
+		log.Panic(err)
+	}
+	bc:=NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	spendable,watchOnly:=wallets.Scripts()
+	for _,tx:=range bc.FindWalletTransactions(append(spendable,watchOnly...)){
+		fmt.Printf("Block %d transaction %x: received %d, sent %d\n",tx.Height,tx.Transaction.ID,tx.Received,tx.Sent)
+	}
+}
+
+//rollback disconnects the last blocks of the chain
+func (cli *CLI) rollback(blocks int,nodeID string){
+	bc:=NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	err:=bc.Rollback(blocks)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	fmt.Printf("Done! Chain height is %d now.\n",bc.GetBestHeight())
+}
+
+//invalidateBlock marks a block invalid and rewinds the chain before it
+func (cli *CLI) invalidateBlock(blockHash,nodeID string){
+	hash,err:=hex.DecodeString(blockHash)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	bc:=NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	err=bc.InvalidateBlock(hash)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	fmt.Printf("Done! Chain height is %d now.\n",bc.GetBestHeight())
+}
+
+//send pays the recipients from FROM in one transaction. A transaction with a locktime in the future is printed instead of sent.
+func (cli *CLI) send(from string,recipients []Recipient,options TxOptions,passphrase,nodeID string,mineNow bool){
 	if !ValidateAddress(from){
 		log.Panic("ERROR:Sender address is not valid")
 	}
-	if !ValidateAddress(to){
-		log.Panic("ERROR:Recipient address is not valid")
-	}
 
 	bc:=NewBlockchain(nodeID)
 	UTXOSet:=UTXOSet{bc}
 	defer bc.db.Close()
 
-	wallets,err:=NewWallets(nodeID)
+	wallets:=openWallets(nodeID,passphrase)
+	wallet,err:=wallets.SigningWallet(from)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	tx,err:=NewBatchTransaction(wallet,recipients,options,&UTXOSet)
 	if err!=nil{
 		log.Panic(err)
 	}
+	if !bc.IsFinal(tx){
+		fmt.Printf("Transaction is locked %s, send it then:\n%x\n",LockTimeString(options.LockTime),tx.Serialize())
+		return
+	}
 
-	wallet:=wallets.GetWallet(from)
+	fee:=-tx.OutputValue()
+	for _,vin:=range tx.Vin{
+		out,_:=UTXOSet.FindOutput(vin.Txid,vin.Vout)
+		fee+=out.Value
+	}
+	fmt.Printf("Paying %d to %d recipients, spending %d outputs with a fee of %d\n",TotalAmount(recipients),len(recipients),len(tx.Vin),fee)
 
-	tx:=NewUTXOTransaction(&wallet,to,amount,&UTXOSet)
 	if mineNow{
-		cbtx:=NewCoinbaseTX(from,"")
+		cbtx:=NewCoinbaseTX(from,"",blockSubsidy(bc.GetBestHeight()+1)+fee)
 		txs:=[]*Transaction{cbtx,tx}
 
-		newBlock:=bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
+		_,err:=bc.MineBlock(context.Background(),txs)
+		if err!=nil{
+			log.Panic(err)
+		}
 	}else{
-		sendTx(knownNodes[0],tx)
+		n:=NewNode("","",[]string{centralNode},bc)
+		n.sendTx(centralNode,tx)
+		n.closePeers()
 	}
 	
 
 	fmt.Println("Send Success!")
 }
 
+//createRawTransaction prints the hex of an unsigned transaction spending the outputs TXID:VOUT and paying ADDRESS:AMOUNT
+func (cli *CLI) createRawTransaction(inputs,outputs []string,lockTime uint32){
+	var recipients []Recipient
+	for _,output:=range outputs{
+		recipient,err:=ParseRecipient(output)
+		if err!=nil{
+			log.Panic(err)
+		}
+		recipients=append(recipients,recipient)
+	}
+
+	tx,err:=NewRawTransaction(inputs,recipients,lockTime)
+	if err!=nil{
+		log.Panic(err)
+	}
+	fmt.Println(EncodeRawTransaction(tx))
+}
+
+//signRawTransaction signs a raw transaction with the wallet's keys and prints its hex.
+//Spent outputs that aren't given as TXID:VOUT:SCRIPTPUBKEY[:REDEEMSCRIPT] are looked up in the UTXO set, if there is a blockchain.
+func (cli *CLI) signRawTransaction(rawTx string,prevOutArgs []string,passphrase,nodeID string){
+	tx,err:=DecodeRawTransaction(rawTx)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	var prevOuts []PrevOut
+	for _,arg:=range prevOutArgs{
+		prevOut,err:=ParsePrevOut(arg)
+		if err!=nil{
+			log.Panic(err)
+		}
+		prevOuts=append(prevOuts,prevOut)
+	}
+	if dbExists(fmt.Sprintf(dbFile,nodeID)){
+		bc:=NewBlockchain(nodeID)
+		prevOuts=UTXOSet{bc}.FindPrevOuts(&tx,prevOuts)
+		bc.db.Close()
+	}
+
+	wallets:=openWallets(nodeID,passphrase)
+	complete,err:=wallets.SignRawTransaction(&tx,prevOuts)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	fmt.Println(EncodeRawTransaction(&tx))
+	if complete{
+		fmt.Println("All inputs are signed")
+	}else{
+		fmt.Println("Some inputs aren't signed yet")
+	}
+}
+
+//decodeRawTransaction prints a raw transaction as JSON
+func (cli *CLI) decodeRawTransaction(rawTx string){
+	tx,err:=DecodeRawTransaction(rawTx)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	out,err:=json.MarshalIndent(newRPCTransaction(&tx),"","  ")
+	if err!=nil{
+		log.Panic(err)
+	}
+	fmt.Println(string(out))
+}
+
+//sendRawTransaction sends a signed raw transaction to the central node
+func (cli *CLI) sendRawTransaction(rawTx,nodeID string){
+	tx,err:=DecodeRawTransaction(rawTx)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	bc:=NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	for _,vin:=range tx.Vin{
+		_,ok:=UTXOSet{bc}.FindOutput(vin.Txid,vin.Vout)
+		if !ok{
+			log.Panicf("ERROR: output %x:%d is spent or unknown",vin.Txid,vin.Vout)
+		}
+	}
+	if !bc.VerifyTransaction(&tx){
+		log.Panic("ERROR: transaction isn't fully signed")
+	}
+
+	n:=NewNode("","",[]string{centralNode},bc)
+	n.sendTx(centralNode,&tx)
+	n.closePeers()
+
+	fmt.Printf("Sent transaction %x\n",tx.ID)
+}
+
 //getBalance get balance of address
 func (cli *CLI) getBalance(address string,nodeID string){
 	if !ValidateAddress(address){
@@ -79,27 +360,159 @@ func (cli *CLI) getBalance(address string,nodeID string){
 	UTXOSet:=UTXOSet{bc}
 	defer bc.db.Close()
 
-	balance:=0
-	pubKeyHash:=Base58Decode([]byte(address))
-	pubKeyHash=pubKeyHash[1:len(pubKeyHash)-4]
-	
-	UTXOs:=UTXOSet.FindUTXO(pubKeyHash)
+	balance,immature:=UTXOSet.FindBalance(AddressToScript(address))
+	fmt.Printf("Balance of '%s':%d\n",address,balance)
+	if immature>0{
+		fmt.Printf("Immature balance of '%s':%d\n",address,immature)
+	}
+}
 
-	for _,out:=range UTXOs{
-		balance+=out.Value
+//getWalletBalance prints the balance of the wallet's addresses, the watch-only ones separately
+func (cli *CLI) getWalletBalance(nodeID string){
+	wallets,err:=NewWallets(nodeID)
+	if err!=nil{
+		log.Panic(err)
+	}
+	bc:=NewBlockchain(nodeID)
+	UTXOSet:=UTXOSet{bc}
+	defer bc.db.Close()
+
+	spendable,watchOnly:=wallets.Scripts()
+	balance,immature:=UTXOSet.FindScriptsBalance(spendable)
+	fmt.Printf("Balance of the wallet:%d\n",balance)
+	if immature>0{
+		fmt.Printf("Immature balance of the wallet:%d\n",immature)
+	}
+
+	if len(watchOnly)>0{
+		balance,immature=UTXOSet.FindScriptsBalance(watchOnly)
+		fmt.Printf("Watch-only balance:%d\n",balance)
+		if immature>0{
+			fmt.Printf("Immature watch-only balance:%d\n",immature)
+		}
 	}
-	fmt.Printf("Balance of '%s':%d\n",address,balance)
 }
 
-//createWallet create a new wallet
-func (cli *CLI) createWallet(nodeID string){
-	wallets,_:=NewWallets(nodeID)
-	//wallet:=NewWallet()
-	address:=wallets.CreateWallet()
+//createWallet adds an address derived from the wallet's seed. A wallet without a seed gets one
+//from a new mnemonic of the number of words, which is printed as the backup.
+func (cli *CLI) createWallet(words int,passphrase,nodeID string){
+	wallets:=openWallets(nodeID,passphrase)
+
+	if wallets.Seed==nil{
+		mnemonic,err:=NewMnemonic(words)
+		if err!=nil{
+			log.Panic(err)
+		}
+		seed,err:=MnemonicToSeed(mnemonic,"")
+		if err!=nil{
+			log.Panic(err)
+		}
+		wallets.SetSeed(seed)
+
+		fmt.Println("Your recovery phrase, write it down and keep it safe:")
+		fmt.Println(mnemonic)
+		if len(wallets.Wallets)>0{
+			fmt.Println("Addresses created before it can't be restored from the phrase, keep a backup of the wallet file as well")
+		}
+	}
+
+	address,err:=wallets.CreateWallet()
+	if err!=nil{
+		log.Panic(err)
+	}
 	wallets.SaveToFile(nodeID)
 	fmt.Println("Your new address:",address)
 }
 
+//restoreWallet sets the seed of the mnemonic and adds its addresses that are used in the blockchain,
+//scanning until gapLimit addresses in a row are unused
+func (cli *CLI) restoreWallet(mnemonic string,gapLimit int,passphrase,nodeID string){
+	wallets:=openWallets(nodeID,passphrase)
+
+	seed,err:=MnemonicToSeed(mnemonic,"")
+	if err!=nil{
+		log.Panic(err)
+	}
+	err=wallets.SetSeed(seed)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	if dbExists(fmt.Sprintf(dbFile,nodeID)){
+		bc:=NewBlockchain(nodeID)
+		for _,address:=range wallets.ScanAddresses(bc.IsScriptUsed,gapLimit){
+			fmt.Println("Found used address:",address)
+		}
+		bc.db.Close()
+	}else{
+		fmt.Println("No existing blockchain found, used addresses aren't scanned")
+	}
+
+	if wallets.HDIndex==0{
+		address,err:=wallets.CreateWallet()
+		if err!=nil{
+			log.Panic(err)
+		}
+		fmt.Println("Your new address:",address)
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Printf("Wallet restored with %d addresses\n",wallets.HDIndex)
+}
+
+//encryptWallet encrypts the private keys and the seed of the wallet file with the passphrase
+func (cli *CLI) encryptWallet(passphrase,nodeID string){
+	wallets,err:=NewWallets(nodeID)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	err=wallets.Encrypt(passphrase)
+	if err!=nil{
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Println("Wallet encrypted, the passphrase is needed to send and to create addresses")
+
+	backup:=fmt.Sprintf(walletFile,nodeID)+".bak"
+	if _,err:=os.Stat(backup);err==nil{
+		fmt.Printf("%s still holds the unencrypted keys of the old wallet file\n",backup)
+	}
+}
+
+//openWallets loads the wallet file of the node and unlocks it with the passphrase if it is encrypted
+func openWallets(nodeID,passphrase string) *Wallets{
+	wallets,err:=NewWallets(nodeID)
+	if err!=nil&&!os.IsNotExist(err){
+		log.Panic(err)
+	}
+
+	if wallets.IsLocked(){
+		if passphrase==""{
+			fmt.Println("Wallet is encrypted, unlock it with -passphrase")
+			os.Exit(1)
+		}
+		err=wallets.Unlock(passphrase)
+		if err!=nil{
+			log.Panic(err)
+		}
+	}
+
+	return wallets
+}
+
+//createMultiSig prints the pay-to-script-hash address and redeem script of a multisig of the keys
+func (cli *CLI) createMultiSig(required int,keys []string,nodeID string){
+	wallets,_:=NewWallets(nodeID)
+
+	address,redeemScript,err:=wallets.MultiSigAddress(required,keys)
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	fmt.Println("Address:",address)
+	fmt.Printf("Redeem script:%x\n",redeemScript)
+}
+
 //listAddresses list all addresses
 func (cli *CLI) listAddresses(nodeID string){
 	wallets,err:=NewWallets(nodeID)
@@ -112,6 +525,80 @@ func (cli *CLI) listAddresses(nodeID string){
 	for _,address:=range addresses{
 		fmt.Println(address)
 	}
+	for _,address:=range wallets.WatchOnlyAddresses(){
+		fmt.Println(address,"(watch-only)")
+	}
+}
+
+//importAddress adds a watch-only address to the wallet file
+func (cli *CLI) importAddress(address,nodeID string){
+	wallets,err:=NewWallets(nodeID)
+	if err!=nil&&!os.IsNotExist(err){
+		log.Panic(err)
+	}
+
+	err=wallets.ImportAddress(address)
+	if err!=nil{
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Println("Watching",address)
+}
+
+//dumpPrivKey prints the private key of an address of the wallet
+func (cli *CLI) dumpPrivKey(address,passphrase,nodeID string){
+	wallets:=openWallets(nodeID,passphrase)
+
+	key,err:=wallets.DumpPrivateKey(address)
+	if err!=nil{
+		log.Panic(err)
+	}
+	fmt.Println(key)
+}
+
+//importPrivKey adds a private key to the wallet file. With rescan, the UTXO set is scanned
+//for the outputs of its address, so the balance shows up before the next block.
+func (cli *CLI) importPrivKey(key string,rescan bool,passphrase,nodeID string){
+	wallets:=openWallets(nodeID,passphrase)
+
+	address,err:=wallets.ImportPrivateKey(key)
+	if err!=nil{
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Println("Imported",address)
+
+	if !rescan||!dbExists(fmt.Sprintf(dbFile,nodeID)){
+		return
+	}
+	bc:=NewBlockchain(nodeID)
+	UTXOSet:=UTXOSet{bc}
+	defer bc.db.Close()
+
+	balance,immature:=UTXOSet.FindBalance(AddressToScript(address))
+	fmt.Printf("Balance of '%s':%d\n",address,balance)
+	if immature>0{
+		fmt.Printf("Immature balance of '%s':%d\n",address,immature)
+	}
+}
+
+//importPubKey adds the address of a public key to the wallet file as watch-only
+func (cli *CLI) importPubKey(pubKey,nodeID string){
+	key,err:=hex.DecodeString(pubKey)
+	if err!=nil{
+		log.Panic("ERROR: Public key is not hex")
+	}
+	wallets,err:=NewWallets(nodeID)
+	if err!=nil&&!os.IsNotExist(err){
+		log.Panic(err)
+	}
+
+	address,err:=wallets.ImportPubKey(key)
+	if err!=nil{
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Println("Watching",address)
 }
 
 //printChain print the blockchain
@@ -129,6 +616,7 @@ func (cli *CLI) printChain(nodeID string){
 		fmt.Printf("Prev hash:%x\n",block.PrevBlockHash)
 		fmt.Printf("Hash:%x\n",block.Hash)
 		fmt.Printf("Nonce:%d\n",block.Nonce)
+		fmt.Printf("Bits:%08x\n",block.Bits)
 		pow:=NewProofOfWork(block)
 		fmt.Printf("PoW:%s\n",strconv.FormatBool(pow.Validate()))
 		fmt.Printf("Count of Transactions:%d\n",len(block.Transactions))
@@ -0,0 +1,194 @@
+package main
+
+import(
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+//Coin selection picks the unspent outputs a wallet transaction spends. Every input makes the
+//transaction bigger, so the strategies compare the coins with the amount plus the fee of the
+//transaction spending them, as estimated by TxFees. Change that wouldn't pay for its own output
+//is left to the miner.
+
+const(
+	//DefaultCoinSelection is the strategy of send without -coinselect
+	DefaultCoinSelection="bnb"
+	//bnbMaxTries bounds the branches the exact match search visits
+	bnbMaxTries=100000
+	//maxSignatureSize is a DER encoded P-256 signature and its hash type
+	maxSignatureSize=73
+	//estimatedVout is an output index as big as the estimate of an input allows for
+	estimatedVout=1<<10
+)
+
+//Coin is an unspent output of the wallet
+type Coin struct{
+	Txid []byte
+	Vout int
+	Value int
+}
+
+//CoinSelector returns coins that pay amount and the fee of a transaction spending them,
+//or nil if all the coins aren't enough
+type CoinSelector func(coins []Coin,amount int,fees TxFees) []Coin
+
+//CoinSelectors are the strategies of send -coinselect
+var CoinSelectors=map[string]CoinSelector{
+	"largest":SelectLargestFirst,
+	"smallest":SelectSmallestFirst,
+	"bnb":SelectBranchAndBound,
+	"random":SelectRandom,
+}
+
+//TxFees estimates the fee of a transaction from its serialized size, the fixed Fee plus
+//FeeRate coins per 1000 bytes rounded up
+type TxFees struct{
+	Fee int
+	FeeRate int
+	baseSize int
+	inputSize int
+	changeSize int
+}
+
+//NewTxFees estimates the fees of tx, which has its outputs but not the inputs, spending pay-to-pubkey-hash
+//outputs with unlocking scripts of scriptSigSize bytes and maybe adding the change output
+func NewTxFees(fee,feeRate int,tx Transaction,change TXOutput,scriptSigSize int) TxFees{
+	tx.Vin=nil
+	input:=TXInput{make([]byte,32),estimatedVout,make([]byte,scriptSigSize),maxSequence}
+
+	var w canonicalWriter
+	input.encode(&w)
+	inputSize:=len(w.data)
+
+	w=canonicalWriter{}
+	change.encode(&w)
+
+	return TxFees{fee,feeRate,len(tx.Serialize()),inputSize,len(w.data)}
+}
+
+//For returns the fee of the transaction with the number of inputs, with or without the change output
+func (f TxFees) For(inputs int,change bool) int{
+	size:=f.baseSize+inputs*f.inputSize+len(binary.AppendUvarint(nil,uint64(inputs)))-1
+	if change{
+		size+=f.changeSize
+	}
+
+	return f.Fee+(size*f.FeeRate+999)/1000
+}
+
+//SelectLargestFirst spends the biggest coins, with the fewest inputs
+func SelectLargestFirst(coins []Coin,amount int,fees TxFees) []Coin{
+	sorted:=sortedCoins(coins,true)
+	return accumulateCoins(sorted,amount,fees)
+}
+
+//SelectSmallestFirst spends the smallest coins, which consolidates dust at a higher fee
+func SelectSmallestFirst(coins []Coin,amount int,fees TxFees) []Coin{
+	sorted:=sortedCoins(coins,false)
+	return accumulateCoins(sorted,amount,fees)
+}
+
+//SelectRandom spends coins in random order, so payments don't reveal a pattern
+func SelectRandom(coins []Coin,amount int,fees TxFees) []Coin{
+	shuffled:=append([]Coin{},coins...)
+	rand.Shuffle(len(shuffled),func(i,j int){
+		shuffled[i],shuffled[j]=shuffled[j],shuffled[i]
+	})
+	return accumulateCoins(shuffled,amount,fees)
+}
+
+//SelectBranchAndBound searches depth first, largest coins first, for coins that pay amount and the fee
+//without change and leave less to the miner than a change output would cost. Without such a match
+//in bnbMaxTries branches it spends the largest coins first.
+func SelectBranchAndBound(coins []Coin,amount int,fees TxFees) []Coin{
+	sorted:=sortedCoins(coins,true)
+
+	//remaining[i] is the value of the coins from i on, to give up branches that can't reach amount
+	remaining:=make([]int,len(sorted)+1)
+	for i:=len(sorted)-1;i>=0;i--{
+		remaining[i]=remaining[i+1]+sorted[i].Value
+	}
+
+	var selected,match []Coin
+	tries:=0
+	var search func(i,total int) bool
+	search=func(i,total int) bool{
+		tries++
+		if tries>bnbMaxTries{
+			return false
+		}
+
+		if len(selected)>0{
+			if total>amount+fees.For(len(selected),true){
+				return false
+			}
+			if total>=amount+fees.For(len(selected),false){
+				match=append([]Coin{},selected...)
+				return true
+			}
+		}
+		if i==len(sorted)||total+remaining[i]<amount+fees.For(len(selected)+1,false){
+			return false
+		}
+
+		selected=append(selected,sorted[i])
+		if search(i+1,total+sorted[i].Value){
+			return true
+		}
+		selected=selected[:len(selected)-1]
+
+		//Leaving out a coin and then one of the same value visits the same sums again
+		next:=i+1
+		for next<len(sorted)&&sorted[next].Value==sorted[i].Value{
+			next++
+		}
+		return search(next,total)
+	}
+
+	if search(0,0){
+		return match
+	}
+	return accumulateCoins(sorted,amount,fees)
+}
+
+//coinSelector returns the strategy named by CoinSelection
+func (o TxOptions) coinSelector() (CoinSelector,error){
+	name:=o.CoinSelection
+	if name==""{
+		name=DefaultCoinSelection
+	}
+
+	selectCoins,ok:=CoinSelectors[name]
+	if !ok{
+		return nil,fmt.Errorf("unknown coin selection %q",name)
+	}
+	return selectCoins,nil
+}
+
+//accumulateCoins takes coins in order until they pay amount and the fee
+func accumulateCoins(coins []Coin,amount int,fees TxFees) []Coin{
+	total:=0
+
+	for i,coin:=range coins{
+		total+=coin.Value
+		if total>=amount+fees.For(i+1,false){
+			return coins[:i+1]
+		}
+	}
+	return nil
+}
+
+//sortedCoins returns a copy of the coins sorted by value
+func sortedCoins(coins []Coin,descending bool) []Coin{
+	sorted:=append([]Coin{},coins...)
+	sort.SliceStable(sorted,func(i,j int) bool{
+		if descending{
+			return sorted[i].Value>sorted[j].Value
+		}
+		return sorted[i].Value<sorted[j].Value
+	})
+
+	return sorted
+}
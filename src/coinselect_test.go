@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCoins(values ...int) []Coin {
+	var coins []Coin
+	for i, value := range values {
+		coins = append(coins, Coin{[]byte{byte(i)}, i, value})
+	}
+	return coins
+}
+
+func coinValues(coins []Coin) []int {
+	var values []int
+	for _, coin := range coins {
+		values = append(values, coin.Value)
+	}
+	return values
+}
+
+func TestCoinSelectors(t *testing.T) {
+	coins := testCoins(3, 1, 8, 2, 5)
+	fees := TxFees{Fee: 1}
+
+	assert.Equal(t, []int{8}, coinValues(SelectLargestFirst(coins, 6, fees)), "Largest coins are spent first")
+	assert.Equal(t, []int{1, 2, 3, 5}, coinValues(SelectSmallestFirst(coins, 9, fees)), "Smallest coins are spent first")
+	assert.Equal(t, []int{8, 1}, coinValues(SelectBranchAndBound(coins, 8, fees)), "Exact match pays amount and fee without change")
+	assert.Equal(t, []int{8, 5, 3, 2, 1}, coinValues(SelectBranchAndBound(coins, 18, fees)), "All coins can be the exact match")
+	assert.Equal(t, []int{8}, coinValues(SelectBranchAndBound(testCoins(8, 4), 2, fees)), "Without exact match largest coins are spent first")
+	assert.Equal(t, []int{3, 2}, coinValues(SelectBranchAndBound(testCoins(3, 1, 8, 2), 3, TxFees{Fee: 1, FeeRate: 1000, changeSize: 1})), "Exact match may leave less than the change output costs")
+
+	total := 0
+	for _, coin := range SelectRandom(coins, 10, fees) {
+		total += coin.Value
+	}
+	assert.True(t, total >= 11, "Random coins pay amount and fee")
+
+	for name, selectCoins := range CoinSelectors {
+		assert.Nil(t, selectCoins(coins, 19, fees), name+" finds no coins for more than the balance")
+		assert.Nil(t, selectCoins(nil, 1, fees), name+" finds no coins in an empty wallet")
+	}
+}
+
+func TestTxFees(t *testing.T) {
+	wallet := NewWallet()
+	tx := Transaction{nil, nil, []TXOutput{*NewTXOutput(1, string(wallet.GetAddress()))}, 0}
+	fees := NewTxFees(2, 1000, tx, *NewTXOutput(0, string(wallet.GetAddress())), 2+maxSignatureSize+len(wallet.PublicKey))
+
+	assert.Equal(t, 2, NewTxFees(2, 0, tx, TXOutput{}, 0).For(10, true), "Without fee rate the fee is fixed")
+	assert.Equal(t, 2+len(tx.Serialize()), fees.For(0, false), "Fee rate is per 1000 bytes")
+	assert.True(t, fees.For(1, false) > fees.For(0, false), "Inputs add to the fee")
+	assert.True(t, fees.For(1, true) > fees.For(1, false), "Change output adds to the fee")
+	assert.Equal(t, 2+1, NewTxFees(2, 1, tx, TXOutput{}, 0).For(1, false), "Fee is rounded up")
+
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress()), "", subsidy)
+	spent := Transaction{nil, []TXInput{{coinbase.ID, 0, nil, maxSequence}}, tx.Vout, 0}
+	spent.Sign(wallet, map[string]Transaction{hex.EncodeToString(coinbase.ID): *coinbase})
+	assert.True(t, fees.For(1, false) >= 2+len(spent.Serialize()), "Estimate covers the signed transaction")
+}
+
+func TestNewUTXOTransactionCoinSelection(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	UTXOSet := UTXOSet{bc}
+	minerAddress := string(miner.GetAddress())
+	other := string(NewWallet().GetAddress())
+	genesis := bc.Iterator().Next().Transactions[0]
+
+	split := Transaction{nil, []TXInput{{genesis.ID, 0, nil, maxSequence}}, nil, 0}
+	for _, value := range []int{1, 2, 3, 4} {
+		split.Vout = append(split.Vout, *NewTXOutput(value, minerAddress))
+	}
+	split.ID = split.Hash()
+	bc.SignTransaction(&split, miner)
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), &split})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(coinSelection string, amount int) *Transaction {
+		tx, err := NewUTXOTransaction(miner, other, amount, TxOptions{Fee: 1, CoinSelection: coinSelection}, &UTXOSet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, bc.VerifyTransaction(tx), coinSelection+" transaction is signed")
+		return tx
+	}
+
+	tx := send("bnb", 5)
+	assert.Len(t, tx.Vout, 1, "Exact match needs no change")
+	assert.Equal(t, 5, tx.OutputValue())
+
+	tx = send("smallest", 4)
+	assert.Len(t, tx.Vin, 3, "Smallest coins are consolidated")
+	assert.Equal(t, 1+2+3-1, tx.OutputValue(), "Change goes back to the sender")
+
+	tx = send("largest", 5)
+	assert.Equal(t, subsidy-1, tx.OutputValue(), "Largest coin is the new coinbase")
+	assert.Len(t, tx.Vin, 1)
+
+	_, err = NewUTXOTransaction(miner, other, 5, TxOptions{CoinSelection: "oldest"}, &UTXOSet)
+	assert.NotNil(t, err, "Unknown coin selection is an error")
+	_, err = NewUTXOTransaction(miner, other, 100, TxOptions{}, &UTXOSet)
+	assert.Equal(t, ErrNotEnoughFunds, err)
+}
@@ -0,0 +1,131 @@
+package main
+
+import(
+	"errors"
+	"math/big"
+	"os"
+	"strconv"
+	"github.com/boltdb/bolt"
+)
+
+//initialTargetBits is the difficulty of the genesis block in leading zero bits
+const initialTargetBits=16
+
+//minTargetBits bounds how easy mining can get after retargeting
+const minTargetBits=8
+
+var(
+	//retargetInterval is the number of blocks between difficulty adjustments
+	retargetInterval=10
+	//blockInterval is the desired time between blocks in seconds
+	blockInterval=int64(10)
+
+	powLimit=new(big.Int).Lsh(big.NewInt(1),256-minTargetBits)
+)
+
+//loadDifficultyParams overrides the retarget settings with RETARGET_INTERVAL and BLOCK_INTERVAL env. vars.
+//All nodes of a network must use the same values.
+func loadDifficultyParams() error{
+	if value:=os.Getenv("RETARGET_INTERVAL");value!=""{
+		interval,err:=strconv.Atoi(value)
+		if err!=nil||interval<=0{
+			return errors.New("RETARGET_INTERVAL must be a positive number of blocks")
+		}
+		retargetInterval=interval
+	}
+
+	if value:=os.Getenv("BLOCK_INTERVAL");value!=""{
+		interval,err:=strconv.ParseInt(value,10,64)
+		if err!=nil||interval<=0{
+			return errors.New("BLOCK_INTERVAL must be a positive number of seconds")
+		}
+		blockInterval=interval
+	}
+	return nil
+}
+
+//CompactToBig converts the compact representation of a target to a big integer.
+//The high byte is the length of the number in bytes and the rest are its most significant bytes.
+func CompactToBig(compact uint32) *big.Int{
+	mantissa:=int64(compact&0x007fffff)
+	exponent:=uint(compact>>24)
+
+	if exponent<=3{
+		return big.NewInt(mantissa>>(8*(3-exponent)))
+	}
+
+	target:=big.NewInt(mantissa)
+	return target.Lsh(target,8*(exponent-3))
+}
+
+//BigToCompact converts a target to its compact representation
+func BigToCompact(target *big.Int) uint32{
+	if target.Sign()<=0{
+		return 0
+	}
+
+	var mantissa uint32
+	exponent:=uint(len(target.Bytes()))
+
+	if exponent<=3{
+		mantissa=uint32(target.Uint64())<<(8*(3-exponent))
+	}else{
+		mantissa=uint32(new(big.Int).Rsh(target,8*(exponent-3)).Uint64())
+	}
+
+	//the mantissa is signed, keep its sign bit clear
+	if mantissa&0x00800000!=0{
+		mantissa>>=8
+		exponent++
+	}
+
+	return uint32(exponent<<24)|mantissa
+}
+
+//initialBits returns the compact target of the genesis block
+func initialBits() uint32{
+	target:=new(big.Int).Lsh(big.NewInt(1),256-initialTargetBits)
+
+	return BigToCompact(target)
+}
+
+//nextBits returns the compact target required for the block following parent.
+//Every retargetInterval blocks the target is scaled by the time the last interval took
+//compared with the desired time, limited to a factor of 4 in either direction.
+func nextBits(tx *bolt.Tx,parent *Block) (uint32,error){
+	height:=parent.Height+1
+	if height%retargetInterval!=0{
+		return parent.Bits,nil
+	}
+
+	b:=tx.Bucket([]byte(blocksBucket))
+	first:=parent
+	for first.Height>height-retargetInterval{
+		blockData:=b.Get(first.PrevBlockHash)
+		if blockData==nil{
+			return 0,errors.New("Block is not found.")
+		}
+		first=DeserializeBlock(blockData)
+	}
+
+	expected:=int64(retargetInterval)*blockInterval
+	actual:=parent.Timestamp-first.Timestamp
+	if actual<expected/4{
+		actual=expected/4
+	}
+	if actual>expected*4{
+		actual=expected*4
+	}
+	if actual<1{
+		actual=1
+	}
+
+	target:=CompactToBig(parent.Bits)
+	target.Mul(target,big.NewInt(actual))
+	target.Div(target,big.NewInt(expected))
+	if target.Cmp(powLimit)>0{
+		target.Set(powLimit)
+	}
+
+	return BigToCompact(target),nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import(
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"math/big"
+)
+
+//Keys are derived from the seed like BIP32, with the P-256 rules of SLIP-0010:
+//a derived key that isn't a valid scalar is derived again from the rest of the HMAC output
+//instead of being skipped. Wallet addresses are the children of hdAccountPath.
+
+const(
+	//hardenedKeyStart is the first index of children derived from the private key only
+	hardenedKeyStart=0x80000000
+	//hdGapLimit is how many unused addresses in a row end a scan
+	hdGapLimit=20
+)
+
+var hdMasterKeySalt=[]byte("Nist256p1 seed")
+
+//hdAccountPath is m/44'/0'/0'/0, the external chain of the first account
+var hdAccountPath=[]uint32{hardenedKeyStart+44,hardenedKeyStart,hardenedKeyStart,0}
+
+//ExtendedKey is a private key with the chain code its children are derived with
+type ExtendedKey struct{
+	Key []byte
+	ChainCode []byte
+}
+
+//NewMasterKey derives the root key of a seed
+func NewMasterKey(seed []byte) *ExtendedKey{
+	sum:=hmacSHA512(hdMasterKeySalt,seed)
+	for !validScalar(sum[:32]){
+		sum=hmacSHA512(hdMasterKeySalt,sum)
+	}
+
+	return &ExtendedKey{sum[:32],sum[32:]}
+}
+
+//Child derives the child key at index, hardened from hardenedKeyStart
+func (k *ExtendedKey) Child(index uint32) *ExtendedKey{
+	var data []byte
+	if index>=hardenedKeyStart{
+		data=append([]byte{0x00},k.Key...)
+	}else{
+		curve:=elliptic.P256()
+		x,y:=curve.ScalarBaseMult(k.Key)
+		data=elliptic.MarshalCompressed(curve,x,y)
+	}
+	data=binary.BigEndian.AppendUint32(data,index)
+
+	n:=elliptic.P256().Params().N
+	for{
+		sum:=hmacSHA512(k.ChainCode,data)
+		child:=new(big.Int).SetBytes(sum[:32])
+		if child.Cmp(n)<0{
+			child.Add(child,new(big.Int).SetBytes(k.Key))
+			child.Mod(child,n)
+			if child.Sign()!=0{
+				return &ExtendedKey{child.FillBytes(make([]byte,32)),sum[32:]}
+			}
+		}
+		data=binary.BigEndian.AppendUint32(append([]byte{0x01},sum[32:]...),index)
+	}
+}
+
+//Derive follows the path of child indexes
+func (k *ExtendedKey) Derive(path []uint32) *ExtendedKey{
+	for _,index:=range path{
+		k=k.Child(index)
+	}
+	return k
+}
+
+//Wallet returns the key pair of the extended key
+func (k *ExtendedKey) Wallet() *Wallet{
+	return newWalletFromKey(k.Key)
+}
+
+//validScalar reports whether the bytes are a private key, between 1 and the curve order
+func validScalar(key []byte) bool{
+	d:=new(big.Int).SetBytes(key)
+	return d.Sign()>0&&d.Cmp(elliptic.P256().Params().N)<0
+}
+
+func hmacSHA512(key,data []byte) []byte{
+	mac:=hmac.New(sha512.New,key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
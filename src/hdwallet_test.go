@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SLIP-0010 test vector 1 for NIST P-256
+func TestDeriveKeyVectors(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+	for _, test := range []struct {
+		path               []uint32
+		chainCode, privKey string
+	}{
+		{nil, "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+		{[]uint32{hardenedKeyStart}, "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+		{[]uint32{hardenedKeyStart, 1}, "4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c", "284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129"},
+	} {
+		key := NewMasterKey(seed).Derive(test.path)
+		assert.Equal(t, test.chainCode, hex.EncodeToString(key.ChainCode), "%x", test.path)
+		assert.Equal(t, test.privKey, hex.EncodeToString(key.Key), "%x", test.path)
+	}
+}
+
+// newAddress creates a wallet and fails the test on error
+func newAddress(t *testing.T, ws *Wallets) string {
+	address, err := ws.CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+func TestSeedWalletsAreDeterministic(t *testing.T) {
+	seed, _ := MnemonicToSeed("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about", "")
+	a, b := Wallets{Wallets: map[string]*Wallet{}}, Wallets{Wallets: map[string]*Wallet{}}
+	assert.Nil(t, a.SetSeed(seed))
+	assert.Nil(t, b.SetSeed(seed))
+	assert.NotNil(t, a.SetSeed(seed), "Seed can't be replaced")
+
+	first, second := newAddress(t, &a), newAddress(t, &a)
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, first, newAddress(t, &b))
+	assert.Equal(t, second, newAddress(t, &b))
+	assert.Equal(t, uint32(2), a.HDIndex)
+
+	w := a.GetWallet(second)
+	assert.Len(t, w.PublicKey, 33)
+	assert.True(t, verifiesSpend(&w))
+}
+
+func TestScanAddressesStopsAtGapLimit(t *testing.T) {
+	seed := []byte("scan test seed")
+	source := Wallets{Wallets: map[string]*Wallet{}}
+	source.SetSeed(seed)
+	var addresses []string
+	for i := 0; i < 8; i++ {
+		addresses = append(addresses, newAddress(t, &source))
+	}
+
+	used := map[string]bool{addresses[1]: true, addresses[4]: true, addresses[7]: true}
+	isUsed := func(script []byte) bool {
+		address, _ := ScriptToAddress(script)
+		return used[address]
+	}
+
+	restored := Wallets{Wallets: map[string]*Wallet{}}
+	restored.SetSeed(seed)
+	assert.Equal(t, []string{addresses[1]}, restored.ScanAddresses(isUsed, 2), "Gap of 2 after address 1 ends the scan")
+	assert.Equal(t, uint32(2), restored.HDIndex)
+	assert.Len(t, restored.Wallets, 2, "Unused addresses before the last used one are kept")
+
+	restored = Wallets{Wallets: map[string]*Wallet{}}
+	restored.SetSeed(seed)
+	assert.Len(t, restored.ScanAddresses(isUsed, 3), 3)
+	assert.Equal(t, uint32(8), restored.HDIndex)
+	assert.Equal(t, newAddress(t, &source), newAddress(t, &restored), "New addresses follow the scanned ones")
+}
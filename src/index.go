@@ -0,0 +1,343 @@
+package main
+
+import(
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log"
+	"sort"
+	"github.com/boltdb/bolt"
+)
+
+//Indexes of the main chain, kept up to date when blocks are connected and disconnected
+const heightIndexBucket="heightindex"
+const txIndexBucket="txindex"
+const addrIndexBucket="addrindex"
+
+var indexBuckets=[]string{heightIndexBucket,txIndexBucket,addrIndexBucket}
+
+//indexBlock adds a block connected to the main chain to the indexes
+func indexBlock(tx *bolt.Tx,block *Block) error{
+	err:=tx.Bucket([]byte(heightIndexBucket)).Put(IntToHex(int64(block.Height)),block.Hash)
+	if err!=nil{
+		return err
+	}
+
+	txs:=tx.Bucket([]byte(txIndexBucket))
+	addrs:=tx.Bucket([]byte(addrIndexBucket))
+
+	for i,transaction:=range block.Transactions{
+		location:=append(append([]byte{},block.Hash...),IntToHex(int64(i))...)
+		err=txs.Put(transaction.ID,location)
+		if err!=nil{
+			return err
+		}
+
+		scripts,err:=transaction.lockingScripts(tx)
+		if err!=nil{
+			return err
+		}
+		for _,script:=range scripts{
+			err=addrs.Put(addrIndexKey(script,transaction.ID),[]byte{})
+			if err!=nil{
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//unindexBlock removes a block disconnected from the main chain from the indexes
+func unindexBlock(tx *bolt.Tx,block *Block) error{
+	err:=tx.Bucket([]byte(heightIndexBucket)).Delete(IntToHex(int64(block.Height)))
+	if err!=nil{
+		return err
+	}
+
+	txs:=tx.Bucket([]byte(txIndexBucket))
+	addrs:=tx.Bucket([]byte(addrIndexBucket))
+
+	//Transactions are removed last to first so the outputs spent within the block can still be found
+	for i:=len(block.Transactions)-1;i>=0;i--{
+		transaction:=block.Transactions[i]
+		scripts,err:=transaction.lockingScripts(tx)
+		if err!=nil{
+			return err
+		}
+		for _,script:=range scripts{
+			err=addrs.Delete(addrIndexKey(script,transaction.ID))
+			if err!=nil{
+				return err
+			}
+		}
+
+		err=txs.Delete(transaction.ID)
+		if err!=nil{
+			return err
+		}
+	}
+	return nil
+}
+
+//addrIndexKey joins the hash of the locking script and the transaction ID so
+//all transactions of an address can be found with a prefix scan
+func addrIndexKey(script,txID []byte) []byte{
+	hash:=sha256.Sum256(script)
+
+	return append(hash[:],txID...)
+}
+
+//lockingScripts returns the locking scripts of the outputs the transaction pays or spends.
+//Spent outputs are looked up in the transaction index.
+func (tx Transaction) lockingScripts(dbTx *bolt.Tx) ([][]byte,error){
+	var scripts [][]byte
+	seen:=make(map[string]bool)
+
+	add:=func(script []byte){
+		if len(script)>0&&!seen[string(script)]{
+			seen[string(script)]=true
+			scripts=append(scripts,script)
+		}
+	}
+
+	if !tx.IsCoinbase(){
+		for _,vin:=range tx.Vin{
+			prevTx,err:=findTransaction(dbTx,vin.Txid)
+			if err!=nil{
+				return nil,err
+			}
+			if vin.Vout>=0&&vin.Vout<len(prevTx.Vout){
+				add(prevTx.Vout[vin.Vout].ScriptPubKey)
+			}
+		}
+	}
+	for _,out:=range tx.Vout{
+		add(out.ScriptPubKey)
+	}
+
+	return scripts,nil
+}
+
+//findTransaction looks up a transaction of the main chain in the index
+func findTransaction(tx *bolt.Tx,ID []byte) (Transaction,error){
+	block,i,err:=locateTransaction(tx,ID)
+	if err!=nil{
+		return Transaction{},err
+	}
+
+	return *block.Transactions[i],nil
+}
+
+//locateTransaction returns the main chain block containing a transaction and its position in the block
+func locateTransaction(tx *bolt.Tx,ID []byte) (*Block,int,error){
+	location:=tx.Bucket([]byte(txIndexBucket)).Get(ID)
+	if location==nil{
+		return nil,0,errors.New("Transaction is not found")
+	}
+
+	blockHash:=location[:len(location)-8]
+	i:=int(binary.BigEndian.Uint64(location[len(location)-8:]))
+
+	blockData:=tx.Bucket([]byte(blocksBucket)).Get(blockHash)
+	if blockData==nil{
+		return nil,0,errors.New("Transaction is not found")
+	}
+
+	return DeserializeBlock(blockData),i,nil
+}
+
+//GetBlockByHeight returns the block of the main chain at the height
+func (bc *Blockchain) GetBlockByHeight(height int) (Block,error){
+	var block Block
+
+	err:=bc.db.View(func(tx *bolt.Tx) error{
+		hash:=tx.Bucket([]byte(heightIndexBucket)).Get(IntToHex(int64(height)))
+		if hash==nil{
+			return errors.New("Block is not found.")
+		}
+
+		block=*DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(hash))
+		return nil
+	})
+
+	return block,err
+}
+
+//FindAddressTransactions returns the transactions of the main chain that pay to
+//or spend from outputs locked with the script, oldest first
+func (bc *Blockchain) FindAddressTransactions(script []byte) []Transaction{
+	var txs []Transaction
+
+	err:=bc.db.View(func(tx *bolt.Tx) error{
+		c:=tx.Bucket([]byte(addrIndexBucket)).Cursor()
+		prefix:=addrIndexKey(script,nil)
+
+		for k,_:=c.Seek(prefix);k!=nil&&bytes.HasPrefix(k,prefix);k,_=c.Next(){
+			transaction,err:=findTransaction(tx,k[len(prefix):])
+			if err!=nil{
+				return err
+			}
+			txs=append(txs,transaction)
+		}
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return txs
+}
+
+//WalletTransaction is a main chain transaction with the value it pays to and spends from a set of scripts
+type WalletTransaction struct{
+	Transaction Transaction
+	Height int
+	Received int
+	Sent int
+
+	//position is the index of the transaction in its block
+	position int
+}
+
+//FindWalletTransactions returns the transactions of the main chain that pay to or spend from
+//outputs locked with any of the scripts, oldest first
+func (bc *Blockchain) FindWalletTransactions(scripts [][]byte) []WalletTransaction{
+	var txs []WalletTransaction
+
+	locked:=make(map[string]bool)
+	for _,script:=range scripts{
+		locked[string(script)]=true
+	}
+
+	err:=bc.db.View(func(tx *bolt.Tx) error{
+		seen:=make(map[string]bool)
+		c:=tx.Bucket([]byte(addrIndexBucket)).Cursor()
+
+		for _,script:=range scripts{
+			prefix:=addrIndexKey(script,nil)
+			for k,_:=c.Seek(prefix);k!=nil&&bytes.HasPrefix(k,prefix);k,_=c.Next(){
+				ID:=k[len(prefix):]
+				if seen[string(ID)]{
+					continue
+				}
+				seen[string(ID)]=true
+
+				block,i,err:=locateTransaction(tx,ID)
+				if err!=nil{
+					return err
+				}
+				walletTx:=WalletTransaction{Transaction:*block.Transactions[i],Height:block.Height,position:i}
+
+				for _,out:=range walletTx.Transaction.Vout{
+					if locked[string(out.ScriptPubKey)]{
+						walletTx.Received+=out.Value
+					}
+				}
+				if !walletTx.Transaction.IsCoinbase(){
+					for _,vin:=range walletTx.Transaction.Vin{
+						prevTx,err:=findTransaction(tx,vin.Txid)
+						if err!=nil{
+							return err
+						}
+						if vin.Vout<len(prevTx.Vout)&&locked[string(prevTx.Vout[vin.Vout].ScriptPubKey)]{
+							walletTx.Sent+=prevTx.Vout[vin.Vout].Value
+						}
+					}
+				}
+
+				txs=append(txs,walletTx)
+			}
+		}
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	sort.Slice(txs,func(i,j int) bool{
+		if txs[i].Height!=txs[j].Height{
+			return txs[i].Height<txs[j].Height
+		}
+		return txs[i].position<txs[j].position
+	})
+
+	return txs
+}
+
+//IsScriptUsed reports whether a transaction of the main chain pays to or spends from outputs locked with the script
+func (bc *Blockchain) IsScriptUsed(script []byte) bool{
+	used:=false
+
+	err:=bc.db.View(func(tx *bolt.Tx) error{
+		prefix:=addrIndexKey(script,nil)
+		k,_:=tx.Bucket([]byte(addrIndexBucket)).Cursor().Seek(prefix)
+		used=k!=nil&&bytes.HasPrefix(k,prefix)
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return used
+}
+
+//hasIndexes reports whether the indexes have been built
+func (bc *Blockchain) hasIndexes() bool{
+	exists:=false
+
+	err:=bc.db.View(func(tx *bolt.Tx) error{
+		exists=tx.Bucket([]byte(heightIndexBucket))!=nil
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return exists
+}
+
+//Reindex rebuilds the height, transaction and address indexes and the UTXO set from the main chain
+func (bc *Blockchain) Reindex(){
+	var blocks []*Block
+	bci:=bc.Iterator()
+
+	for{
+		block:=bci.Next()
+		blocks=append(blocks,block)
+
+		if len(block.PrevBlockHash)==0{
+			break
+		}
+	}
+
+	err:=bc.db.Update(func(tx *bolt.Tx) error{
+		for _,bucket:=range indexBuckets{
+			if tx.Bucket([]byte(bucket))!=nil{
+				err:=tx.DeleteBucket([]byte(bucket))
+				if err!=nil{
+					return err
+				}
+			}
+
+			_,err:=tx.CreateBucket([]byte(bucket))
+			if err!=nil{
+				return err
+			}
+		}
+
+		for i:=len(blocks)-1;i>=0;i--{
+			err:=indexBlock(tx,blocks[i])
+			if err!=nil{
+				return err
+			}
+		}
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	UTXOSet:=UTXOSet{bc}
+	UTXOSet.Reindex()
+}
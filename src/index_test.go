@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexesAreRebuiltByReindex(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	minerAddress := string(miner.GetAddress())
+	other := NewWallet()
+	prev, vout := bc.Iterator().Next().Transactions[0], 0
+
+	blocks := []*Block{bc.Iterator().Next()}
+	var txs []*Transaction
+	for i := 1; i <= 3; i++ {
+		tx := spend(miner, prev, vout, string(other.GetAddress()), 1, 1)
+		block, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy+1), tx})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+		txs = append(txs, tx)
+		prev, vout = tx, 1
+	}
+
+	check := func(message string) {
+		for height, block := range blocks {
+			found, err := bc.GetBlockByHeight(height)
+			assert.Nil(t, err, message)
+			assert.Equal(t, block.Hash, found.Hash, message)
+
+			for _, tx := range block.Transactions {
+				found, err := bc.FindTransaction(tx.ID)
+				assert.Nil(t, err, message)
+				assert.Equal(t, tx.Serialize(), found.Serialize(), message)
+			}
+		}
+		_, err := bc.GetBlockByHeight(len(blocks))
+		assert.NotNil(t, err, message)
+		_, err = bc.FindTransaction(testHash("unknown"))
+		assert.NotNil(t, err, message)
+
+		var paid [][]byte
+		for _, tx := range bc.FindAddressTransactions(other.LockingScript()) {
+			paid = append(paid, tx.ID)
+		}
+		assert.ElementsMatch(t, [][]byte{txs[0].ID, txs[1].ID, txs[2].ID}, paid, message)
+	}
+
+	check("Indexes are updated when blocks are connected")
+	utxos := utxoSnapshot(t, bc)
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(txIndexBucket))
+	})
+	assert.Nil(t, err)
+	bc.Reindex()
+	check("Reindex rebuilds the same indexes")
+	assert.Equal(t, utxos, utxoSnapshot(t, bc), "Reindex rebuilds the same UTXO set")
+}
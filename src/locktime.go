@@ -0,0 +1,105 @@
+package main
+
+import(
+	"fmt"
+	"log"
+	"time"
+	"github.com/boltdb/bolt"
+)
+
+//A transaction can't be mined before its LockTime. A LockTime below lockTimeThreshold
+//is the last block height the transaction is locked for, otherwise it is a Unix timestamp
+//that the previous block's timestamp must pass. Zero disables the lock, and so does
+//maxSequence on every input.
+//
+//An input whose Sequence doesn't have sequenceDisableFlag set is also locked relative to
+//the output it spends: for the number of blocks in the low 16 bits after the block that
+//created the output, or, with sequenceTypeFlag, for that many units of 512 seconds after
+//the output's block timestamp.
+
+const(
+	lockTimeThreshold=500000000
+	maxSequence=0xffffffff
+	sequenceDisableFlag=1<<31
+	sequenceTypeFlag=1<<22
+	sequenceMask=0xffff
+	sequenceGranularity=9
+)
+
+//IsFinal reports whether the locktime of the transaction has passed for a block at height
+//whose previous block has the timestamp parentTime
+func (tx *Transaction) IsFinal(height int,parentTime int64) bool{
+	if tx.LockTime==0{
+		return true
+	}
+	if tx.LockTime<lockTimeThreshold&&int64(height)>int64(tx.LockTime){
+		return true
+	}
+	if tx.LockTime>=lockTimeThreshold&&parentTime>int64(tx.LockTime){
+		return true
+	}
+
+	for _,vin:=range tx.Vin{
+		if vin.Sequence!=maxSequence{
+			return false
+		}
+	}
+	return true
+}
+
+//LockTimeString describes until when a locktime holds
+func LockTimeString(lockTime uint32) string{
+	if lockTime<lockTimeThreshold{
+		return fmt.Sprintf("until block %d is mined",lockTime)
+	}
+	return fmt.Sprintf("until a block later than %s is mined",time.Unix(int64(lockTime),0).UTC().Format(time.RFC3339))
+}
+
+//IsFinal reports whether the locktime of the transaction allows it in the next block
+func (bc *Blockchain) IsFinal(tx *Transaction) bool{
+	final:=false
+
+	err:=bc.db.View(func(dbTx *bolt.Tx) error{
+		height:=bestHeight(dbTx)+1
+		final=tx.IsFinal(height,blockTimeAt(dbTx,height-1))
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return final
+}
+
+//sequenceLocked reports whether the relative lock of an input spending an output created at outHeight
+//still holds for a block at height whose previous block has the timestamp parentTime.
+//Outputs that aren't in the main chain yet are taken as created by the block at height.
+func sequenceLocked(tx *bolt.Tx,sequence uint32,outHeight,height int,parentTime int64) bool{
+	if sequence&sequenceDisableFlag!=0{
+		return false
+	}
+
+	value:=int64(sequence&sequenceMask)
+	if sequence&sequenceTypeFlag==0{
+		return int64(height-outHeight)<value
+	}
+
+	outTime:=parentTime
+	if outHeight<height{
+		outTime=blockTimeAt(tx,outHeight)
+	}
+	return parentTime<outTime+value<<sequenceGranularity
+}
+
+//blockTimeAt returns the timestamp of the main chain block at height, or 0 below the genesis block
+func blockTimeAt(tx *bolt.Tx,height int) int64{
+	if height<0{
+		return 0
+	}
+
+	hash:=tx.Bucket([]byte(heightIndexBucket)).Get(IntToHex(int64(height)))
+	if hash==nil{
+		return 0
+	}
+	return DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(hash)).Timestamp
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lock sets the locktime and the sequence of the transaction and signs it again
+func lock(tx *Transaction, from *Wallet, prev *Transaction, lockTime, sequence uint32) *Transaction {
+	tx.LockTime = lockTime
+	tx.Vin[0].Sequence = sequence
+	tx.ID = tx.Hash()
+	tx.Sign(from, map[string]Transaction{hex.EncodeToString(prev.ID): *prev})
+	return tx
+}
+
+func TestIsFinal(t *testing.T) {
+	tx := Transaction{nil, []TXInput{{[]byte{1}, 0, nil, maxSequence - 1}}, nil, 0}
+	assert.True(t, tx.IsFinal(0, 0), "Zero locktime is final")
+
+	tx.LockTime = 10
+	assert.False(t, tx.IsFinal(10, 0), "Locked until block 10 is mined")
+	assert.True(t, tx.IsFinal(11, 0))
+
+	tx.LockTime = lockTimeThreshold + 100
+	assert.False(t, tx.IsFinal(1000, lockTimeThreshold+100), "Previous block must be later than the locktime")
+	assert.True(t, tx.IsFinal(1000, lockTimeThreshold+101))
+
+	tx.Vin[0].Sequence = maxSequence
+	assert.True(t, tx.IsFinal(0, 0), "Final sequences disable the locktime")
+}
+
+func TestSequenceLocked(t *testing.T) {
+	assert.True(t, sequenceLocked(nil, 2, 5, 6, 0), "Locked for 2 blocks after block 5")
+	assert.False(t, sequenceLocked(nil, 2, 5, 7, 0))
+	assert.False(t, sequenceLocked(nil, sequenceDisableFlag|2, 5, 6, 0), "Disabled relative lock")
+	assert.True(t, sequenceLocked(nil, sequenceTypeFlag|1, 6, 6, 1000), "Output of the same block is locked for 512 seconds")
+	assert.False(t, sequenceLocked(nil, sequenceTypeFlag, 6, 6, 1000))
+}
+
+func TestLockTimeIsEnforced(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	minerAddress := string(miner.GetAddress())
+	other := NewWallet()
+
+	locked := lock(spend(miner, genesis, 0, string(other.GetAddress()), 5, 1), miner, genesis, 1, maxSequence-1)
+	assert.False(t, bc.IsFinal(locked))
+	assert.True(t, errors.Is(NewMempool(maxMempoolSize, mempoolExpiry).Add(locked, bc), ErrNonFinal), "Mempool rejects locked transactions")
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy+1), locked})
+	assert.True(t, errors.Is(err, ErrNonFinal), "Locked transaction can't be mined")
+
+	mineCoinbase(t, bc, miner)
+	assert.True(t, bc.IsFinal(locked))
+	assert.Nil(t, NewMempool(maxMempoolSize, mempoolExpiry).Add(locked, bc))
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy+1), locked})
+	assert.Nil(t, err)
+}
+
+func TestSequenceLockIsEnforced(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	minerAddress := string(miner.GetAddress())
+	other := NewWallet()
+
+	locked := lock(spend(miner, genesis, 0, string(other.GetAddress()), 5, 1), miner, genesis, 0, 2)
+	assert.True(t, errors.Is(NewMempool(maxMempoolSize, mempoolExpiry).Add(locked, bc), ErrSequenceLocked), "Mempool rejects locked inputs")
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy+1), locked})
+	assert.True(t, errors.Is(err, ErrSequenceLocked), "Output is locked for 2 blocks")
+
+	mineCoinbase(t, bc, miner)
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy+1), locked})
+	assert.Nil(t, err)
+}
+
+func TestLockTimeScripts(t *testing.T) {
+	tx := spendOutput(fundScript(nil))
+	tx.Vin[0].ScriptSig = []byte{Op1}
+	cltv := []byte{0x01, 0x0a, OpCheckLockTimeVerify, OpDrop}
+	csv := []byte{0x01, 0x03, OpCheckSequenceVerify, OpDrop}
+
+	for _, test := range []struct {
+		lockTime, sequence uint32
+		script             []byte
+		err                error
+		name               string
+	}{
+		{10, 0, cltv, nil, "Locktime reaches the script's"},
+		{9, 0, cltv, ErrScriptFailed, "Locktime is before the script's"},
+		{lockTimeThreshold, 0, cltv, ErrScriptFailed, "Time can't satisfy a height"},
+		{10, maxSequence, cltv, ErrScriptFailed, "Locktime is disabled"},
+		{0, 3, csv, nil, "Sequence reaches the script's"},
+		{0, 2, csv, ErrScriptFailed, "Sequence is before the script's"},
+		{0, sequenceTypeFlag | 3, csv, ErrScriptFailed, "Time can't satisfy blocks"},
+		{0, sequenceDisableFlag, csv, ErrScriptFailed, "Relative lock is disabled"},
+	} {
+		tx.LockTime = test.lockTime
+		tx.Vin[0].Sequence = test.sequence
+		err := tx.VerifyInput(0, TXOutput{10, test.script})
+		if test.err == nil {
+			assert.Nil(t, err, test.name)
+		} else {
+			assert.True(t, errors.Is(err, test.err), "%s: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import(
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"github.com/boltdb/bolt"
+)
+
+//maxMempoolSize is the total serialized size of the transactions a node keeps pending
+const maxMempoolSize=4<<20
+
+//maxBlockTemplateSize is the total serialized size of the pending transactions a miner puts in one block
+const maxBlockTemplateSize=1<<20
+
+//mempoolExpiry is how long a transaction may wait in the mempool
+const mempoolExpiry=72*time.Hour
+
+//Errors returned when a transaction isn't accepted into the mempool
+var(
+	ErrTxKnown=errors.New("transaction is already known")
+	ErrMempoolConflict=errors.New("transaction spends an output spent by a pending transaction")
+	ErrMempoolFull=errors.New("mempool is full and the transaction fee rate is too low")
+)
+
+//mempoolEntry is a pending transaction with the data used to order and evict it
+type mempoolEntry struct{
+	tx *Transaction
+	fee int
+	size int
+	added time.Time
+	//depends holds the IDs of the pending transactions whose outputs it spends
+	depends map[string]bool
+}
+
+//feeRateLess reports whether a pays a lower fee per byte than b
+func (a *mempoolEntry) feeRateLess(b *mempoolEntry) bool{
+	return a.fee*b.size<b.fee*a.size
+}
+
+//Mempool holds valid transactions waiting to be mined.
+//Its transactions spend unspent outputs of the main chain or outputs of other
+//pending transactions, and no output is spent twice.
+type Mempool struct{
+	mutex sync.Mutex
+	entries map[string]*mempoolEntry
+	//spends maps an outpoint to the ID of the pending transaction spending it
+	spends map[string]string
+	//children maps the ID of a pending transaction to the IDs of the pending transactions spending its outputs
+	children map[string]map[string]bool
+	size int
+	maxSize int
+	expiry time.Duration
+}
+
+//NewMempool creates a mempool limited to maxSize bytes that drops transactions older than expiry
+func NewMempool(maxSize int,expiry time.Duration) *Mempool{
+	return &Mempool{
+		entries:make(map[string]*mempoolEntry),
+		spends:make(map[string]string),
+		children:make(map[string]map[string]bool),
+		maxSize:maxSize,
+		expiry:expiry}
+}
+
+func outpoint(txid []byte,vout int) string{
+	return fmt.Sprintf("%x:%d",txid,vout)
+}
+
+//Add validates the transaction against the main chain and the pending transactions and adds it
+func (mp *Mempool) Add(tx *Transaction,bc *Blockchain) error{
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	mp.expire(time.Now())
+
+	err:=mp.add(tx,bc,time.Now())
+	if err!=nil{
+		return err
+	}
+
+	mp.trim()
+	if _,ok:=mp.entries[hex.EncodeToString(tx.ID)];!ok{
+		return &TxError{tx.ID,ErrMempoolFull}
+	}
+	return nil
+}
+
+func (mp *Mempool) add(tx *Transaction,bc *Blockchain,added time.Time) error{
+	txID:=hex.EncodeToString(tx.ID)
+	if _,ok:=mp.entries[txID];ok{
+		return &TxError{tx.ID,ErrTxKnown}
+	}
+
+	err:=checkTransaction(tx)
+	if err!=nil{
+		return err
+	}
+	if tx.IsCoinbase(){
+		return &TxError{tx.ID,ErrBadTransaction}
+	}
+
+	entry:=&mempoolEntry{tx:tx,size:len(tx.Serialize()),added:added,depends:make(map[string]bool)}
+
+	err=bc.db.View(func(dbTx *bolt.Tx) error{
+		if _,err:=findTransaction(dbTx,tx.ID);err==nil{
+			return &TxError{tx.ID,ErrTxKnown}
+		}
+
+		utxos:=dbTx.Bucket([]byte(utxoBucket))
+		height:=bestHeight(dbTx)+1
+		parentTime:=blockTimeAt(dbTx,height-1)
+		if !tx.IsFinal(height,parentTime){
+			return &TxError{tx.ID,ErrNonFinal}
+		}
+		prevTXs:=make(map[string]Transaction)
+		spent:=make(map[string]bool)
+		inputValue:=0
+
+		for _,vin:=range tx.Vin{
+			prevID:=hex.EncodeToString(vin.Txid)
+			point:=outpoint(vin.Txid,vin.Vout)
+			if spent[point]{
+				return &TxError{tx.ID,ErrDoubleSpend}
+			}
+			spent[point]=true
+			if _,ok:=mp.spends[point];ok{
+				return &TxError{tx.ID,ErrMempoolConflict}
+			}
+
+			if parent,ok:=mp.entries[prevID];ok{
+				if vin.Vout<0||vin.Vout>=len(parent.tx.Vout){
+					return &TxError{tx.ID,ErrMissingInput}
+				}
+				if sequenceLocked(dbTx,vin.Sequence,height,height,parentTime){
+					return &TxError{tx.ID,ErrSequenceLocked}
+				}
+				var ok bool
+				inputValue,ok=addMoney(inputValue,parent.tx.Vout[vin.Vout].Value)
+				if !ok{
+					return &TxError{tx.ID,ErrValueOverflow}
+				}
+				prevTXs[prevID]=*parent.tx
+				entry.depends[prevID]=true
+				continue
+			}
+
+			prevTx,err:=findTransaction(dbTx,vin.Txid)
+			if err!=nil{
+				return &TxError{tx.ID,ErrMissingInput}
+			}
+			outs,_:=findOutputs(utxos,vin.Txid)
+			out,ok:=outs.Outputs[vin.Vout]
+			if !ok{
+				if vin.Vout<0||vin.Vout>=len(prevTx.Vout){
+					return &TxError{tx.ID,ErrMissingInput}
+				}
+				return &TxError{tx.ID,ErrDoubleSpend}
+			}
+			if !outs.IsMature(height){
+				return &TxError{tx.ID,ErrImmatureSpend}
+			}
+			if sequenceLocked(dbTx,vin.Sequence,outs.Height,height,parentTime){
+				return &TxError{tx.ID,ErrSequenceLocked}
+			}
+			inputValue,ok=addMoney(inputValue,out.Value)
+			if !ok{
+				return &TxError{tx.ID,ErrValueOverflow}
+			}
+			prevTXs[prevID]=prevTx
+		}
+
+		var err error
+		entry.fee,err=txFee(tx,inputValue)
+		if err!=nil{
+			return err
+		}
+
+		if !tx.Verify(prevTXs){
+			return &TxError{tx.ID,ErrBadSignature}
+		}
+		return nil
+	})
+	if err!=nil{
+		return err
+	}
+
+	mp.entries[txID]=entry
+	for _,vin:=range tx.Vin{
+		mp.spends[outpoint(vin.Txid,vin.Vout)]=txID
+	}
+	for parent:=range entry.depends{
+		if mp.children[parent]==nil{
+			mp.children[parent]=make(map[string]bool)
+		}
+		mp.children[parent][txID]=true
+	}
+	mp.size+=entry.size
+	return nil
+}
+
+//remove deletes a transaction and the pending transactions spending its outputs
+func (mp *Mempool) remove(txID string){
+	entry,ok:=mp.entries[txID]
+	if !ok{
+		return
+	}
+
+	delete(mp.entries,txID)
+	for _,vin:=range entry.tx.Vin{
+		delete(mp.spends,outpoint(vin.Txid,vin.Vout))
+	}
+	for parent:=range entry.depends{
+		delete(mp.children[parent],txID)
+		if len(mp.children[parent])==0{
+			delete(mp.children,parent)
+		}
+	}
+	mp.size-=entry.size
+
+	children:=mp.children[txID]
+	delete(mp.children,txID)
+	for child:=range children{
+		mp.remove(child)
+	}
+}
+
+//expire drops transactions that waited longer than the expiry
+func (mp *Mempool) expire(now time.Time){
+	for txID,entry:=range mp.entries{
+		if now.Sub(entry.added)>mp.expiry{
+			mp.remove(txID)
+		}
+	}
+}
+
+//trim evicts the transactions with the lowest fee rate that nothing depends on until the mempool fits its size limit
+func (mp *Mempool) trim(){
+	for mp.size>mp.maxSize{
+		var lowest string
+		for txID,entry:=range mp.entries{
+			if len(mp.children[txID])>0{
+				continue
+			}
+			if lowest==""||entry.feeRateLess(mp.entries[lowest]){
+				lowest=txID
+			}
+		}
+		mp.remove(lowest)
+	}
+}
+
+//Remove drops a pending transaction and the pending transactions spending its outputs
+func (mp *Mempool) Remove(ID []byte){
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	mp.remove(hex.EncodeToString(ID))
+}
+
+//Get returns a pending transaction
+func (mp *Mempool) Get(ID []byte) (Transaction,bool){
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	entry,ok:=mp.entries[hex.EncodeToString(ID)]
+	if !ok{
+		return Transaction{},false
+	}
+	return *entry.tx,true
+}
+
+//Count returns the number of pending transactions
+func (mp *Mempool) Count() int{
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	return len(mp.entries)
+}
+
+//Size returns the total serialized size of the pending transactions
+func (mp *Mempool) Size() int{
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	return mp.size
+}
+
+//Transactions returns the pending transactions ordered by fee rate, highest first,
+//and the sum of their fees.
+//A transaction always comes after the pending transactions it spends from,
+//so any prefix of the list can be mined.
+func (mp *Mempool) Transactions() ([]*Transaction,int){
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	var txs []*Transaction
+	fees:=0
+	for _,entry:=range mp.sorted(){
+		txs=append(txs,entry.tx)
+		fees+=entry.fee
+	}
+	return txs,fees
+}
+
+//BlockTemplate revalidates the pending transactions against the main chain, dropping those that became invalid,
+//and returns the transactions of the next block and the sum of their fees. They are taken by fee rate like
+//Transactions, up to maxSize bytes; a transaction that doesn't fit is left out with those spending from it.
+func (mp *Mempool) BlockTemplate(bc *Blockchain,maxSize int) ([]*Transaction,int){
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	mp.revalidate(bc)
+
+	var txs []*Transaction
+	fees,size:=0,0
+	skipped:=make(map[string]bool)
+	for _,entry:=range mp.sorted(){
+		skip:=size+entry.size>maxSize
+		for parent:=range entry.depends{
+			skip=skip||skipped[parent]
+		}
+		if skip{
+			skipped[hex.EncodeToString(entry.tx.ID)]=true
+			continue
+		}
+
+		txs=append(txs,entry.tx)
+		fees+=entry.fee
+		size+=entry.size
+	}
+	return txs,fees
+}
+
+//sorted returns the entries by fee rate with every transaction after its dependencies
+func (mp *Mempool) sorted() []*mempoolEntry{
+	var pending []*mempoolEntry
+	for _,entry:=range mp.entries{
+		pending=append(pending,entry)
+	}
+	sort.Slice(pending,func(i,j int) bool{
+		if pending[j].feeRateLess(pending[i]){
+			return true
+		}
+		if pending[i].feeRateLess(pending[j]){
+			return false
+		}
+		return pending[i].added.Before(pending[j].added)
+	})
+
+	var result []*mempoolEntry
+	included:=make(map[string]bool)
+	for len(pending)>0{
+		var rest []*mempoolEntry
+		for _,entry:=range pending{
+			ready:=true
+			for parent:=range entry.depends{
+				ready=ready&&included[parent]
+			}
+
+			if ready{
+				result=append(result,entry)
+				included[hex.EncodeToString(entry.tx.ID)]=true
+			}else{
+				rest=append(rest,entry)
+			}
+		}
+		pending=rest
+	}
+	return result
+}
+
+//Update drops the transactions that were mined, conflict with the main chain
+//or spend a coinbase that isn't mature anymore after a reorganization.
+//It is called after the tip changes; the rest are revalidated against the new chain.
+func (mp *Mempool) Update(bc *Blockchain){
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	mp.revalidate(bc)
+}
+
+//revalidate adds the entries again in mining order, those that aren't valid anymore are dropped
+func (mp *Mempool) revalidate(bc *Blockchain){
+	entries:=mp.sorted()
+	mp.entries=make(map[string]*mempoolEntry)
+	mp.spends=make(map[string]string)
+	mp.children=make(map[string]map[string]bool)
+	mp.size=0
+
+	for _,entry:=range entries{
+		mp.add(entry.tx,bc,entry.added)
+	}
+}
+
+//mempoolInfo describes a pending transaction
+type mempoolInfo struct{
+	ID string `json:"txid"`
+	Fee int `json:"fee"`
+	Size int `json:"size"`
+	Time int64 `json:"time"`
+	Depends []string `json:"depends"`
+}
+
+//Info describes the pending transactions in the order they would be mined
+func (mp *Mempool) Info() []mempoolInfo{
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	infos:=[]mempoolInfo{}
+	for _,entry:=range mp.sorted(){
+		info:=mempoolInfo{
+			ID:hex.EncodeToString(entry.tx.ID),
+			Fee:entry.fee,
+			Size:entry.size,
+			Time:entry.added.Unix(),
+			Depends:[]string{}}
+		for parent:=range entry.depends{
+			info.Depends=append(info.Depends,parent)
+		}
+		sort.Strings(info.Depends)
+		infos=append(infos,info)
+	}
+	return infos
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spend creates a signed transaction paying amount of an output of prev to the address and fee to the miner
+func spend(from *Wallet, prev *Transaction, vout int, to string, amount, fee int) *Transaction {
+	inputs := []TXInput{{prev.ID, vout, nil, 0}}
+	outputs := []TXOutput{*NewTXOutput(amount, to)}
+	if change := prev.Vout[vout].Value - amount - fee; change > 0 {
+		outputs = append(outputs, *NewTXOutput(change, string(from.GetAddress())))
+	}
+
+	tx := Transaction{nil, inputs, outputs, 0}
+	tx.ID = tx.Hash()
+	tx.Sign(from, map[string]Transaction{hex.EncodeToString(prev.ID): *prev})
+	return &tx
+}
+
+// mineCoinbase mines a block paying the subsidy to the wallet and returns its coinbase
+func mineCoinbase(t *testing.T, bc *Blockchain, wallet *Wallet) *Transaction {
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress()), "", subsidy)
+	_, err := bc.MineBlock(context.Background(), []*Transaction{coinbase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coinbase
+}
+
+func TestMempoolAdd(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	other := NewWallet()
+	mp := NewMempool(maxMempoolSize, mempoolExpiry)
+
+	tx := spend(miner, genesis, 0, string(other.GetAddress()), 4, 1)
+	assert.Nil(t, mp.Add(tx, bc), "Valid transaction is accepted")
+	assert.True(t, errors.Is(mp.Add(tx, bc), ErrTxKnown), "Transaction is only added once")
+
+	conflict := spend(miner, genesis, 0, string(other.GetAddress()), 5, 1)
+	assert.True(t, errors.Is(mp.Add(conflict, bc), ErrMempoolConflict), "Pending output can't be spent twice")
+
+	child := spend(other, tx, 0, string(miner.GetAddress()), 2, 2)
+	assert.Nil(t, mp.Add(child, bc), "Output of a pending transaction can be spent")
+
+	missing := spend(other, tx, 0, string(miner.GetAddress()), 2, 2)
+	missing.Vin[0].Vout = 5
+	missing.ID = missing.Hash()
+	assert.True(t, errors.Is(mp.Add(missing, bc), ErrMissingInput), "Unknown output can't be spent")
+
+	greedy := spend(miner, genesis, 0, string(other.GetAddress()), subsidy+1, 0)
+	assert.NotNil(t, mp.Add(greedy, bc), "Outputs can't exceed inputs")
+
+	assert.Equal(t, 2, mp.Count())
+	info := mp.Info()
+	assert.Equal(t, hex.EncodeToString(tx.ID), info[0].ID)
+	assert.Equal(t, 1, info[0].Fee)
+	assert.Equal(t, 2, info[1].Fee)
+	assert.Equal(t, []string{hex.EncodeToString(tx.ID)}, info[1].Depends, "Child depends on its parent")
+}
+
+func TestMempoolOrdersByFeeRate(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	coinbase := mineCoinbase(t, bc, miner)
+	other := NewWallet()
+	mp := NewMempool(maxMempoolSize, mempoolExpiry)
+
+	cheap := spend(miner, genesis, 0, string(other.GetAddress()), 5, 1)
+	rich := spend(miner, coinbase, 0, string(other.GetAddress()), 5, 3)
+	child := spend(other, cheap, 0, string(other.GetAddress()), 1, 4)
+	for _, tx := range []*Transaction{cheap, rich, child} {
+		assert.Nil(t, mp.Add(tx, bc))
+	}
+
+	txs, fees := mp.Transactions()
+	assert.Equal(t, rich.ID, txs[0].ID, "Highest fee rate comes first")
+	assert.Equal(t, cheap.ID, txs[1].ID, "Parent comes before its child")
+	assert.Equal(t, child.ID, txs[2].ID)
+	assert.Equal(t, 8, fees)
+}
+
+func TestMempoolEvictsLowestFeeRate(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	coinbase := mineCoinbase(t, bc, miner)
+	other := NewWallet()
+
+	cheap := spend(miner, genesis, 0, string(other.GetAddress()), 5, 1)
+	rich := spend(miner, coinbase, 0, string(other.GetAddress()), 5, 3)
+	mp := NewMempool(len(rich.Serialize())+16, mempoolExpiry)
+
+	assert.Nil(t, mp.Add(cheap, bc))
+	assert.Nil(t, mp.Add(rich, bc), "Higher fee rate replaces the cheapest transaction")
+	_, ok := mp.Get(cheap.ID)
+	assert.False(t, ok, "Cheapest transaction is evicted")
+
+	cheaper := spend(miner, genesis, 0, string(other.GetAddress()), 6, 1)
+	assert.True(t, errors.Is(mp.Add(cheaper, bc), ErrMempoolFull), "Low fee rate isn't accepted into a full mempool")
+	assert.Equal(t, 1, mp.Count())
+	assert.True(t, mp.Size() <= len(rich.Serialize())+16)
+}
+
+func TestMempoolExpiry(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	mp := NewMempool(maxMempoolSize, time.Hour)
+
+	tx := spend(miner, genesis, 0, string(NewWallet().GetAddress()), 5, 1)
+	assert.Nil(t, mp.Add(tx, bc))
+
+	mp.expire(time.Now().Add(2 * time.Hour))
+	assert.Equal(t, 0, mp.Count(), "Old transactions are dropped")
+	assert.Equal(t, 0, mp.Size())
+}
+
+func TestMempoolRemovesDescendants(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	other := NewWallet()
+	mp := NewMempool(maxMempoolSize, mempoolExpiry)
+
+	parent := spend(miner, genesis, 0, string(other.GetAddress()), 6, 1)
+	child := spend(other, parent, 0, string(other.GetAddress()), 4, 1)
+	grandchild := spend(other, child, 0, string(miner.GetAddress()), 2, 1)
+	sibling := spend(miner, parent, 1, string(other.GetAddress()), 2, 1)
+	for _, tx := range []*Transaction{parent, child, grandchild, sibling} {
+		assert.Nil(t, mp.Add(tx, bc))
+	}
+	assert.Len(t, mp.children[hex.EncodeToString(parent.ID)], 2)
+
+	mp.Remove(child.ID)
+	assert.Equal(t, 2, mp.Count(), "Descendants are removed with a transaction")
+	_, ok := mp.Get(grandchild.ID)
+	assert.False(t, ok)
+	assert.Equal(t, map[string]map[string]bool{hex.EncodeToString(parent.ID): {hex.EncodeToString(sibling.ID): true}}, mp.children)
+
+	mp.Remove(parent.ID)
+	assert.Equal(t, 0, mp.Count())
+	assert.Equal(t, 0, mp.Size())
+	assert.Empty(t, mp.spends)
+	assert.Empty(t, mp.children)
+}
+
+func TestMempoolUpdateRemovesMinedTransactions(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	other := NewWallet()
+	mp := NewMempool(maxMempoolSize, mempoolExpiry)
+
+	tx := spend(miner, genesis, 0, string(other.GetAddress()), 5, 1)
+	child := spend(other, tx, 0, string(miner.GetAddress()), 3, 1)
+	assert.Nil(t, mp.Add(tx, bc))
+	assert.Nil(t, mp.Add(child, bc))
+
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(miner.GetAddress()), "", subsidy), tx})
+	assert.Nil(t, err)
+	mp.Update(bc)
+
+	_, ok := mp.Get(tx.ID)
+	assert.False(t, ok, "Mined transaction leaves the mempool")
+	_, ok = mp.Get(child.ID)
+	assert.True(t, ok, "Child of a mined transaction stays")
+	assert.Empty(t, mp.Info()[0].Depends, "Child no longer depends on a pending transaction")
+}
+
+func TestMempoolBlockTemplate(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	coinbase := mineCoinbase(t, bc, miner)
+	other := NewWallet()
+	mp := NewMempool(maxMempoolSize, mempoolExpiry)
+
+	cheap := spend(miner, genesis, 0, string(other.GetAddress()), 5, 1)
+	rich := spend(miner, coinbase, 0, string(other.GetAddress()), 5, 3)
+	child := spend(other, cheap, 0, string(other.GetAddress()), 1, 4)
+	for _, tx := range []*Transaction{cheap, rich, child} {
+		assert.Nil(t, mp.Add(tx, bc))
+	}
+
+	txs, fees := mp.BlockTemplate(bc, len(rich.Serialize())+len(cheap.Serialize())-1)
+	assert.Equal(t, []*Transaction{rich}, txs, "Transactions that don't fit are left out with their children")
+	assert.Equal(t, 3, fees)
+
+	conflict := spend(miner, coinbase, 0, string(miner.GetAddress()), 2, 0)
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(miner.GetAddress()), "", subsidy), conflict})
+	assert.Nil(t, err)
+
+	txs, fees = mp.BlockTemplate(bc, maxBlockTemplateSize)
+	assert.Equal(t, []*Transaction{cheap, child}, txs, "Transactions spending outputs of the chain that were spent are left out")
+	assert.Equal(t, 5, fees)
+	_, ok := mp.Get(rich.ID)
+	assert.False(t, ok, "Invalid transaction leaves the mempool")
+
+	mp.Remove(cheap.ID)
+	assert.Equal(t, 0, mp.Count(), "Removing a transaction removes its children")
+}
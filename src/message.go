@@ -0,0 +1,250 @@
+package main
+
+import(
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//networkMagic starts every message so peers of another network or protocol are detected
+const networkMagic uint32=0xf9beb4d9
+
+//headerLength is the size of magic, command, payload length and checksum
+const headerLength=4+commandLength+4+addressChecksumLen
+
+//maxPayloadSize limits the payload of a single message
+const maxPayloadSize=4<<20
+
+//maxAddrCount limits the number of addresses in an addr message
+const maxAddrCount=1000
+
+//Errors returned when a message frame or payload is malformed
+var(
+	ErrBadMagic=errors.New("message has a wrong network magic")
+	ErrBadCommand=errors.New("message command is malformed")
+	ErrMessageTooLarge=errors.New("message payload exceeds size limit")
+	ErrBadChecksum=errors.New("message checksum does not match payload")
+	ErrBadPayload=errors.New("message payload has invalid fields")
+)
+
+//MessageError reports a message the peer shouldn't have sent and how much it adds to its misbehavior score
+type MessageError struct{
+	Command string
+	Score int
+	Err error
+}
+
+func (e *MessageError) Error() string{
+	return fmt.Sprintf("bad %s message: %s",e.Command,e.Err)
+}
+
+//Unwrap returns the reason the message was rejected
+func (e *MessageError) Unwrap() error{
+	return e.Err
+}
+
+//writeMessage writes a message frame: magic, command, payload length, checksum and payload.
+//The checksum is the double SHA-256 prefix also used by addresses.
+func writeMessage(w io.Writer,command string,payload []byte) error{
+	if len(command)==0||len(command)>commandLength{
+		return ErrBadCommand
+	}
+	if len(payload)>maxPayloadSize{
+		return ErrMessageTooLarge
+	}
+
+	frame:=make([]byte,headerLength,headerLength+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4],networkMagic)
+	copy(frame[4:4+commandLength],commandToBytes(command))
+	binary.BigEndian.PutUint32(frame[4+commandLength:8+commandLength],uint32(len(payload)))
+	copy(frame[8+commandLength:],checksum(payload))
+	frame=append(frame,payload...)
+
+	_,err:=w.Write(frame)
+	return err
+}
+
+//readMessage reads a message frame and returns its command and payload.
+//The payload is only read after the header has been checked.
+func readMessage(r io.Reader) (string,[]byte,error){
+	header:=make([]byte,headerLength)
+
+	_,err:=io.ReadFull(r,header)
+	if err!=nil{
+		return "",nil,err
+	}
+
+	if binary.BigEndian.Uint32(header[0:4])!=networkMagic{
+		return "",nil,ErrBadMagic
+	}
+
+	command,err:=parseCommand(header[4:4+commandLength])
+	if err!=nil{
+		return "",nil,err
+	}
+
+	length:=binary.BigEndian.Uint32(header[4+commandLength:8+commandLength])
+	if length>maxPayloadSize{
+		return "",nil,fmt.Errorf("%s: %w",command,ErrMessageTooLarge)
+	}
+
+	payload:=make([]byte,length)
+	_,err=io.ReadFull(r,payload)
+	if err!=nil{
+		if err==io.EOF{
+			err=io.ErrUnexpectedEOF
+		}
+		return "",nil,err
+	}
+
+	if !bytes.Equal(checksum(payload),header[8+commandLength:]){
+		return "",nil,fmt.Errorf("%s: %w",command,ErrBadChecksum)
+	}
+
+	return command,payload,nil
+}
+
+//parseCommand reads a zero padded command, it must be printable ASCII
+func parseCommand(data []byte) (string,error){
+	end:=bytes.IndexByte(data,0)
+	if end==-1{
+		end=len(data)
+	}
+	if end==0{
+		return "",ErrBadCommand
+	}
+
+	for i,b:=range data{
+		if i<end&&(b<0x20||b>0x7e){
+			return "",ErrBadCommand
+		}
+		if i>=end&&b!=0x0{
+			return "",ErrBadCommand
+		}
+	}
+
+	return bytesToCommand(data),nil
+}
+
+//decodePayload decodes a gob encoded payload into target.
+//Blocks and transactions inside payloads use the canonical encoding.
+func decodePayload(command string,data []byte,target interface{}) error{
+	err:=gob.NewDecoder(bytes.NewReader(data)).Decode(target)
+	if err!=nil{
+		return &MessageError{command,malformedMessageScore,err}
+	}
+	return nil
+}
+
+//badPayload reports a payload that decodes but has invalid fields
+func badPayload(command,reason string) error{
+	return &MessageError{command,malformedMessageScore,fmt.Errorf("%w: %s",ErrBadPayload,reason)}
+}
+
+//isHash reports whether data has the length of a block hash or transaction ID
+func isHash(data []byte) bool{
+	return len(data)==32
+}
+
+func decodeVersion(request []byte) (verzion,error){
+	var payload verzion
+
+	err:=decodePayload("version",request,&payload)
+	if err!=nil{
+		return payload,err
+	}
+	if payload.BestHeight<0{
+		return payload,badPayload("version","negative best height")
+	}
+	return payload,nil
+}
+
+func decodeAddr(request []byte) (addr,error){
+	var payload addr
+
+	err:=decodePayload("addr",request,&payload)
+	if err!=nil{
+		return payload,err
+	}
+	if len(payload.AddrList)>maxAddrCount{
+		return payload,badPayload("addr","too many addresses")
+	}
+	return payload,nil
+}
+
+func decodeGetBlocks(request []byte) (getblocks,error){
+	var payload getblocks
+
+	err:=decodePayload("getblocks",request,&payload)
+	return payload,err
+}
+
+func decodeInv(request []byte) (inv,error){
+	var payload inv
+
+	err:=decodePayload("inv",request,&payload)
+	if err!=nil{
+		return payload,err
+	}
+	if payload.Type!="block"&&payload.Type!="tx"{
+		return payload,badPayload("inv","unknown inventory type")
+	}
+	if len(payload.Items)==0{
+		return payload,badPayload("inv","empty inventory")
+	}
+	for _,item:=range payload.Items{
+		if !isHash(item){
+			return payload,badPayload("inv","malformed inventory item")
+		}
+	}
+	return payload,nil
+}
+
+func decodeGetData(request []byte) (getdata,error){
+	var payload getdata
+
+	err:=decodePayload("getdata",request,&payload)
+	if err!=nil{
+		return payload,err
+	}
+	if payload.Type!="block"&&payload.Type!="tx"{
+		return payload,badPayload("getdata","unknown data type")
+	}
+	if !isHash(payload.ID){
+		return payload,badPayload("getdata","malformed ID")
+	}
+	return payload,nil
+}
+
+func decodeBlock(request []byte) (block,*Block,error){
+	var payload block
+
+	err:=decodePayload("block",request,&payload)
+	if err!=nil{
+		return payload,nil,err
+	}
+
+	b,err:=ParseBlock(payload.Block)
+	if err!=nil{
+		return payload,nil,&MessageError{"block",malformedMessageScore,err}
+	}
+	return payload,b,nil
+}
+
+func decodeTx(request []byte) (tx,*Transaction,error){
+	var payload tx
+
+	err:=decodePayload("tx",request,&payload)
+	if err!=nil{
+		return payload,nil,err
+	}
+
+	transaction,err:=ParseTransaction(payload.Transaction)
+	if err!=nil{
+		return payload,nil,&MessageError{"tx",malformedMessageScore,err}
+	}
+	return payload,&transaction,nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	var buff bytes.Buffer
+
+	assert.Nil(t, writeMessage(&buff, "version", []byte("payload")))
+	assert.Nil(t, writeMessage(&buff, "getblocks", []byte{}))
+
+	command, payload, err := readMessage(&buff)
+	assert.Nil(t, err)
+	assert.Equal(t, "version", command)
+	assert.Equal(t, []byte("payload"), payload)
+
+	command, payload, err = readMessage(&buff)
+	assert.Nil(t, err)
+	assert.Equal(t, "getblocks", command)
+	assert.Equal(t, 0, len(payload))
+
+	_, _, err = readMessage(&buff)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMalformedMessages(t *testing.T) {
+	frame := func() []byte {
+		var buff bytes.Buffer
+		assert.Nil(t, writeMessage(&buff, "tx", []byte("payload")))
+		return buff.Bytes()
+	}
+
+	badMagic := frame()
+	badMagic[0] ^= 0xff
+	_, _, err := readMessage(bytes.NewReader(badMagic))
+	assert.True(t, errors.Is(err, ErrBadMagic))
+
+	badCommand := frame()
+	badCommand[4+len("tx")+1] = 'x'
+	_, _, err = readMessage(bytes.NewReader(badCommand))
+	assert.True(t, errors.Is(err, ErrBadCommand))
+
+	badChecksum := frame()
+	badChecksum[len(badChecksum)-1] ^= 0xff
+	_, _, err = readMessage(bytes.NewReader(badChecksum))
+	assert.True(t, errors.Is(err, ErrBadChecksum))
+
+	tooLarge := frame()
+	binary.BigEndian.PutUint32(tooLarge[4+commandLength:], maxPayloadSize+1)
+	_, _, err = readMessage(bytes.NewReader(tooLarge))
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+
+	truncated := frame()
+	_, _, err = readMessage(bytes.NewReader(truncated[:len(truncated)-1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	assert.Equal(t, ErrBadCommand, writeMessage(io.Discard, "averylongcommand", nil))
+	assert.Equal(t, ErrMessageTooLarge, writeMessage(io.Discard, "block", make([]byte, maxPayloadSize+1)))
+}
+
+func TestPeerWritesQueuedMessagesInOrder(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	p := newPeer(NewNode("", "", nil, nil), local, "localhost:3999", false)
+	go p.writeLoop()
+
+	assert.Nil(t, p.QueueMessage("getblocks", []byte("first")))
+	assert.Nil(t, p.QueueMessage("getdata", []byte("second")))
+
+	command, payload, err := readMessage(remote)
+	assert.Nil(t, err)
+	assert.Equal(t, "getblocks", command)
+	assert.Equal(t, []byte("first"), payload)
+
+	command, payload, err = readMessage(remote)
+	assert.Nil(t, err)
+	assert.Equal(t, "getdata", command)
+	assert.Equal(t, []byte("second"), payload)
+
+	p.disconnect()
+	assert.NotNil(t, p.QueueMessage("getblocks", nil))
+}
+
+func FuzzReadMessage(f *testing.F) {
+	var buff bytes.Buffer
+	writeMessage(&buff, "version", []byte("payload"))
+	f.Add(buff.Bytes())
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		command, payload, err := readMessage(bytes.NewReader(data))
+		if err == nil {
+			assert.True(t, len(command) > 0 && len(command) <= commandLength)
+			assert.True(t, len(payload) <= maxPayloadSize)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import(
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+//Mnemonics follow BIP39: the entropy and the first len(entropy)/4 bits of its SHA-256
+//are split into 11 bit indexes of mnemonicWords. The seed is PBKDF2-HMAC-SHA512 of the
+//mnemonic with "mnemonic" and the optional passphrase as salt.
+
+const(
+	//defaultMnemonicWords is the number of words of new mnemonics, 128 bits of entropy
+	defaultMnemonicWords=12
+	mnemonicSeedIterations=2048
+	mnemonicSeedSize=64
+)
+
+var ErrBadMnemonic=errors.New("mnemonic is invalid")
+
+//NewMnemonic returns a random mnemonic of 12, 15, 18, 21 or 24 words
+func NewMnemonic(words int) (string,error){
+	if words<12||words>24||words%3!=0{
+		return "",fmt.Errorf("mnemonic must have 12, 15, 18, 21 or 24 words, not %d",words)
+	}
+
+	entropy:=make([]byte,words/3*4)
+	_,err:=rand.Read(entropy)
+	if err!=nil{
+		return "",err
+	}
+
+	return EntropyToMnemonic(entropy)
+}
+
+//EntropyToMnemonic encodes 16 to 32 bytes of entropy, in steps of 4, as a mnemonic
+func EntropyToMnemonic(entropy []byte) (string,error){
+	if len(entropy)<16||len(entropy)>32||len(entropy)%4!=0{
+		return "",errors.New("entropy must be 16 to 32 bytes in steps of 4")
+	}
+
+	checksumBits:=uint(len(entropy)/4)
+	hash:=sha256.Sum256(entropy)
+	data:=new(big.Int).SetBytes(entropy)
+	data.Lsh(data,checksumBits)
+	data.Or(data,big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	words:=make([]string,(uint(len(entropy))*8+checksumBits)/11)
+	index:=new(big.Int)
+	mask:=big.NewInt(2047)
+	for i:=len(words)-1;i>=0;i--{
+		words[i]=mnemonicWords[index.And(data,mask).Int64()]
+		data.Rsh(data,11)
+	}
+
+	return strings.Join(words," "),nil
+}
+
+//MnemonicToEntropy decodes a mnemonic and verifies its checksum
+func MnemonicToEntropy(mnemonic string) ([]byte,error){
+	words:=strings.Fields(mnemonic)
+	if len(words)<12||len(words)>24||len(words)%3!=0{
+		return nil,fmt.Errorf("%w: it must have 12, 15, 18, 21 or 24 words",ErrBadMnemonic)
+	}
+
+	data:=new(big.Int)
+	for _,word:=range words{
+		index:=mnemonicWordIndex(strings.ToLower(word))
+		if index<0{
+			return nil,fmt.Errorf("%w: %q is not in the word list",ErrBadMnemonic,word)
+		}
+		data.Lsh(data,11)
+		data.Or(data,big.NewInt(int64(index)))
+	}
+
+	checksumBits:=uint(len(words)/3)
+	checksum:=new(big.Int).And(data,big.NewInt(1<<checksumBits-1))
+	entropy:=data.Rsh(data,checksumBits).FillBytes(make([]byte,len(words)/3*4))
+
+	hash:=sha256.Sum256(entropy)
+	if checksum.Int64()!=int64(hash[0]>>(8-checksumBits)){
+		return nil,fmt.Errorf("%w: checksum doesn't match",ErrBadMnemonic)
+	}
+
+	return entropy,nil
+}
+
+//MnemonicToSeed verifies the mnemonic and returns its seed
+func MnemonicToSeed(mnemonic,passphrase string) ([]byte,error){
+	_,err:=MnemonicToEntropy(mnemonic)
+	if err!=nil{
+		return nil,err
+	}
+
+	normalized:=strings.ToLower(strings.Join(strings.Fields(mnemonic)," "))
+	return pbkdf2.Key([]byte(normalized),[]byte("mnemonic"+passphrase),mnemonicSeedIterations,mnemonicSeedSize,sha512.New),nil
+}
+
+//mnemonicWordIndex returns the index of the word in the sorted word list, or -1
+func mnemonicWordIndex(word string) int{
+	i:=sort.SearchStrings(mnemonicWords,word)
+	if i<len(mnemonicWords)&&mnemonicWords[i]==word{
+		return i
+	}
+	return -1
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// copyTestBlockchain opens a copy of bc as the blockchain of another node
+func copyTestBlockchain(t *testing.T, bc *Blockchain, nodeID string) *Blockchain {
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(fmt.Sprintf(dbFile, nodeID), 0600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied := NewBlockchain(nodeID)
+	t.Cleanup(func() {
+		copied.db.Close()
+	})
+	return copied
+}
+
+// startTestNode runs a node on a free local port, with no seeds it is the central node
+func startTestNode(t *testing.T, minerAddress string, bc *Blockchain, seeds ...string) *Node {
+	n := NewNode("127.0.0.1:0", minerAddress, seeds, bc)
+	if err := n.Listen(); err != nil {
+		t.Fatal(err)
+	}
+
+	go n.Serve()
+	t.Cleanup(n.Stop)
+	return n
+}
+
+func waitFor(t *testing.T, message string, condition func() bool) {
+	deadline := time.Now().Add(20 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNodeSyncsFromCentralNode(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	other := copyTestBlockchain(t, bc, "other")
+
+	for i := 0; i < 3; i++ {
+		_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(miner.GetAddress()), fmt.Sprint(i), subsidy)})
+		assert.Nil(t, err)
+	}
+
+	central := startTestNode(t, "", bc)
+	node := startTestNode(t, "", other, central.Address())
+
+	waitFor(t, "node didn't download the chain", func() bool {
+		return other.GetBestHeight() == 3
+	})
+	assert.Equal(t, bc.Tip(), other.Tip())
+	assert.True(t, central.nodeIsKnown(node.Address()), "central node learns the address from version")
+}
+
+func TestTransactionsAreRelayedAndMined(t *testing.T) {
+	bc, sender := newTestBlockchain(t)
+	secondSender := NewWallet()
+	miner := NewWallet()
+	receiver := NewWallet()
+
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(secondSender.GetAddress()), "", subsidy)})
+	assert.Nil(t, err)
+	minerChain := copyTestBlockchain(t, bc, "miner")
+	clientChain := copyTestBlockchain(t, bc, "client")
+
+	central := startTestNode(t, "", bc)
+	minerNode := startTestNode(t, string(miner.GetAddress()), minerChain, central.Address())
+	waitFor(t, "central node didn't learn about the miner", func() bool {
+		return central.nodeIsKnown(minerNode.Address())
+	})
+
+	UTXOSet := UTXOSet{clientChain}
+	client := NewNode("", "", []string{central.Address()}, clientChain)
+	tx, _ := NewUTXOTransaction(sender, string(receiver.GetAddress()), 3, TxOptions{Fee: 1}, &UTXOSet)
+	client.sendTx(central.Address(), tx)
+	tx, _ = NewUTXOTransaction(secondSender, string(receiver.GetAddress()), 4, TxOptions{Fee: 2}, &UTXOSet)
+	client.sendTx(central.Address(), tx)
+	client.closePeers()
+
+	waitFor(t, "miner's block didn't reach the central node", func() bool {
+		return bc.GetBestHeight() == 2
+	})
+	assert.Equal(t, minerChain.Tip(), bc.Tip())
+	assert.Equal(t, 7, balanceOf(bc, receiver))
+	assert.Equal(t, subsidy+3, balanceOf(bc, miner), "Miner collects the subsidy and the fees")
+	assert.Equal(t, 0, minerNode.mempool.Count())
+}
+
+func TestMiningIsAbortedByCompetingBlock(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	n := NewNode("", string(miner.GetAddress()), nil, bc)
+
+	ctx := n.startMining(1)
+	mined := make(chan error)
+	go func() {
+		// The target of these bits is 1, mining only ends when ctx is canceled
+		_, err := NewBlockWithContext(ctx, []*Transaction{NewCoinbaseTX(string(miner.GetAddress()), "", subsidy)}, bc.Tip(), 1, 0x03000001)
+		mined <- err
+	}()
+
+	n.abortMiningAt(0)
+	assert.Nil(t, ctx.Err(), "Blocks below the mined height don't compete")
+	n.abortMiningAt(1)
+	select {
+	case err := <-mined:
+		assert.True(t, errors.Is(err, context.Canceled), "Block at the mined height cancels mining")
+	case <-time.After(20 * time.Second):
+		t.Fatal("mining wasn't aborted")
+	}
+
+	previous := n.startMining(2)
+	next := n.startMining(2)
+	assert.NotNil(t, previous.Err(), "Mining a new block cancels the previous one")
+	assert.Nil(t, next.Err())
+
+	n.Stop()
+	assert.NotNil(t, next.Err())
+	assert.NotNil(t, n.startMining(3).Err(), "Stopped node doesn't mine")
+}
@@ -0,0 +1,278 @@
+package main
+
+import(
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+//centralNode is the node every other node connects to first
+const centralNode="localhost:3000"
+
+//Node is a network node. It owns the blockchain it serves, its peers, the
+//mempool and the block download state, so several nodes can run in one process.
+type Node struct{
+	address string
+	miningAddress string
+	bc *Blockchain
+	listener net.Listener
+
+	mempool *Mempool
+
+	//mutex guards knownNodes and blocksInTransit
+	mutex sync.Mutex
+	knownNodes []string
+	blocksInTransit [][]byte
+
+	//miningMutex guards the mining state. A single worker mines at a time, mineAgain asks it
+	//for another round when transactions arrive while it runs.
+	miningMutex sync.Mutex
+	mining bool
+	mineAgain bool
+	miningStopped bool
+	//miningHeight is the height of the block being mined
+	miningHeight int
+	cancelMining context.CancelFunc
+
+	//peersMutex guards peers, the addresses of the peers and the misbehavior scores and bans,
+	//which are kept by host so a peer can't shed them by announcing another address
+	peersMutex sync.Mutex
+	peers map[string]*Peer
+	scores map[string]int
+	bannedUntil map[string]time.Time
+}
+
+//NewNode creates a node listening on address that first connects to the seed nodes.
+//The first seed is the central node that relays transactions to miners.
+func NewNode(address,minerAddress string,seeds []string,bc *Blockchain) *Node{
+	return &Node{
+		address:address,
+		miningAddress:minerAddress,
+		bc:bc,
+		mempool:NewMempool(maxMempoolSize,mempoolExpiry),
+		knownNodes:append([]string{},seeds...),
+		blocksInTransit:[][]byte{},
+		peers:make(map[string]*Peer),
+		scores:make(map[string]int),
+		bannedUntil:make(map[string]time.Time)}
+}
+
+//Listen binds the node's address. A zero port picks a free one and the node takes its address.
+func (n *Node) Listen() error{
+	ln,err:=net.Listen(protocol,n.address)
+	if err!=nil{
+		return err
+	}
+
+	n.listener=ln
+	n.address=ln.Addr().String()
+	if len(n.knownNodes)==0{
+		n.knownNodes=[]string{n.address}
+	}
+	return nil
+}
+
+//Serve announces the node to the central node and handles connections until Stop is called
+func (n *Node) Serve() error{
+	if n.listener==nil{
+		return errors.New("Node is not listening")
+	}
+
+	if seed:=n.KnownNodes()[0];seed!=n.address{
+		n.sendVersion(seed)
+	}
+
+	for{
+		conn,err:=n.listener.Accept()
+		if err!=nil{
+			if errors.Is(err,net.ErrClosed){
+				return nil
+			}
+			return err
+		}
+		go n.handleConnection(conn)
+	}
+}
+
+//Stop closes the listener and all peer connections and aborts mining
+func (n *Node) Stop(){
+	if n.listener!=nil{
+		n.listener.Close()
+	}
+
+	n.peersMutex.Lock()
+	var all []*Peer
+	for _,p:=range n.peers{
+		all=append(all,p)
+	}
+	n.peersMutex.Unlock()
+
+	for _,p:=range all{
+		p.disconnect()
+	}
+
+	n.miningMutex.Lock()
+	n.miningStopped=true
+	n.miningMutex.Unlock()
+	n.abortMining()
+}
+
+//Address returns the address the node listens on
+func (n *Node) Address() string{
+	return n.address
+}
+
+//isCentral reports whether the node is the central node that relays transactions
+func (n *Node) isCentral() bool{
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return len(n.knownNodes)>0&&n.knownNodes[0]==n.address
+}
+
+//KnownNodes returns the addresses of the nodes this node knows about
+func (n *Node) KnownNodes() []string{
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return append([]string{},n.knownNodes...)
+}
+
+//nodeIsKnown
+func (n *Node) nodeIsKnown(addr string) bool{
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _,node:=range n.knownNodes{
+		if node==addr{
+			return true
+		}
+	}
+	return false
+}
+
+//addKnownNode remembers a node address unless it is known already
+func (n *Node) addKnownNode(addr string){
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _,node:=range n.knownNodes{
+		if node==addr{
+			return
+		}
+	}
+	n.knownNodes=append(n.knownNodes,addr)
+}
+
+//removeKnownNode forgets a node that isn't available
+func (n *Node) removeKnownNode(addr string){
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	var updataNodes []string
+	for _,node:=range n.knownNodes{
+		if node!=addr{
+			updataNodes=append(updataNodes,node)
+		}
+	}
+	n.knownNodes=updataNodes
+}
+
+//setBlocksInTransit replaces the blocks still to be downloaded, oldest first
+func (n *Node) setBlocksInTransit(hashes [][]byte){
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.blocksInTransit=hashes
+}
+
+//nextBlockInTransit removes and returns the next block to download
+func (n *Node) nextBlockInTransit() ([]byte,bool){
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if len(n.blocksInTransit)==0{
+		return nil,false
+	}
+
+	blockHash:=n.blocksInTransit[0]
+	n.blocksInTransit=n.blocksInTransit[1:]
+	return blockHash,true
+}
+
+//startMiner mines the mempool in a worker goroutine, or has the running worker mine again when it is done
+func (n *Node) startMiner(){
+	n.miningMutex.Lock()
+	defer n.miningMutex.Unlock()
+
+	if n.miningStopped{
+		return
+	}
+	if n.mining{
+		n.mineAgain=true
+		return
+	}
+	n.mining=true
+
+	go func(){
+		for{
+			err:=n.mineTransactions()
+			if err!=nil{
+				fmt.Println("Mining failed:",err)
+			}
+
+			n.miningMutex.Lock()
+			if !n.mineAgain||n.miningStopped{
+				n.mining=false
+				n.miningMutex.Unlock()
+				return
+			}
+			n.mineAgain=false
+			n.miningMutex.Unlock()
+		}
+	}()
+}
+
+//startMining returns the context of the block being mined at height, canceled by abortMining.
+//It cancels the context of a previous block, after Stop the context is canceled already.
+func (n *Node) startMining(height int) context.Context{
+	n.miningMutex.Lock()
+	defer n.miningMutex.Unlock()
+
+	if n.cancelMining!=nil{
+		n.cancelMining()
+	}
+	ctx,cancel:=context.WithCancel(context.Background())
+	n.cancelMining=cancel
+	n.miningHeight=height
+	if n.miningStopped{
+		cancel()
+	}
+
+	return ctx
+}
+
+//abortMining stops mining the current block
+func (n *Node) abortMining(){
+	n.miningMutex.Lock()
+	defer n.miningMutex.Unlock()
+
+	if n.cancelMining!=nil{
+		n.cancelMining()
+		n.cancelMining=nil
+	}
+}
+
+//abortMiningAt stops mining when a block at height competes with the block being mined
+func (n *Node) abortMiningAt(height int){
+	n.miningMutex.Lock()
+	defer n.miningMutex.Unlock()
+
+	if n.cancelMining!=nil&&height>=n.miningHeight{
+		n.cancelMining()
+		n.cancelMining=nil
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import(
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+//A batch payment pays many recipients in one transaction. Recipients are given as ADDRESS:AMOUNT,
+//or in a file that is either a JSON array of {"address","amount"} objects or CSV lines of
+//address and amount, with an optional address,amount header.
+
+//Recipient is an address and the amount paid to it
+type Recipient struct{
+	Address string `json:"address"`
+	Amount int `json:"amount"`
+}
+
+//NewRecipient checks the address and that the amount is positive
+func NewRecipient(address string,amount int) (Recipient,error){
+	if !ValidateAddress(address){
+		return Recipient{},fmt.Errorf("recipient address %q is not valid",address)
+	}
+	if amount<=0{
+		return Recipient{},fmt.Errorf("amount paid to %s must be positive",address)
+	}
+	return Recipient{address,amount},nil
+}
+
+//ParseRecipient parses ADDRESS:AMOUNT
+func ParseRecipient(s string) (Recipient,error){
+	address,amount,ok:=strings.Cut(s,":")
+	if !ok{
+		return Recipient{},fmt.Errorf("recipient %q is not ADDRESS:AMOUNT",s)
+	}
+
+	value,err:=strconv.Atoi(strings.TrimSpace(amount))
+	if err!=nil{
+		return Recipient{},fmt.Errorf("amount of recipient %q is not a number",s)
+	}
+	return NewRecipient(strings.TrimSpace(address),value)
+}
+
+//ReadRecipients reads the recipients of a JSON or CSV file
+func ReadRecipients(data []byte) ([]Recipient,error){
+	data=bytes.TrimSpace(data)
+	if len(data)>0&&data[0]=='['{
+		return readJSONRecipients(data)
+	}
+	return readCSVRecipients(data)
+}
+
+//LoadRecipients reads the recipients of the file at path
+func LoadRecipients(path string) ([]Recipient,error){
+	data,err:=ioutil.ReadFile(path)
+	if err!=nil{
+		return nil,err
+	}
+
+	recipients,err:=ReadRecipients(data)
+	if err!=nil{
+		return nil,fmt.Errorf("%s: %w",path,err)
+	}
+	return recipients,nil
+}
+
+//TotalAmount returns the sum paid to the recipients
+func TotalAmount(recipients []Recipient) int{
+	total:=0
+	for _,recipient:=range recipients{
+		total+=recipient.Amount
+	}
+	return total
+}
+
+func readJSONRecipients(data []byte) ([]Recipient,error){
+	var entries []Recipient
+	err:=json.Unmarshal(data,&entries)
+	if err!=nil{
+		return nil,err
+	}
+
+	var recipients []Recipient
+	for i,entry:=range entries{
+		recipient,err:=NewRecipient(entry.Address,entry.Amount)
+		if err!=nil{
+			return nil,fmt.Errorf("entry %d: %w",i+1,err)
+		}
+		recipients=append(recipients,recipient)
+	}
+	return recipients,nil
+}
+
+func readCSVRecipients(data []byte) ([]Recipient,error){
+	r:=csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord=2
+	r.TrimLeadingSpace=true
+
+	var recipients []Recipient
+	for line:=1;;line++{
+		record,err:=r.Read()
+		if errors.Is(err,io.EOF){
+			break
+		}
+		if err!=nil{
+			return nil,err
+		}
+
+		if line==1&&isRecipientsHeader(record){
+			continue
+		}
+
+		amount,err:=strconv.Atoi(strings.TrimSpace(record[1]))
+		if err!=nil{
+			return nil,fmt.Errorf("line %d: amount %q is not a number",line,record[1])
+		}
+
+		recipient,err:=NewRecipient(strings.TrimSpace(record[0]),amount)
+		if err!=nil{
+			return nil,fmt.Errorf("line %d: %w",line,err)
+		}
+		recipients=append(recipients,recipient)
+	}
+	return recipients,nil
+}
+
+//isRecipientsHeader reports whether a CSV record is the header address,amount
+func isRecipientsHeader(record []string) bool{
+	return strings.EqualFold(strings.TrimSpace(record[0]),"address")&&strings.EqualFold(strings.TrimSpace(record[1]),"amount")
+}
+
+//RecipientsFromFlags parses the -to flags of send. A single -to that is only an address is paid amount.
+func RecipientsFromFlags(values []string,amount int) ([]Recipient,error){
+	if len(values)==1&&!strings.Contains(values[0],":"){
+		recipient,err:=NewRecipient(values[0],amount)
+		if err!=nil{
+			return nil,err
+		}
+		return []Recipient{recipient},nil
+	}
+	if amount!=0{
+		return nil,errors.New("-amount is only for a single -to ADDRESS, use -to ADDRESS:AMOUNT")
+	}
+
+	var recipients []Recipient
+	for _,value:=range values{
+		recipient,err:=ParseRecipient(value)
+		if err!=nil{
+			return nil,err
+		}
+		recipients=append(recipients,recipient)
+	}
+	return recipients,nil
+}
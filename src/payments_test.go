@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecipients(t *testing.T) {
+	alice := string(NewWallet().GetAddress())
+	bob := string(NewWallet().GetAddress())
+
+	recipient, err := ParseRecipient(alice + ":3")
+	assert.Nil(t, err)
+	assert.Equal(t, Recipient{alice, 3}, recipient)
+
+	for _, s := range []string{alice, alice + ":", alice + ":x", alice + ":0", "1abc:3"} {
+		_, err = ParseRecipient(s)
+		assert.NotNil(t, err, s+" is not a recipient")
+	}
+
+	expected := []Recipient{{alice, 3}, {bob, 4}}
+	files := []string{
+		fmt.Sprintf("%s,3\n%s,4\n", alice, bob),
+		fmt.Sprintf("address,amount\n%s, 3\n%s, 4", alice, bob),
+		fmt.Sprintf("Address, AMOUNT\n%s,3\n%s,4", alice, bob),
+		fmt.Sprintf(`[{"address":"%s","amount":3},{"address":"%s","amount":4}]`, alice, bob),
+	}
+	for _, file := range files {
+		recipients, err := ReadRecipients([]byte(file))
+		assert.Nil(t, err, file)
+		assert.Equal(t, expected, recipients, file)
+	}
+
+	_, err = ReadRecipients([]byte(fmt.Sprintf("%s,3\n%s,many\n", alice, bob)))
+	assert.EqualError(t, err, `line 2: amount "many" is not a number`)
+	_, err = ReadRecipients([]byte(fmt.Sprintf("%s,three\n%s,4\n", alice, bob)))
+	assert.EqualError(t, err, `line 1: amount "three" is not a number`, "Only the header may be skipped")
+	_, err = ReadRecipients([]byte(fmt.Sprintf("name,amount\n%s,4\n", bob)))
+	assert.EqualError(t, err, `line 1: amount "amount" is not a number`)
+	_, err = ReadRecipients([]byte(fmt.Sprintf(`[{"address":"%s","amount":-1}]`, alice)))
+	assert.NotNil(t, err, "Amounts must be positive")
+
+	recipients, err := RecipientsFromFlags([]string{alice}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []Recipient{{alice, 5}}, recipients, "Single address is paid -amount")
+
+	flags := []string{alice + ":3", bob + ":4"}
+	recipients, err = RecipientsFromFlags(flags, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, recipients)
+	_, err = RecipientsFromFlags(flags, 5)
+	assert.NotNil(t, err, "-amount doesn't apply to many recipients")
+}
+
+func TestNewBatchTransaction(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	UTXOSet := UTXOSet{bc}
+	var recipients []Recipient
+	for i := 1; i <= 3; i++ {
+		recipients = append(recipients, Recipient{string(NewWallet().GetAddress()), i})
+	}
+
+	tx, err := NewBatchTransaction(miner, recipients, TxOptions{Fee: 1}, &UTXOSet)
+	assert.Nil(t, err)
+	assert.Len(t, tx.Vin, 1)
+	assert.Len(t, tx.Vout, 4, "Every recipient and a single change output are paid")
+	for i, recipient := range recipients {
+		assert.Equal(t, recipient.Amount, tx.Vout[i].Value)
+		assert.Equal(t, AddressToScript(recipient.Address), tx.Vout[i].ScriptPubKey)
+	}
+	assert.Equal(t, subsidy-1-1-2-3, tx.Vout[3].Value)
+
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(miner.GetAddress()), "", subsidy+1), tx})
+	assert.Nil(t, err, "Batch transaction is valid")
+	for _, recipient := range recipients {
+		balance, _ := UTXOSet.FindBalance(AddressToScript(recipient.Address))
+		assert.Equal(t, recipient.Amount, balance)
+	}
+
+	_, err = NewBatchTransaction(miner, nil, TxOptions{}, &UTXOSet)
+	assert.NotNil(t, err, "Transaction needs recipients")
+}
@@ -0,0 +1,327 @@
+package main
+
+import(
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+//dialTimeout bounds connecting to a peer
+const dialTimeout=10*time.Second
+
+//writeTimeout bounds writing a single message to a peer
+const writeTimeout=30*time.Second
+
+//sendQueueLength is the number of messages that can wait to be written to a peer
+const sendQueueLength=64
+
+//A peer whose misbehavior score reaches banThreshold is disconnected and banned for banDuration
+const banThreshold=100
+const banDuration=24*time.Hour
+
+//Misbehavior scores of the messages a well-behaved peer never sends
+const(
+	malformedMessageScore=20
+	unknownCommandScore=10
+	invalidTxScore=10
+	invalidBlockScore=banThreshold
+)
+
+//Peer is a long-lived connection to another node. Messages are read by its
+//read loop and written in order by its write loop from the send queue.
+type Peer struct{
+	node *Node
+	addr string
+	conn net.Conn
+	inbound bool
+	sendQueue chan []byte
+	quit chan struct{}
+	closeOnce sync.Once
+	//host is the remote host of the connection, bans and misbehavior scores are kept by it
+	host string
+}
+
+func newPeer(n *Node,conn net.Conn,addr string,inbound bool) *Peer{
+	return &Peer{
+		node:n,
+		addr:addr,
+		conn:conn,
+		host:hostOf(conn.RemoteAddr().String()),
+		inbound:inbound,
+		sendQueue:make(chan []byte,sendQueueLength),
+		quit:make(chan struct{})}
+}
+
+//connectPeer returns the connection to the node at addr, dialing it if there is none
+func (n *Node) connectPeer(addr string) (*Peer,error){
+	if n.isBanned(hostOf(addr)){
+		return nil,fmt.Errorf("%s is banned",addr)
+	}
+
+	n.peersMutex.Lock()
+	p,ok:=n.peers[addr]
+	n.peersMutex.Unlock()
+	if ok{
+		return p,nil
+	}
+
+	conn,err:=net.DialTimeout(protocol,addr,dialTimeout)
+	if err!=nil{
+		return nil,err
+	}
+
+	p=newPeer(n,conn,addr,false)
+	if existing:=n.registerPeer(p);existing!=p{
+		conn.Close()
+		return existing,nil
+	}
+
+	go p.writeLoop()
+	go p.readLoop()
+	return p,nil
+}
+
+//registerPeer makes p the connection used for its address unless there is one already.
+//It returns the registered peer.
+func (n *Node) registerPeer(p *Peer) *Peer{
+	n.peersMutex.Lock()
+	defer n.peersMutex.Unlock()
+
+	if existing,ok:=n.peers[p.addr];ok{
+		return existing
+	}
+	n.peers[p.addr]=p
+	return p
+}
+
+//identify names an inbound peer by the listening address it announced, which is only used
+//to reply and gossip. The peer is registered under it unless another one already is.
+func (p *Peer) identify(addr string){
+	p.node.peersMutex.Lock()
+	defer p.node.peersMutex.Unlock()
+
+	if p.addr!=""{
+		return
+	}
+	if _,ok:=p.node.peers[addr];!ok{
+		p.addr=addr
+		p.node.peers[addr]=p
+	}
+}
+
+//hostOf returns the host of a host:port address, or the address if it has no port
+func hostOf(addr string) string{
+	host,_,err:=net.SplitHostPort(addr)
+	if err!=nil{
+		return addr
+	}
+	return host
+}
+
+//banKey identifies the peer for banning: the host it connects from, whatever address it announced
+func (p *Peer) banKey() string{
+	return p.host
+}
+
+//score returns the misbehavior score of the peer's host
+func (p *Peer) score() int{
+	p.node.peersMutex.Lock()
+	defer p.node.peersMutex.Unlock()
+
+	return p.node.scores[p.host]
+}
+
+//misbehave adds to the misbehavior score of the peer's host and bans it once the score reaches banThreshold
+func (p *Peer) misbehave(score int,reason error){
+	p.node.peersMutex.Lock()
+	p.node.scores[p.host]+=score
+	total:=p.node.scores[p.host]
+	p.node.peersMutex.Unlock()
+
+	fmt.Printf("Misbehavior score of %s is %d: %s\n",p,total,reason)
+	if total>=banThreshold{
+		p.node.ban(p.banKey())
+		p.disconnect()
+	}
+}
+
+//ban refuses connections to and from the key for banDuration
+func (n *Node) ban(key string){
+	n.peersMutex.Lock()
+	defer n.peersMutex.Unlock()
+
+	n.bannedUntil[key]=time.Now().Add(banDuration)
+	delete(n.scores,key)
+	fmt.Printf("Banned %s until %s\n",key,n.bannedUntil[key].Format(time.RFC3339))
+}
+
+//isBanned reports whether the key is banned, expired bans are lifted
+func (n *Node) isBanned(key string) bool{
+	n.peersMutex.Lock()
+	defer n.peersMutex.Unlock()
+
+	until,ok:=n.bannedUntil[key]
+	if ok&&time.Now().After(until){
+		delete(n.bannedUntil,key)
+		return false
+	}
+	return ok
+}
+
+//peerInfo describes a connected peer
+type peerInfo struct{
+	Addr string `json:"addr"`
+	Inbound bool `json:"inbound"`
+	BanScore int `json:"banscore"`
+}
+
+//PeerInfo describes the connected peers ordered by address
+func (n *Node) PeerInfo() []peerInfo{
+	n.peersMutex.Lock()
+	defer n.peersMutex.Unlock()
+
+	infos:=[]peerInfo{}
+	for addr,p:=range n.peers{
+		infos=append(infos,peerInfo{addr,p.inbound,n.scores[p.host]})
+	}
+	sort.Slice(infos,func(i,j int) bool{
+		return infos[i].Addr<infos[j].Addr
+	})
+	return infos
+}
+
+//closePeers writes the queued messages of all peers and disconnects them
+func (n *Node) closePeers(){
+	n.peersMutex.Lock()
+	var all []*Peer
+	for _,p:=range n.peers{
+		all=append(all,p)
+	}
+	n.peersMutex.Unlock()
+
+	for _,p:=range all{
+		p.Close()
+	}
+}
+
+//QueueMessage frames the payload and queues it to be written to the peer
+func (p *Peer) QueueMessage(command string,payload []byte) error{
+	var frame bytes.Buffer
+
+	err:=writeMessage(&frame,command,payload)
+	if err!=nil{
+		return err
+	}
+
+	select{
+	case <-p.quit:
+		return fmt.Errorf("%s is disconnected",p)
+	default:
+	}
+
+	select{
+	case p.sendQueue<-frame.Bytes():
+		return nil
+	case <-p.quit:
+		return fmt.Errorf("%s is disconnected",p)
+	}
+}
+
+//Close disconnects the peer once the queued messages are written
+func (p *Peer) Close(){
+	select{
+	case p.sendQueue<-nil:
+	case <-p.quit:
+	}
+	<-p.quit
+}
+
+//disconnect closes the connection and forgets the peer
+func (p *Peer) disconnect(){
+	p.closeOnce.Do(func(){
+		close(p.quit)
+		p.conn.Close()
+
+		p.node.peersMutex.Lock()
+		if p.node.peers[p.addr]==p{
+			delete(p.node.peers,p.addr)
+		}
+		p.node.peersMutex.Unlock()
+	})
+}
+
+func (p *Peer) String() string{
+	p.node.peersMutex.Lock()
+	defer p.node.peersMutex.Unlock()
+
+	if p.addr!=""{
+		return p.addr
+	}
+	return p.conn.RemoteAddr().String()
+}
+
+//writeLoop writes queued messages until the peer disconnects, a nil message closes the connection
+func (p *Peer) writeLoop(){
+	for{
+		select{
+		case frame:=<-p.sendQueue:
+			if frame==nil{
+				p.disconnect()
+				return
+			}
+
+			p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			_,err:=p.conn.Write(frame)
+			if err!=nil{
+				fmt.Printf("Failed to write to %s: %s\n",p,err)
+				p.disconnect()
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+//readLoop reads and handles messages until the connection is closed.
+//Bad messages add to the misbehavior score of the peer, a frame that
+//breaks the stream gets it banned.
+func (p *Peer) readLoop(){
+	defer p.disconnect()
+
+	for{
+		command,payload,err:=readMessage(p.conn)
+		if errors.Is(err,ErrBadChecksum){
+			p.misbehave(malformedMessageScore,err)
+			continue
+		}
+		if err!=nil{
+			select{
+			case <-p.quit:
+			default:
+				if errors.Is(err,ErrBadMagic)||errors.Is(err,ErrBadCommand)||errors.Is(err,ErrMessageTooLarge){
+					p.misbehave(banThreshold,err)
+				}else if err!=io.EOF{
+					fmt.Printf("Disconnecting %s: %s\n",p,err)
+				}
+			}
+			return
+		}
+
+		fmt.Printf("Received: %s command\n",command)
+		err=p.node.handleMessage(p,command,payload)
+		if err!=nil{
+			fmt.Printf("Failed to handle %s from %s: %s\n",command,p,err)
+
+			var msgErr *MessageError
+			if errors.As(err,&msgErr){
+				p.misbehave(msgErr.Score,msgErr)
+			}
+		}
+	}
+}
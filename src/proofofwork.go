@@ -2,14 +2,19 @@ package main
 
 import(
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const targetBits=16
-
 var (
 	maxNonce=math.MaxInt64
 ) 
@@ -17,53 +22,107 @@ var (
 type ProofOfWork struct{
 	block *Block
 	target *big.Int
+	hashrate float64
 }
 
 func NewProofOfWork(b *Block) *ProofOfWork {
-	target:=big.NewInt(1)
-	target.Lsh(target,uint(256-targetBits))
+	target:=CompactToBig(b.Bits)
 
-	pow:=&ProofOfWork{b,target}
+	pow:=&ProofOfWork{block:b,target:target}
 
 	return pow
 }
 
 func (pow *ProofOfWork) prepareData(nonce int)[]byte{
+	return append(pow.prepareHeader(),IntToHex(int64(nonce))...)
+}
+
+//prepareHeader returns the part of the hashed data that doesn't depend on the nonce
+func (pow *ProofOfWork) prepareHeader()[]byte{
 	data:=bytes.Join(
 		[][]byte{
 			pow.block.PrevBlockHash,
 			pow.block.HashTransactions(),
 			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce))},
+			IntToHex(int64(pow.block.Bits))},
 		[]byte{})
 
 	return data
 }
 
-func (pow*ProofOfWork) Run()(int,[]byte){
-	var hashInt big.Int
-	var hash [32]byte
-	nonce:=0
+//Run searches for a nonce on every CPU until one is found or ctx is canceled
+func (pow*ProofOfWork) Run(ctx context.Context)(int,[]byte,error){
+	type result struct{
+		nonce int
+		hash []byte
+	}
+
+	ctx,cancel:=context.WithCancel(ctx)
+	defer cancel()
+
+	header:=pow.prepareHeader()
+	workers:=runtime.NumCPU()
+	found:=make(chan result,workers)
+	var hashes uint64
+	var wg sync.WaitGroup
+
+	fmt.Printf("Mining a new block on %d workers\n",workers)
+	start:=time.Now()
+
+	for i:=0;i<workers;i++{
+		wg.Add(1)
+		go func(first int){
+			defer wg.Done()
+
+			var hashInt big.Int
+			data:=make([]byte,len(header)+8)
+			copy(data,header)
+			count:=uint64(0)
+
+			for nonce:=first;nonce<maxNonce&&nonce>=0;nonce+=workers{
+				if count%1024==0&&ctx.Err()!=nil{
+					break
+				}
+				count++
+
+				binary.BigEndian.PutUint64(data[len(header):],uint64(nonce))
+				hash:=sha256.Sum256(data)
+				hashInt.SetBytes(hash[:])
+
+				if hashInt.Cmp(pow.target)==-1{
+					found<-result{nonce,hash[:]}
+					cancel()
+					break
+				}
+			}
+			atomic.AddUint64(&hashes,count)
+		}(i)
+	}
 
-	fmt.Printf("Mining a new block \n")
-	for nonce<maxNonce{
-		data:=pow.prepareData(nonce)
+	wg.Wait()
+	close(found)
 
-		hash=sha256.Sum256(data)
-		hashInt.SetBytes(hash[:])
+	if elapsed:=time.Since(start).Seconds();elapsed>0{
+		pow.hashrate=float64(hashes)/elapsed
+	}
+	fmt.Printf("%d hashes at %.0f H/s\n",hashes,pow.hashrate)
 
-		if hashInt.Cmp(pow.target)==-1{
-			fmt.Printf("\r%x",hash)
-			break
-		}else{
-			nonce++
+	res,ok:=<-found
+	if !ok{
+		fmt.Print("Mining aborted\n\n")
+		if ctx.Err()==nil{
+			return 0,nil,errors.New("Nonce space is exhausted")
 		}
+		return 0,nil,ctx.Err()
 	}
 
-	fmt.Print("\n\n")
+	fmt.Printf("%x\n\n",res.hash)
+	return res.nonce,res.hash,nil
+}
 
-	return nonce,hash[:]
+//Hashrate returns the hashes per second reached by the last Run
+func (pow *ProofOfWork) Hashrate() float64{
+	return pow.hashrate
 }
 
 func (pow *ProofOfWork) Validate()bool{
@@ -72,7 +131,19 @@ func (pow *ProofOfWork) Validate()bool{
 	hash:=sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 
-	isVaild:=hashInt.Cmp(pow.target)==-1
+	if pow.target.Sign()<=0||pow.target.Cmp(powLimit)>0{
+		return false
+	}
+
+	isVaild:=hashInt.Cmp(pow.target)==-1&&bytes.Compare(hash[:],pow.block.Hash)==0
 
 	return isVaild
+}
+
+//Work returns the expected number of hashes needed to find the block
+func (pow *ProofOfWork) Work() *big.Int{
+	denominator:=new(big.Int).Add(pow.target,big.NewInt(1))
+	numerator:=new(big.Int).Lsh(big.NewInt(1),256)
+
+	return numerator.Div(numerator,denominator)
 }
\ No newline at end of file
@@ -0,0 +1,192 @@
+package main
+
+import(
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Raw transactions are created, signed and sent in separate steps, passed between them as the hex of
+//their canonical encoding. Signing only needs the locking scripts of the spent outputs, so a wallet
+//on a machine without the chain can sign when it is given them as PrevOuts.
+
+//PrevOut is an output spent by a raw transaction and what signing its input needs
+type PrevOut struct{
+	Txid []byte
+	Vout int
+	ScriptPubKey []byte
+	//RedeemScript is the script a pay-to-script-hash output commits to, nil for other outputs
+	RedeemScript []byte
+}
+
+//ParseOutpoint parses TXID:VOUT
+func ParseOutpoint(s string) ([]byte,int,error){
+	txid,vout,ok:=strings.Cut(s,":")
+	if !ok{
+		return nil,0,fmt.Errorf("output %q is not TXID:VOUT",s)
+	}
+
+	ID,err:=hex.DecodeString(txid)
+	if err!=nil||len(ID)==0{
+		return nil,0,fmt.Errorf("transaction ID of output %q is not hex encoded",s)
+	}
+	index,err:=strconv.Atoi(vout)
+	if err!=nil||index<0{
+		return nil,0,fmt.Errorf("index of output %q is not a number",s)
+	}
+	return ID,index,nil
+}
+
+//ParsePrevOut parses TXID:VOUT:SCRIPTPUBKEY or TXID:VOUT:SCRIPTPUBKEY:REDEEMSCRIPT, the scripts in hex
+func ParsePrevOut(s string) (PrevOut,error){
+	parts:=strings.Split(s,":")
+	if len(parts)<3||len(parts)>4{
+		return PrevOut{},fmt.Errorf("previous output %q is not TXID:VOUT:SCRIPTPUBKEY[:REDEEMSCRIPT]",s)
+	}
+
+	txid,vout,err:=ParseOutpoint(parts[0]+":"+parts[1])
+	if err!=nil{
+		return PrevOut{},err
+	}
+	prevOut:=PrevOut{Txid:txid,Vout:vout}
+	prevOut.ScriptPubKey,err=hex.DecodeString(parts[2])
+	if err!=nil{
+		return PrevOut{},fmt.Errorf("locking script of previous output %q is not hex encoded",s)
+	}
+	if len(parts)==4{
+		prevOut.RedeemScript,err=hex.DecodeString(parts[3])
+		if err!=nil{
+			return PrevOut{},fmt.Errorf("redeem script of previous output %q is not hex encoded",s)
+		}
+	}
+	return prevOut,nil
+}
+
+//NewRawTransaction creates an unsigned transaction spending the outputs TXID:VOUT and paying the recipients.
+//A non-zero lockTime keeps the transaction out of blocks until it passes.
+func NewRawTransaction(outpoints []string,recipients []Recipient,lockTime uint32) (*Transaction,error){
+	if len(outpoints)==0||len(recipients)==0{
+		return nil,errors.New("transaction needs inputs and recipients")
+	}
+
+	tx:=Transaction{nil,nil,nil,lockTime}
+	for _,outpoint:=range outpoints{
+		txid,vout,err:=ParseOutpoint(outpoint)
+		if err!=nil{
+			return nil,err
+		}
+
+		input:=TXInput{txid,vout,nil,maxSequence}
+		if lockTime>0{
+			input.Sequence=maxSequence-1
+		}
+		tx.Vin=append(tx.Vin,input)
+	}
+	for _,recipient:=range recipients{
+		tx.Vout=append(tx.Vout,*NewTXOutput(recipient.Amount,recipient.Address))
+	}
+	tx.ID=tx.Hash()
+
+	return &tx,nil
+}
+
+//EncodeRawTransaction returns the hex of the canonical encoding of the transaction
+func EncodeRawTransaction(tx *Transaction) string{
+	return hex.EncodeToString(tx.Serialize())
+}
+
+//DecodeRawTransaction parses the hex of a canonically encoded transaction
+func DecodeRawTransaction(s string) (Transaction,error){
+	data,err:=hex.DecodeString(strings.TrimSpace(s))
+	if err!=nil{
+		return Transaction{},errors.New("raw transaction is not hex encoded")
+	}
+	return ParseTransaction(data)
+}
+
+//SignRawTransaction adds the signatures of the wallets' keys to the inputs that spend one of prevOuts with
+//SigHashAll, and reports whether every input is signed. Inputs that are already signed are left as they are,
+//multisig inputs keep the signatures of other owners.
+func (ws *Wallets) SignRawTransaction(tx *Transaction,prevOuts []PrevOut) (bool,error){
+	if ws.IsLocked(){
+		return false,ErrWalletLocked
+	}
+
+	complete:=true
+	for inID,vin:=range tx.Vin{
+		prevOut,ok:=findPrevOut(prevOuts,vin.Txid,vin.Vout)
+		if !ok{
+			complete=false
+			continue
+		}
+
+		out:=TXOutput{0,prevOut.ScriptPubKey}
+		if tx.VerifyInput(inID,out)==nil{
+			continue
+		}
+
+		script:=prevOut.ScriptPubKey
+		if extractScriptHash(script)!=nil{
+			script=prevOut.RedeemScript
+		}
+		for _,wallet:=range ws.Wallets{
+			if !canSign(script,wallet){
+				continue
+			}
+
+			err:=tx.SignInput(inID,wallet,out,prevOut.RedeemScript,SigHashAll)
+			if err!=nil{
+				return false,fmt.Errorf("input %d: %w",inID,err)
+			}
+		}
+
+		if tx.VerifyInput(inID,out)!=nil{
+			complete=false
+		}
+	}
+
+	return complete,nil
+}
+
+//findPrevOut returns the output txid:vout of prevOuts
+func findPrevOut(prevOuts []PrevOut,txid []byte,vout int) (PrevOut,bool){
+	for _,prevOut:=range prevOuts{
+		if bytes.Equal(prevOut.Txid,txid)&&prevOut.Vout==vout{
+			return prevOut,true
+		}
+	}
+	return PrevOut{},false
+}
+
+//canSign reports whether the script pays to the wallet's key, alone or in a multisig
+func canSign(script []byte,wallet *Wallet) bool{
+	switch ClassifyScript(script){
+	case PubKeyHashScript:
+		return bytes.Equal(extractPubKeyHash(script),HashPubKey(wallet.PublicKey))
+	case MultiSigScript:
+		_,pubKeys,_:=extractMultiSig(script)
+		for _,pubKey:=range pubKeys{
+			if bytes.Equal(pubKey,wallet.PublicKey){
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//FindPrevOuts adds the unspent outputs spent by inputs of tx that aren't in prevOuts yet.
+//The UTXO set doesn't know redeem scripts, those of pay-to-script-hash outputs must be in prevOuts.
+func (u UTXOSet) FindPrevOuts(tx *Transaction,prevOuts []PrevOut) []PrevOut{
+	for _,vin:=range tx.Vin{
+		if _,ok:=findPrevOut(prevOuts,vin.Txid,vin.Vout);ok{
+			continue
+		}
+		if out,ok:=u.FindOutput(vin.Txid,vin.Vout);ok{
+			prevOuts=append(prevOuts,PrevOut{Txid:vin.Txid,Vout:vin.Vout,ScriptPubKey:out.ScriptPubKey})
+		}
+	}
+	return prevOuts
+}
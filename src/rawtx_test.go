@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawTransactionEncoding(t *testing.T) {
+	alice := string(NewWallet().GetAddress())
+	txid := hex.EncodeToString(testHash("prev"))
+
+	tx, err := NewRawTransaction([]string{txid + ":1"}, []Recipient{{alice, 3}}, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, TXInput{testHash("prev"), 1, nil, maxSequence - 1}, tx.Vin[0], "Locked transaction has a final sequence below the maximum")
+	assert.Equal(t, *NewTXOutput(3, alice), tx.Vout[0])
+
+	decoded, err := DecodeRawTransaction(EncodeRawTransaction(tx))
+	assert.Nil(t, err)
+	assert.Equal(t, *tx, decoded, "Raw transaction round trips through hex")
+
+	_, err = DecodeRawTransaction("zz")
+	assert.NotNil(t, err)
+	for _, outpoint := range []string{txid, txid + ":x", txid + ":-1", "xy:0"} {
+		_, err = NewRawTransaction([]string{outpoint}, []Recipient{{alice, 3}}, 0)
+		assert.NotNil(t, err, outpoint+" is not an outpoint")
+	}
+	_, err = NewRawTransaction(nil, []Recipient{{alice, 3}}, 0)
+	assert.NotNil(t, err, "Transaction needs inputs")
+
+	prevOut, err := ParsePrevOut(txid + ":2:76a9:a914")
+	assert.Nil(t, err)
+	assert.Equal(t, PrevOut{testHash("prev"), 2, []byte{0x76, 0xa9}, []byte{0xa9, 0x14}}, prevOut)
+	_, err = ParsePrevOut(txid + ":2")
+	assert.NotNil(t, err, "Previous output needs its locking script")
+}
+
+func TestSignRawTransaction(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	aliceWallets := Wallets{Wallets: map[string]*Wallet{string(alice.GetAddress()): alice}}
+	bobWallets := Wallets{Wallets: map[string]*Wallet{string(bob.GetAddress()): bob}}
+
+	multisig, redeemScript, err := aliceWallets.MultiSigAddress(2, []string{string(alice.GetAddress()), hex.EncodeToString(bob.PublicKey)})
+	assert.Nil(t, err)
+	paid := fundScript(alice.LockingScript())
+	shared := fundScript(AddressToScript(multisig))
+	prevOuts := []PrevOut{
+		{paid.ID, 0, paid.Vout[0].ScriptPubKey, nil},
+		{shared.ID, 0, shared.Vout[0].ScriptPubKey, redeemScript},
+	}
+
+	outpoints := []string{fmt.Sprintf("%x:0", paid.ID), fmt.Sprintf("%x:0", shared.ID)}
+	tx, err := NewRawTransaction(outpoints, []Recipient{{string(bob.GetAddress()), 19}}, 0)
+	assert.Nil(t, err)
+	ID := tx.ID
+
+	complete, err := bobWallets.SignRawTransaction(tx, prevOuts[:1])
+	assert.Nil(t, err)
+	assert.False(t, complete, "Inputs without previous output aren't signed")
+	assert.Nil(t, tx.Vin[0].ScriptSig, "Keys of other wallets don't sign")
+
+	complete, err = aliceWallets.SignRawTransaction(tx, prevOuts)
+	assert.Nil(t, err)
+	assert.False(t, complete, "Multisig needs the other owner's signature")
+	assert.Nil(t, tx.VerifyInput(0, paid.Vout[0]))
+	assert.NotNil(t, tx.VerifyInput(1, shared.Vout[0]))
+
+	raw := EncodeRawTransaction(tx)
+	decoded, err := DecodeRawTransaction(raw)
+	assert.Nil(t, err)
+	complete, err = bobWallets.SignRawTransaction(&decoded, prevOuts)
+	assert.Nil(t, err)
+	assert.True(t, complete, "Owners sign one after another")
+	assert.Nil(t, decoded.VerifyInput(1, shared.Vout[0]))
+	assert.Equal(t, ID, decoded.ID, "Signing doesn't change the ID")
+	assert.True(t, decoded.Verify(map[string]Transaction{hex.EncodeToString(paid.ID): paid, hex.EncodeToString(shared.ID): shared}))
+
+	locked := newEncryptedWallets(t, "secret")
+	locked.Lock()
+	_, err = locked.SignRawTransaction(tx, prevOuts)
+	assert.Equal(t, ErrWalletLocked, err)
+}
+
+func TestRawTransactionIsMined(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	UTXOSet := UTXOSet{bc}
+	genesis := bc.Iterator().Next().Transactions[0]
+	other := string(NewWallet().GetAddress())
+	wallets := Wallets{Wallets: map[string]*Wallet{string(miner.GetAddress()): miner}}
+
+	tx, err := NewRawTransaction([]string{fmt.Sprintf("%x:0", genesis.ID)}, []Recipient{{other, 6}}, 0)
+	assert.Nil(t, err)
+	complete, err := wallets.SignRawTransaction(tx, UTXOSet.FindPrevOuts(tx, nil))
+	assert.Nil(t, err)
+	assert.True(t, complete, "Previous outputs are found in the UTXO set")
+
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(miner.GetAddress()), "", subsidy+4), tx})
+	assert.Nil(t, err, "Raw transaction without change pays the rest to the miner")
+	balance, _ := UTXOSet.FindBalance(AddressToScript(other))
+	assert.Equal(t, 6, balance)
+}
@@ -0,0 +1,370 @@
+package main
+
+import(
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"github.com/boltdb/bolt"
+)
+
+//chainWork returns the cumulative work of the chain ending with the block hash
+func chainWork(tx *bolt.Tx,hash []byte) *big.Int{
+	w:=tx.Bucket([]byte(chainworkBucket))
+
+	workData:=w.Get(hash)
+	if workData!=nil{
+		return new(big.Int).SetBytes(workData)
+	}
+
+	//the block was saved before chain work was recorded
+	block:=DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(hash))
+	work:=NewProofOfWork(block).Work()
+	if len(block.PrevBlockHash)>0{
+		work.Add(work,chainWork(tx,block.PrevBlockHash))
+	}
+
+	if tx.Writable(){
+		err:=w.Put(hash,work.Bytes())
+		if err!=nil{
+			log.Panic(err)
+		}
+	}
+	return work
+}
+
+//connectTip validates the transactions of a block extending the tip,
+//applies them to the UTXO set and the indexes and makes the block the new tip
+func connectTip(tx *bolt.Tx,block *Block) error{
+	err:=checkTransactions(tx,block.Transactions,block.Height)
+	if err!=nil{
+		return &BlockError{block.Hash,err}
+	}
+
+	err=connectBlock(tx,block)
+	if err!=nil{
+		return err
+	}
+
+	err=indexBlock(tx,block)
+	if err!=nil{
+		return err
+	}
+
+	return tx.Bucket([]byte(blocksBucket)).Put([]byte("1"),block.Hash)
+}
+
+//disconnectTip removes the tip block from the UTXO set and the indexes and makes its parent the new tip
+func disconnectTip(tx *bolt.Tx,block *Block) error{
+	err:=disconnectBlock(tx,block)
+	if err!=nil{
+		return err
+	}
+
+	err=unindexBlock(tx,block)
+	if err!=nil{
+		return err
+	}
+
+	return tx.Bucket([]byte(blocksBucket)).Put([]byte("1"),block.PrevBlockHash)
+}
+
+//isInvalid reports whether the block was marked invalid
+func isInvalid(tx *bolt.Tx,hash []byte) bool{
+	return tx.Bucket([]byte(invalidBucket)).Get(hash)!=nil
+}
+
+//invalidateBranch marks block and its ancestors down to the block hash invalid
+func invalidateBranch(tx *bolt.Tx,block *Block,hash []byte) error{
+	b:=tx.Bucket([]byte(blocksBucket))
+	invalid:=tx.Bucket([]byte(invalidBucket))
+
+	for{
+		err:=invalid.Put(block.Hash,[]byte{1})
+		if err!=nil{
+			return err
+		}
+		if bytes.Equal(block.Hash,hash){
+			return nil
+		}
+
+		blockData:=b.Get(block.PrevBlockHash)
+		if blockData==nil{
+			return errors.New("Block is not found.")
+		}
+		block=DeserializeBlock(blockData)
+	}
+}
+
+//reorganize switches the main chain from the block tip to the branch ending with block.
+//It returns the transactions of the disconnected blocks that aren't in the new branch.
+func reorganize(tx *bolt.Tx,tip []byte,block *Block) ([]*Transaction,error){
+	var detach []*Block
+	var attach []*Block
+
+	b:=tx.Bucket([]byte(blocksBucket))
+	parentOf:=func(block *Block) (*Block,error){
+		blockData:=b.Get(block.PrevBlockHash)
+		if blockData==nil{
+			return nil,errors.New("Block is not found.")
+		}
+		return DeserializeBlock(blockData),nil
+	}
+
+	var err error
+	oldBlock:=DeserializeBlock(b.Get(tip))
+	newBlock:=block
+
+	for newBlock.Height>oldBlock.Height{
+		attach=append(attach,newBlock)
+		newBlock,err=parentOf(newBlock)
+		if err!=nil{
+			return nil,err
+		}
+	}
+	for oldBlock.Height>newBlock.Height{
+		detach=append(detach,oldBlock)
+		oldBlock,err=parentOf(oldBlock)
+		if err!=nil{
+			return nil,err
+		}
+	}
+	for bytes.Compare(oldBlock.Hash,newBlock.Hash)!=0{
+		detach=append(detach,oldBlock)
+		attach=append(attach,newBlock)
+
+		oldBlock,err=parentOf(oldBlock)
+		if err!=nil{
+			return nil,err
+		}
+		newBlock,err=parentOf(newBlock)
+		if err!=nil{
+			return nil,err
+		}
+	}
+
+	fmt.Printf("Reorganizing chain at %x: %d blocks disconnected, %d connected\n",oldBlock.Hash,len(detach),len(attach))
+
+	for _,block:=range attach{
+		if isInvalid(tx,block.Hash){
+			return nil,&BlockError{block.Hash,ErrInvalidBlock}
+		}
+	}
+
+	for _,block:=range detach{
+		err=disconnectTip(tx,block)
+		if err!=nil{
+			return nil,err
+		}
+	}
+
+	confirmed:=make(map[string]bool)
+	for i:=len(attach)-1;i>=0;i--{
+		err=connectTip(tx,attach[i])
+		if err!=nil{
+			return nil,err
+		}
+
+		for _,transaction:=range attach[i].Transactions{
+			confirmed[hex.EncodeToString(transaction.ID)]=true
+		}
+	}
+
+	var evicted []*Transaction
+	for i:=len(detach)-1;i>=0;i--{
+		for _,transaction:=range detach[i].Transactions{
+			if !transaction.IsCoinbase()&&!confirmed[hex.EncodeToString(transaction.ID)]{
+				evicted=append(evicted,transaction)
+			}
+		}
+	}
+	return evicted,nil
+}
+
+
+//Rollback disconnects the last blocks of the main chain and removes them from the database
+func (bc *Blockchain) Rollback(blocks int) error{
+	var tip []byte
+
+	bc.tipMutex.Lock()
+	defer bc.tipMutex.Unlock()
+
+	err:=bc.db.Update(func(tx *bolt.Tx) error{
+		b:=tx.Bucket([]byte(blocksBucket))
+
+		for i:=0;i<blocks;i++{
+			block:=DeserializeBlock(b.Get(b.Get([]byte("1"))))
+			if len(block.PrevBlockHash)==0{
+				return errors.New("Genesis block can't be rolled back")
+			}
+
+			err:=disconnectTip(tx,block)
+			if err!=nil{
+				return err
+			}
+
+			err=b.Delete(block.Hash)
+			if err!=nil{
+				return err
+			}
+			err=tx.Bucket([]byte(chainworkBucket)).Delete(block.Hash)
+			if err!=nil{
+				return err
+			}
+		}
+
+		tip=b.Get([]byte("1"))
+		return nil
+	})
+	if err!=nil{
+		return err
+	}
+
+	bc.tip=tip
+	return nil
+}
+
+//InvalidateBlock marks the block invalid so it is never connected again.
+//If it is in the main chain, the chain is rewound to its parent, the
+//disconnected descendants are marked invalid too and the side chain with the
+//most work that has no invalid block becomes the main chain.
+func (bc *Blockchain) InvalidateBlock(hash []byte) error{
+	var tip []byte
+
+	bc.tipMutex.Lock()
+	defer bc.tipMutex.Unlock()
+
+	err:=bc.db.Update(func(tx *bolt.Tx) error{
+		b:=tx.Bucket([]byte(blocksBucket))
+		invalid:=tx.Bucket([]byte(invalidBucket))
+
+		blockData:=b.Get(hash)
+		if blockData==nil{
+			return errors.New("Block is not found.")
+		}
+		block:=DeserializeBlock(blockData)
+		if len(block.PrevBlockHash)==0{
+			return errors.New("Genesis block can't be invalidated")
+		}
+
+		err:=invalid.Put(hash,[]byte{1})
+		if err!=nil{
+			return err
+		}
+
+		var detach []*Block
+		current:=DeserializeBlock(b.Get(b.Get([]byte("1"))))
+		for current.Height>=block.Height{
+			detach=append(detach,current)
+			current=DeserializeBlock(b.Get(current.PrevBlockHash))
+		}
+
+		if len(detach)==0||bytes.Compare(detach[len(detach)-1].Hash,hash)!=0{
+			//the block is on a side chain
+			tip=b.Get([]byte("1"))
+			return nil
+		}
+
+		for _,block:=range detach{
+			err=disconnectTip(tx,block)
+			if err!=nil{
+				return err
+			}
+
+			err=invalid.Put(block.Hash,[]byte{1})
+			if err!=nil{
+				return err
+			}
+		}
+
+		tip=b.Get([]byte("1"))
+		return nil
+	})
+	if err!=nil{
+		return err
+	}
+
+	bc.tip=tip
+	return bc.activateBestChain()
+}
+
+//activateBestChain switches to the branch with the most work that has no invalid block, if it has more work
+//than the main chain. A branch that fails to connect is marked invalid and the next best one is tried.
+//The caller holds tipMutex.
+func (bc *Blockchain) activateBestChain() error{
+	for{
+		var best *Block
+		err:=bc.db.View(func(tx *bolt.Tx) error{
+			best=bestValidBlock(tx)
+			return nil
+		})
+		if err!=nil{
+			return err
+		}
+		if best==nil{
+			return nil
+		}
+
+		err=bc.db.Update(func(tx *bolt.Tx) error{
+			_,err:=reorganize(tx,tx.Bucket([]byte(blocksBucket)).Get([]byte("1")),best)
+			return err
+		})
+		if blockErr,ok:=err.(*BlockError);ok{
+			err=bc.db.Update(func(tx *bolt.Tx) error{
+				return invalidateBranch(tx,best,blockErr.Hash)
+			})
+		}else if err==nil{
+			bc.tip=best.Hash
+		}
+		if err!=nil{
+			return err
+		}
+	}
+}
+
+//bestValidBlock returns the block with the most work of those that have more work than the tip
+//of the main chain and descend from no invalid block, nil if there is none
+func bestValidBlock(tx *bolt.Tx) *Block{
+	b:=tx.Bucket([]byte(blocksBucket))
+	var best *Block
+	bestWork:=chainWork(tx,b.Get([]byte("1")))
+
+	c:=b.Cursor()
+	for hash,blockData:=c.First();hash!=nil;hash,blockData=c.Next(){
+		if bytes.Equal(hash,[]byte("1")){
+			continue
+		}
+
+		work:=chainWork(tx,hash)
+		if work.Cmp(bestWork)<=0{
+			continue
+		}
+		block:=DeserializeBlock(blockData)
+		if !isValidBranch(tx,block){
+			continue
+		}
+		best,bestWork=block,work
+	}
+	return best
+}
+
+//isValidBranch reports whether the block and its ancestors down to the main chain aren't marked invalid
+func isValidBranch(tx *bolt.Tx,block *Block) bool{
+	b:=tx.Bucket([]byte(blocksBucket))
+	heights:=tx.Bucket([]byte(heightIndexBucket))
+
+	for !bytes.Equal(heights.Get(IntToHex(int64(block.Height))),block.Hash){
+		if isInvalid(tx,block.Hash){
+			return false
+		}
+
+		blockData:=b.Get(block.PrevBlockHash)
+		if blockData==nil{
+			return false
+		}
+		block=DeserializeBlock(blockData)
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func balanceOf(bc *Blockchain, wallet *Wallet) int {
+	balance := 0
+	for _, out := range (UTXOSet{bc}).FindUTXO(wallet.LockingScript()) {
+		balance += out.Value
+	}
+	return balance
+}
+
+func TestReorganization(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	UTXOSet := UTXOSet{bc}
+	minerAddress := string(miner.GetAddress())
+	genesis := bc.tip
+	other := NewWallet()
+
+	tx, _ := NewUTXOTransaction(miner, string(other.GetAddress()), 3, TxOptions{}, &UTXOSet)
+	a1, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), tx})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, balanceOf(bc, other))
+
+	b1 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)}, genesis, 1, a1.Bits)
+	evicted, err := bc.AddBlock(b1)
+	assert.Nil(t, err, "Side chain block is accepted")
+	assert.Empty(t, evicted)
+	assert.Equal(t, a1.Hash, bc.tip, "Branch with equal work doesn't replace the tip")
+
+	b2 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)}, b1.Hash, 2, b1.Bits)
+	evicted, err = bc.AddBlock(b2)
+	assert.Nil(t, err, "Heavier branch is accepted")
+	assert.Equal(t, b2.Hash, bc.tip, "Heavier branch becomes the main chain")
+	assert.Equal(t, 2, bc.GetBestHeight())
+
+	assert.Len(t, evicted, 1, "Disconnected transaction is returned")
+	assert.Equal(t, tx.ID, evicted[0].ID)
+	assert.Equal(t, 0, balanceOf(bc, other), "Outputs of disconnected blocks are removed")
+	assert.Equal(t, 3*subsidy, balanceOf(bc, miner), "Spent outputs are restored")
+
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), evicted[0]})
+	assert.Nil(t, err, "Evicted transaction can be mined on the new chain")
+	assert.Equal(t, 3, balanceOf(bc, other))
+}
+
+func TestFailedReorganizationMarksBranchInvalid(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	minerAddress := string(miner.GetAddress())
+	genesis := bc.tip
+	other := NewWallet()
+
+	a1, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)})
+	assert.Nil(t, err)
+
+	missing := spend(miner, NewCoinbaseTX(minerAddress, "missing", subsidy), 0, string(other.GetAddress()), 3, 0)
+	b1 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), missing}, genesis, 1, a1.Bits)
+	_, err = bc.AddBlock(b1)
+	assert.Nil(t, err, "Side chain block isn't validated until it is connected")
+
+	b2 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)}, b1.Hash, 2, b1.Bits)
+	_, err = bc.AddBlock(b2)
+	var blockErr *BlockError
+	assert.True(t, errors.As(err, &blockErr))
+	assert.Equal(t, b1.Hash, blockErr.Hash, "Failing block of the branch is reported")
+	assert.True(t, errors.Is(err, ErrMissingInput))
+	assert.Equal(t, a1.Hash, bc.tip, "Main chain is kept")
+	assert.Equal(t, 2*subsidy, balanceOf(bc, miner), "UTXO set is restored")
+	assert.Equal(t, 0, balanceOf(bc, other))
+
+	bc.db.View(func(tx *bolt.Tx) error {
+		assert.True(t, isInvalid(tx, b1.Hash), "Failing block is marked invalid")
+		assert.True(t, isInvalid(tx, b2.Hash), "Descendants of the failing block are marked invalid")
+		assert.False(t, isInvalid(tx, a1.Hash))
+		return nil
+	})
+
+	b3 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)}, b2.Hash, 3, b2.Bits)
+	_, err = bc.AddBlock(b3)
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "Descendant of an invalid block is rejected without connecting the branch again")
+	assert.Equal(t, a1.Hash, bc.tip)
+}
+
+// utxoSnapshot returns the contents of the UTXO bucket
+func utxoSnapshot(t *testing.T, bc *Blockchain) map[string]string {
+	snapshot := make(map[string]string)
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			snapshot[string(k)] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func TestRollbackAndInvalidateRestoreUTXOSet(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	UTXOSet := UTXOSet{bc}
+	minerAddress := string(miner.GetAddress())
+	genesis := bc.Iterator().Next()
+	other := NewWallet()
+	atGenesis := utxoSnapshot(t, bc)
+
+	b1 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "b1", subsidy)}, genesis.Hash, 1, genesis.Bits)
+	_, err := bc.AddBlock(b1)
+	assert.Nil(t, err)
+	atB1 := utxoSnapshot(t, bc)
+
+	tx := spend(miner, genesis.Transactions[0], 0, string(other.GetAddress()), 3, 1)
+	c2 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "c2", subsidy+1), tx}, b1.Hash, 2, b1.Bits)
+	UTXOSet.Update(c2)
+	assert.Equal(t, 3, balanceOf(bc, other))
+	bc.db.View(func(dbTx *bolt.Tx) error {
+		undo := DeserializeBlockUndo(dbTx.Bucket([]byte(undoBucket)).Get(c2.Hash))
+		assert.Equal(t, []SpentOutput{{genesis.Transactions[0].ID, 0, genesis.Transactions[0].Vout[0], 0, true}}, undo.Spent, "Undo record holds the spent outputs")
+		return nil
+	})
+	UTXOSet.Revert(c2)
+	assert.Equal(t, atB1, utxoSnapshot(t, bc), "Revert restores the UTXO set")
+
+	assert.Nil(t, bc.Rollback(1))
+	assert.Equal(t, genesis.Hash, bc.Tip())
+	assert.Equal(t, atGenesis, utxoSnapshot(t, bc), "Rollback restores the UTXO set")
+	_, err = bc.GetBlockByHeight(1)
+	assert.NotNil(t, err, "Rolled back block leaves the height index")
+
+	_, err = bc.AddBlock(b1)
+	assert.Nil(t, err)
+	assert.Equal(t, atB1, utxoSnapshot(t, bc), "Rolled back block can be connected again")
+
+	a1 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "a1", subsidy+1), tx}, genesis.Hash, 1, genesis.Bits)
+	a2 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "a2", subsidy)}, a1.Hash, 2, a1.Bits)
+	for _, block := range []*Block{a1, a2} {
+		_, err = bc.AddBlock(block)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, a2.Hash, bc.Tip(), "Heavier branch becomes the main chain")
+
+	assert.Nil(t, bc.InvalidateBlock(a1.Hash))
+	assert.Equal(t, b1.Hash, bc.Tip(), "Side chain with the most work becomes the main chain")
+	assert.Equal(t, atB1, utxoSnapshot(t, bc), "UTXO set is the one of the side chain")
+	block, err := bc.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b1.Hash, block.Hash)
+
+	a3 := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "a3", subsidy)}, a2.Hash, 3, a2.Bits)
+	_, err = bc.AddBlock(a3)
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "Descendants of invalidated blocks are rejected")
+
+	assert.Nil(t, bc.InvalidateBlock(b1.Hash))
+	assert.Equal(t, genesis.Hash, bc.Tip(), "Invalid branches don't become the main chain")
+	assert.Equal(t, atGenesis, utxoSnapshot(t, bc))
+}
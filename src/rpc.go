@@ -0,0 +1,1032 @@
+package main
+
+import(
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//JSON-RPC 2.0 error codes
+const(
+	rpcParseError=-32700
+	rpcInvalidRequest=-32600
+	rpcMethodNotFound=-32601
+	rpcInvalidParams=-32602
+	rpcInternalError=-32603
+	rpcMiscError=-1
+	rpcWalletUnlockNeeded=-13
+	rpcWalletWrongPassphrase=-14
+	rpcWalletWrongEncState=-15
+)
+
+//maxRPCRequestSize limits the size of a request body
+const maxRPCRequestSize=1<<20
+
+//Every request authenticates with HTTP basic authentication. The credentials are RPC_USER and
+//RPC_PASSWORD when both are set, otherwise the node writes a random password for rpcCookieUser
+//to its cookie file at start, which clients on the same machine read.
+const(
+	rpcCookieFile="rpc_%s.cookie"
+	rpcCookieUser="__cookie__"
+)
+
+type rpcRequest struct{
+	JSONRPC string `json:"jsonrpc"`
+	Method string `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID json.RawMessage `json:"id"`
+}
+
+type rpcResponse struct{
+	JSONRPC string `json:"jsonrpc"`
+	Result interface{} `json:"result"`
+	Error *RPCError `json:"error,omitempty"`
+	ID json.RawMessage `json:"id"`
+}
+
+//RPCError is a JSON-RPC error object
+type RPCError struct{
+	Code int `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string{
+	return fmt.Sprintf("%s (code %d)",e.Message,e.Code)
+}
+
+//RPCServer serves node operations over JSON-RPC 2.0
+type RPCServer struct{
+	node *Node
+	bc *Blockchain
+	nodeID string
+
+	credentials RPCCredentials
+
+	//walletFileMutex serializes the handlers that read and write the wallet file
+	walletFileMutex sync.Mutex
+	//walletMutex guards walletKey and walletLockTimer
+	walletMutex sync.Mutex
+	//walletKey decrypts the wallet file until walletLockTimer fires
+	walletKey []byte
+	walletLockTimer *time.Timer
+}
+
+type rpcHandler func(s *RPCServer,params []json.RawMessage) (interface{},error)
+
+var rpcHandlers map[string]rpcHandler
+
+func init(){
+	rpcHandlers=map[string]rpcHandler{
+		"getblockcount":(*RPCServer).getBlockCount,
+		"getblock":(*RPCServer).getBlock,
+		"getrawtransaction":(*RPCServer).getRawTransaction,
+		"getbalance":walletHandler((*RPCServer).getBalance),
+		"sendtoaddress":walletHandler((*RPCServer).sendToAddress),
+		"sendmany":walletHandler((*RPCServer).sendMany),
+		"createrawtransaction":(*RPCServer).createRawTransaction,
+		"signrawtransaction":walletHandler((*RPCServer).signRawTransaction),
+		"decoderawtransaction":(*RPCServer).decodeRawTransaction,
+		"sendrawtransaction":(*RPCServer).sendRawTransaction,
+		"createmultisig":walletHandler((*RPCServer).createMultiSig),
+		"importaddress":walletHandler((*RPCServer).importAddress),
+		"importpubkey":walletHandler((*RPCServer).importPubKey),
+		"dumpprivkey":walletHandler((*RPCServer).dumpPrivKey),
+		"importprivkey":walletHandler((*RPCServer).importPrivKey),
+		"encryptwallet":walletHandler((*RPCServer).encryptWallet),
+		"walletpassphrase":walletHandler((*RPCServer).walletPassphrase),
+		"walletlock":(*RPCServer).walletLock,
+		"getmempoolinfo":(*RPCServer).getMempoolInfo,
+		"getmempool":(*RPCServer).getMempool,
+		"getpeerinfo":(*RPCServer).getPeerInfo,
+	}
+}
+
+//walletHandler runs a handler with the wallet file to itself, so concurrent requests don't lose each other's changes
+func walletHandler(handler rpcHandler) rpcHandler{
+	return func(s *RPCServer,params []json.RawMessage) (interface{},error){
+		s.walletFileMutex.Lock()
+		defer s.walletFileMutex.Unlock()
+
+		return handler(s,params)
+	}
+}
+
+//NewRPCServer creates the JSON-RPC server of a node, requests must authenticate with the credentials
+func NewRPCServer(nodeID string,n *Node,credentials RPCCredentials) *RPCServer{
+	return &RPCServer{node:n,bc:n.bc,nodeID:nodeID,credentials:credentials}
+}
+
+//StartRPCServer binds address and serves JSON-RPC requests in the background.
+//The credentials come from the environment or a new cookie file.
+func StartRPCServer(address,nodeID string,n *Node) error{
+	credentials,err:=newRPCCredentials(nodeID)
+	if err!=nil{
+		return err
+	}
+	ln,err:=net.Listen("tcp",address)
+	if err!=nil{
+		return err
+	}
+
+	server:=NewRPCServer(nodeID,n,credentials)
+	fmt.Printf("JSON-RPC server is listening on %s\n",ln.Addr())
+	go func(){
+		err:=http.Serve(ln,server)
+		if err!=nil{
+			log.Println("JSON-RPC server stopped:",err)
+		}
+	}()
+	return nil
+}
+
+//ServeHTTP handles a single or a batch JSON-RPC request
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter,r *http.Request){
+	if r.Method!=http.MethodPost{
+		http.Error(w,"JSON-RPC requests must be POSTed",http.StatusMethodNotAllowed)
+		return
+	}
+	user,password,ok:=r.BasicAuth()
+	if !ok||!s.credentials.match(user,password){
+		w.Header().Set("WWW-Authenticate",`Basic realm="jsonrpc"`)
+		http.Error(w,"JSON-RPC credentials are missing or wrong",http.StatusUnauthorized)
+		return
+	}
+	mediaType,_,err:=mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err!=nil||mediaType!="application/json"{
+		http.Error(w,"JSON-RPC requests must have Content-Type application/json",http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body,err:=ioutil.ReadAll(http.MaxBytesReader(w,r.Body,maxRPCRequestSize))
+	if err!=nil{
+		writeRPC(w,rpcResponse{"2.0",nil,&RPCError{rpcParseError,err.Error()},nil})
+		return
+	}
+
+	body=bytes.TrimSpace(body)
+	if len(body)>0&&body[0]=='['{
+		var requests []json.RawMessage
+		err=json.Unmarshal(body,&requests)
+		if err!=nil||len(requests)==0{
+			writeRPC(w,rpcResponse{"2.0",nil,&RPCError{rpcInvalidRequest,"Invalid batch request"},nil})
+			return
+		}
+
+		responses:=[]rpcResponse{}
+		for _,request:=range requests{
+			if response,ok:=s.handle(request);ok{
+				responses=append(responses,response)
+			}
+		}
+		if len(responses)==0{
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeRPC(w,responses)
+		return
+	}
+
+	response,ok:=s.handle(body)
+	if !ok{
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRPC(w,response)
+}
+
+//handle runs one request; notifications, which have no id, get no response
+func (s *RPCServer) handle(data []byte) (rpcResponse,bool){
+	var request rpcRequest
+
+	err:=json.Unmarshal(data,&request)
+	if err!=nil{
+		if json.Valid(data){
+			return rpcResponse{"2.0",nil,&RPCError{rpcInvalidRequest,"Invalid request"},nil},true
+		}
+		return rpcResponse{"2.0",nil,&RPCError{rpcParseError,err.Error()},nil},true
+	}
+	if request.JSONRPC!="2.0"||request.Method==""{
+		return rpcResponse{"2.0",nil,&RPCError{rpcInvalidRequest,"Invalid request"},request.ID},true
+	}
+
+	handler,ok:=rpcHandlers[request.Method]
+	if !ok{
+		return rpcResponse{"2.0",nil,&RPCError{rpcMethodNotFound,"Method not found"},request.ID},request.ID!=nil
+	}
+
+	result,err:=handler(s,request.Params)
+	if request.ID==nil{
+		return rpcResponse{},false
+	}
+	if err!=nil{
+		rpcErr,ok:=err.(*RPCError)
+		if !ok{
+			rpcErr=&RPCError{rpcMiscError,err.Error()}
+		}
+		return rpcResponse{"2.0",nil,rpcErr,request.ID},true
+	}
+
+	return rpcResponse{"2.0",result,nil,request.ID},true
+}
+
+func writeRPC(w http.ResponseWriter,response interface{}){
+	w.Header().Set("Content-Type","application/json")
+
+	err:=json.NewEncoder(w).Encode(response)
+	if err!=nil{
+		log.Println(err)
+	}
+}
+
+//parseParams decodes positional params into targets, the trailing ones are optional
+func parseParams(params []json.RawMessage,required int,targets ...interface{}) error{
+	if len(params)<required||len(params)>len(targets){
+		return &RPCError{rpcInvalidParams,fmt.Sprintf("Expected %d to %d params",required,len(targets))}
+	}
+
+	for i,param:=range params{
+		err:=json.Unmarshal(param,targets[i])
+		if err!=nil{
+			return &RPCError{rpcInvalidParams,fmt.Sprintf("Param %d: %s",i+1,err)}
+		}
+	}
+	return nil
+}
+
+//rpcBlock is the JSON representation of a block
+type rpcBlock struct{
+	Hash string `json:"hash"`
+	Height int `json:"height"`
+	PrevBlockHash string `json:"previousblockhash"`
+	Timestamp int64 `json:"time"`
+	Bits string `json:"bits"`
+	Nonce int `json:"nonce"`
+	Transactions []string `json:"tx"`
+}
+
+//rpcTransaction is the JSON representation of a transaction
+type rpcTransaction struct{
+	ID string `json:"txid"`
+	Hex string `json:"hex"`
+	Vin []rpcInput `json:"vin"`
+	Vout []rpcOutput `json:"vout"`
+	LockTime uint32 `json:"locktime"`
+}
+
+type rpcInput struct{
+	Txid string `json:"txid,omitempty"`
+	Vout int `json:"vout"`
+	ScriptSig string `json:"scriptSig,omitempty"`
+	Sequence uint32 `json:"sequence"`
+}
+
+type rpcOutput struct{
+	Value int `json:"value"`
+	ScriptPubKey string `json:"scriptPubKey"`
+	Type string `json:"type"`
+	Address string `json:"address,omitempty"`
+}
+
+func newRPCTransaction(tx *Transaction) rpcTransaction{
+	result:=rpcTransaction{
+		ID:hex.EncodeToString(tx.ID),
+		Hex:hex.EncodeToString(tx.Serialize()),
+		LockTime:tx.LockTime}
+
+	for _,vin:=range tx.Vin{
+		result.Vin=append(result.Vin,rpcInput{
+			hex.EncodeToString(vin.Txid),
+			vin.Vout,
+			hex.EncodeToString(vin.ScriptSig),
+			vin.Sequence})
+	}
+	for _,vout:=range tx.Vout{
+		address,_:=ScriptToAddress(vout.ScriptPubKey)
+		result.Vout=append(result.Vout,rpcOutput{
+			vout.Value,
+			hex.EncodeToString(vout.ScriptPubKey),
+			ClassifyScript(vout.ScriptPubKey).String(),
+			address})
+	}
+
+	return result
+}
+
+func (s *RPCServer) getBlockCount(params []json.RawMessage) (interface{},error){
+	err:=parseParams(params,0)
+	if err!=nil{
+		return nil,err
+	}
+
+	return s.bc.GetBestHeight(),nil
+}
+
+//getBlock takes a block hash or a height of the main chain
+func (s *RPCServer) getBlock(params []json.RawMessage) (interface{},error){
+	var id interface{}
+
+	err:=parseParams(params,1,&id)
+	if err!=nil{
+		return nil,err
+	}
+
+	var block Block
+	switch id:=id.(type){
+	case float64:
+		block,err=s.bc.GetBlockByHeight(int(id))
+	case string:
+		var hash []byte
+		hash,err=hex.DecodeString(id)
+		if err!=nil{
+			return nil,&RPCError{rpcInvalidParams,"Block hash must be hex encoded"}
+		}
+		block,err=s.bc.GetBlock(hash)
+	default:
+		return nil,&RPCError{rpcInvalidParams,"Expected a block hash or height"}
+	}
+	if err!=nil{
+		return nil,err
+	}
+
+	result:=rpcBlock{
+		Hash:hex.EncodeToString(block.Hash),
+		Height:block.Height,
+		PrevBlockHash:hex.EncodeToString(block.PrevBlockHash),
+		Timestamp:block.Timestamp,
+		Bits:fmt.Sprintf("%08x",block.Bits),
+		Nonce:block.Nonce,
+		Transactions:[]string{}}
+	for _,tx:=range block.Transactions{
+		result.Transactions=append(result.Transactions,hex.EncodeToString(tx.ID))
+	}
+
+	return result,nil
+}
+
+//getRawTransaction returns the hex encoded transaction or, with verbose set, its fields
+func (s *RPCServer) getRawTransaction(params []json.RawMessage) (interface{},error){
+	var txid string
+	verbose:=false
+
+	err:=parseParams(params,1,&txid,&verbose)
+	if err!=nil{
+		return nil,err
+	}
+
+	ID,err:=hex.DecodeString(txid)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,"Transaction ID must be hex encoded"}
+	}
+
+	tx,ok:=s.node.mempool.Get(ID)
+	if !ok{
+		tx,err=s.bc.FindTransaction(ID)
+		if err!=nil{
+			return nil,err
+		}
+	}
+
+	if verbose{
+		return newRPCTransaction(&tx),nil
+	}
+	return hex.EncodeToString(tx.Serialize()),nil
+}
+
+func (s *RPCServer) getBalance(params []json.RawMessage) (interface{},error){
+	var address string
+
+	err:=parseParams(params,0,&address)
+	if err!=nil{
+		return nil,err
+	}
+
+	UTXOSet:=UTXOSet{s.bc}
+	if address==""{
+		wallets,err:=NewWallets(s.nodeID)
+		if err!=nil{
+			return nil,errors.New("Wallet file is not found")
+		}
+		spendable,watchOnly:=wallets.Scripts()
+		balance,immature:=UTXOSet.FindScriptsBalance(spendable)
+		watchOnlyBalance,watchOnlyImmature:=UTXOSet.FindScriptsBalance(watchOnly)
+
+		return map[string]int{"balance":balance,"immature":immature,
+			"watchonly":watchOnlyBalance,"watchonlyimmature":watchOnlyImmature},nil
+	}
+
+	if !ValidateAddress(address){
+		return nil,&RPCError{rpcInvalidParams,"Address is not valid"}
+	}
+	balance,immature:=UTXOSet.FindBalance(AddressToScript(address))
+
+	return map[string]int{"balance":balance,"immature":immature},nil
+}
+
+//sendToAddress pays amount and an optional fee from a wallet of the node and relays the transaction
+func (s *RPCServer) sendToAddress(params []json.RawMessage) (interface{},error){
+	var from,to string
+	var amount,fee int
+
+	err:=parseParams(params,3,&from,&to,&amount,&fee)
+	if err!=nil{
+		return nil,err
+	}
+	if amount<=0{
+		return nil,&RPCError{rpcInvalidParams,"Amount must be positive"}
+	}
+	if !ValidateAddress(to){
+		return nil,&RPCError{rpcInvalidParams,"Address is not valid"}
+	}
+
+	return s.send(from,[]Recipient{{to,amount}},fee)
+}
+
+//sendMany pays a list of {"address","amount"} recipients and an optional fee in one transaction
+func (s *RPCServer) sendMany(params []json.RawMessage) (interface{},error){
+	var from string
+	var entries []Recipient
+	var fee int
+
+	err:=parseParams(params,2,&from,&entries,&fee)
+	if err!=nil{
+		return nil,err
+	}
+	if len(entries)==0{
+		return nil,&RPCError{rpcInvalidParams,"Recipients are missing"}
+	}
+
+	var recipients []Recipient
+	for _,entry:=range entries{
+		recipient,err:=NewRecipient(entry.Address,entry.Amount)
+		if err!=nil{
+			return nil,&RPCError{rpcInvalidParams,err.Error()}
+		}
+		recipients=append(recipients,recipient)
+	}
+
+	return s.send(from,recipients,fee)
+}
+
+//send pays the recipients and the fee from a wallet of the node, relays the transaction and mines it on a mining node
+func (s *RPCServer) send(from string,recipients []Recipient,fee int) (interface{},error){
+	if !ValidateAddress(from){
+		return nil,&RPCError{rpcInvalidParams,"Address is not valid"}
+	}
+	if fee<0{
+		return nil,&RPCError{rpcInvalidParams,"Fee can't be negative"}
+	}
+
+	wallets,err:=s.openWallets()
+	if err!=nil{
+		return nil,err
+	}
+	wallet,err:=wallets.SigningWallet(from)
+	if errors.Is(err,ErrWalletLocked){
+		return nil,&RPCError{rpcWalletUnlockNeeded,"Wallet is locked, unlock it with walletpassphrase first"}
+	}
+	if err!=nil{
+		return nil,err
+	}
+
+	UTXOSet:=UTXOSet{s.bc}
+	tx,err:=NewBatchTransaction(wallet,recipients,TxOptions{Fee:fee},&UTXOSet)
+	if err!=nil{
+		return nil,err
+	}
+	err=s.node.mempool.Add(tx,s.bc)
+	if err!=nil{
+		return nil,err
+	}
+	s.node.broadcastInv("tx",[][]byte{tx.ID})
+	s.node.minePending()
+
+	return hex.EncodeToString(tx.ID),nil
+}
+
+//rpcPrevOut is an output spent by a raw transaction, with the scripts in hex
+type rpcPrevOut struct{
+	Txid string `json:"txid"`
+	Vout int `json:"vout"`
+	ScriptPubKey string `json:"scriptPubKey"`
+	RedeemScript string `json:"redeemScript,omitempty"`
+}
+
+//createRawTransaction returns the hex of an unsigned transaction spending a list of {"txid","vout"}
+//and paying a list of {"address","amount"} recipients, with an optional locktime
+func (s *RPCServer) createRawTransaction(params []json.RawMessage) (interface{},error){
+	var inputs []rpcPrevOut
+	var entries []Recipient
+	var lockTime uint32
+
+	err:=parseParams(params,2,&inputs,&entries,&lockTime)
+	if err!=nil{
+		return nil,err
+	}
+
+	var outpoints []string
+	for _,input:=range inputs{
+		outpoints=append(outpoints,fmt.Sprintf("%s:%d",input.Txid,input.Vout))
+	}
+	var recipients []Recipient
+	for _,entry:=range entries{
+		recipient,err:=NewRecipient(entry.Address,entry.Amount)
+		if err!=nil{
+			return nil,&RPCError{rpcInvalidParams,err.Error()}
+		}
+		recipients=append(recipients,recipient)
+	}
+
+	tx,err:=NewRawTransaction(outpoints,recipients,lockTime)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,err.Error()}
+	}
+	return EncodeRawTransaction(tx),nil
+}
+
+//signRawTransaction signs a raw transaction with the keys of the node's wallet. Spent outputs that
+//aren't in the optional list of {"txid","vout","scriptPubKey","redeemScript"} are looked up in the UTXO set.
+func (s *RPCServer) signRawTransaction(params []json.RawMessage) (interface{},error){
+	var rawTx string
+	var entries []rpcPrevOut
+
+	err:=parseParams(params,1,&rawTx,&entries)
+	if err!=nil{
+		return nil,err
+	}
+	tx,err:=DecodeRawTransaction(rawTx)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,err.Error()}
+	}
+
+	var prevOuts []PrevOut
+	for _,entry:=range entries{
+		arg:=fmt.Sprintf("%s:%d:%s",entry.Txid,entry.Vout,entry.ScriptPubKey)
+		if entry.RedeemScript!=""{
+			arg+=":"+entry.RedeemScript
+		}
+		prevOut,err:=ParsePrevOut(arg)
+		if err!=nil{
+			return nil,&RPCError{rpcInvalidParams,err.Error()}
+		}
+		prevOuts=append(prevOuts,prevOut)
+	}
+	prevOuts=UTXOSet{s.bc}.FindPrevOuts(&tx,prevOuts)
+
+	wallets,err:=s.openWallets()
+	if err!=nil{
+		return nil,err
+	}
+	complete,err:=wallets.SignRawTransaction(&tx,prevOuts)
+	if errors.Is(err,ErrWalletLocked){
+		return nil,&RPCError{rpcWalletUnlockNeeded,"Wallet is locked, unlock it with walletpassphrase first"}
+	}
+	if err!=nil{
+		return nil,err
+	}
+
+	return map[string]interface{}{"hex":EncodeRawTransaction(&tx),"complete":complete},nil
+}
+
+//decodeRawTransaction returns the JSON representation of a raw transaction
+func (s *RPCServer) decodeRawTransaction(params []json.RawMessage) (interface{},error){
+	var rawTx string
+
+	err:=parseParams(params,1,&rawTx)
+	if err!=nil{
+		return nil,err
+	}
+	tx,err:=DecodeRawTransaction(rawTx)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,err.Error()}
+	}
+
+	return newRPCTransaction(&tx),nil
+}
+
+//sendRawTransaction adds a signed raw transaction to the mempool, relays it and mines it on a mining node
+func (s *RPCServer) sendRawTransaction(params []json.RawMessage) (interface{},error){
+	var rawTx string
+
+	err:=parseParams(params,1,&rawTx)
+	if err!=nil{
+		return nil,err
+	}
+	tx,err:=DecodeRawTransaction(rawTx)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,err.Error()}
+	}
+
+	err=s.node.mempool.Add(&tx,s.bc)
+	if err!=nil{
+		return nil,err
+	}
+	s.node.broadcastInv("tx",[][]byte{tx.ID})
+	s.node.minePending()
+
+	return hex.EncodeToString(tx.ID),nil
+}
+
+//importAddress adds a watch-only address to the wallet file of the node
+func (s *RPCServer) importAddress(params []json.RawMessage) (interface{},error){
+	var address string
+
+	err:=parseParams(params,1,&address)
+	if err!=nil{
+		return nil,err
+	}
+
+	wallets,err:=NewWallets(s.nodeID)
+	if err!=nil&&!os.IsNotExist(err){
+		return nil,err
+	}
+	err=wallets.ImportAddress(address)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,err.Error()}
+	}
+	wallets.SaveToFile(s.nodeID)
+
+	return address,nil
+}
+
+//importPubKey adds the address of a public key in hex to the wallet file of the node as watch-only
+func (s *RPCServer) importPubKey(params []json.RawMessage) (interface{},error){
+	var pubKey string
+
+	err:=parseParams(params,1,&pubKey)
+	if err!=nil{
+		return nil,err
+	}
+	key,err:=hex.DecodeString(pubKey)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,"Public key is not hex"}
+	}
+
+	wallets,err:=NewWallets(s.nodeID)
+	if err!=nil&&!os.IsNotExist(err){
+		return nil,err
+	}
+	address,err:=wallets.ImportPubKey(key)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,err.Error()}
+	}
+	wallets.SaveToFile(s.nodeID)
+
+	return address,nil
+}
+
+//dumpPrivKey returns the private key of an address of the node's wallet
+func (s *RPCServer) dumpPrivKey(params []json.RawMessage) (interface{},error){
+	var address string
+
+	err:=parseParams(params,1,&address)
+	if err!=nil{
+		return nil,err
+	}
+
+	wallets,err:=s.openWallets()
+	if err!=nil{
+		return nil,err
+	}
+	key,err:=wallets.DumpPrivateKey(address)
+	if errors.Is(err,ErrWalletLocked){
+		return nil,&RPCError{rpcWalletUnlockNeeded,"Wallet is locked, unlock it with walletpassphrase first"}
+	}
+	if err!=nil{
+		return nil,err
+	}
+
+	return key,nil
+}
+
+//importPrivKey adds a private key to the node's wallet and, unless rescan is false,
+//returns the balance of its address in the UTXO set
+func (s *RPCServer) importPrivKey(params []json.RawMessage) (interface{},error){
+	var key string
+	rescan:=true
+
+	err:=parseParams(params,1,&key,&rescan)
+	if err!=nil{
+		return nil,err
+	}
+
+	wallets,err:=s.openWallets()
+	if err!=nil{
+		return nil,err
+	}
+	address,err:=wallets.ImportPrivateKey(key)
+	if errors.Is(err,ErrWalletLocked){
+		return nil,&RPCError{rpcWalletUnlockNeeded,"Wallet is locked, unlock it with walletpassphrase first"}
+	}
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,err.Error()}
+	}
+	wallets.SaveToFile(s.nodeID)
+
+	result:=map[string]interface{}{"address":address}
+	if rescan{
+		UTXOSet:=UTXOSet{s.bc}
+		result["balance"],result["immature"]=UTXOSet.FindBalance(AddressToScript(address))
+	}
+	return result,nil
+}
+
+//encryptWallet encrypts the wallet file of the node with a passphrase
+func (s *RPCServer) encryptWallet(params []json.RawMessage) (interface{},error){
+	var passphrase string
+
+	err:=parseParams(params,1,&passphrase)
+	if err!=nil{
+		return nil,err
+	}
+
+	wallets,err:=NewWallets(s.nodeID)
+	if err!=nil{
+		return nil,errors.New("Wallet file is not found")
+	}
+	if wallets.IsEncrypted(){
+		return nil,&RPCError{rpcWalletWrongEncState,"Wallet is already encrypted"}
+	}
+	err=wallets.Encrypt(passphrase)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,err.Error()}
+	}
+	wallets.SaveToFile(s.nodeID)
+
+	return "Wallet encrypted, unlock it with walletpassphrase to send",nil
+}
+
+//walletPassphrase unlocks the encrypted wallet file of the node for a number of seconds.
+//Only the key derived from the passphrase is kept, the file is decrypted whenever a wallet is needed.
+func (s *RPCServer) walletPassphrase(params []json.RawMessage) (interface{},error){
+	var passphrase string
+	var timeout int
+
+	err:=parseParams(params,2,&passphrase,&timeout)
+	if err!=nil{
+		return nil,err
+	}
+	if timeout<=0{
+		return nil,&RPCError{rpcInvalidParams,"Timeout must be positive"}
+	}
+
+	wallets,err:=NewWallets(s.nodeID)
+	if err!=nil{
+		return nil,errors.New("Wallet file is not found")
+	}
+	err=wallets.Unlock(passphrase)
+	if errors.Is(err,ErrWalletNotEncrypted){
+		return nil,&RPCError{rpcWalletWrongEncState,"Wallet is not encrypted"}
+	}
+	if errors.Is(err,ErrWrongPassphrase){
+		return nil,&RPCError{rpcWalletWrongPassphrase,"Passphrase is wrong"}
+	}
+	if err!=nil{
+		return nil,err
+	}
+
+	s.walletMutex.Lock()
+	defer s.walletMutex.Unlock()
+
+	s.lockWallet()
+	s.walletKey=append([]byte{},wallets.key...)
+	wallets.Lock()
+
+	var timer *time.Timer
+	timer=time.AfterFunc(time.Duration(timeout)*time.Second,func(){
+		s.walletMutex.Lock()
+		defer s.walletMutex.Unlock()
+
+		//A later walletpassphrase replaces the timer
+		if s.walletLockTimer==timer{
+			s.lockWallet()
+		}
+	})
+	s.walletLockTimer=timer
+
+	return map[string]int64{"unlockeduntil":time.Now().Add(time.Duration(timeout)*time.Second).Unix()},nil
+}
+
+//walletLock locks the wallet file of the node before the timeout of walletpassphrase
+func (s *RPCServer) walletLock(params []json.RawMessage) (interface{},error){
+	err:=parseParams(params,0)
+	if err!=nil{
+		return nil,err
+	}
+
+	s.walletMutex.Lock()
+	defer s.walletMutex.Unlock()
+	s.lockWallet()
+
+	return map[string]bool{"locked":true},nil
+}
+
+//lockWallet forgets the wallet key, the caller holds walletMutex
+func (s *RPCServer) lockWallet(){
+	if s.walletLockTimer!=nil{
+		s.walletLockTimer.Stop()
+		s.walletLockTimer=nil
+	}
+	for i:=range s.walletKey{
+		s.walletKey[i]=0
+	}
+	s.walletKey=nil
+}
+
+//openWallets loads the wallet file of the node, unlocked if walletpassphrase unlocked it
+func (s *RPCServer) openWallets() (*Wallets,error){
+	wallets,err:=NewWallets(s.nodeID)
+	if err!=nil{
+		return nil,errors.New("Wallet file is not found")
+	}
+	if !wallets.IsLocked(){
+		return wallets,nil
+	}
+
+	s.walletMutex.Lock()
+	key:=append([]byte{},s.walletKey...)
+	s.walletMutex.Unlock()
+
+	if len(key)>0{
+		err=wallets.unlockWithKey(key)
+		if err!=nil{
+			return nil,err
+		}
+	}
+	return wallets,nil
+}
+
+//createMultiSig returns the pay-to-script-hash address and redeem script of a multisig
+//of the required number of keys, which are addresses of the node's wallet or public keys in hex
+func (s *RPCServer) createMultiSig(params []json.RawMessage) (interface{},error){
+	var required int
+	var keys []string
+
+	err:=parseParams(params,2,&required,&keys)
+	if err!=nil{
+		return nil,err
+	}
+
+	wallets,_:=NewWallets(s.nodeID)
+	address,redeemScript,err:=wallets.MultiSigAddress(required,keys)
+	if err!=nil{
+		return nil,&RPCError{rpcInvalidParams,err.Error()}
+	}
+
+	return map[string]string{"address":address,"redeemScript":hex.EncodeToString(redeemScript)},nil
+}
+
+func (s *RPCServer) getMempoolInfo(params []json.RawMessage) (interface{},error){
+	err:=parseParams(params,0)
+	if err!=nil{
+		return nil,err
+	}
+
+	return map[string]int{"size":s.node.mempool.Count(),"bytes":s.node.mempool.Size()},nil
+}
+
+//getMempool lists the pending transactions in the order they would be mined
+func (s *RPCServer) getMempool(params []json.RawMessage) (interface{},error){
+	err:=parseParams(params,0)
+	if err!=nil{
+		return nil,err
+	}
+
+	return s.node.mempool.Info(),nil
+}
+
+//getPeerInfo lists the connected peers with their direction and misbehavior score
+func (s *RPCServer) getPeerInfo(params []json.RawMessage) (interface{},error){
+	err:=parseParams(params,0)
+	if err!=nil{
+		return nil,err
+	}
+
+	return s.node.PeerInfo(),nil
+}
+
+//defaultRPCAddress returns the RPC address of a node, its port is NODE_ID+1000
+func defaultRPCAddress(nodeID string) string{
+	port,err:=strconv.Atoi(nodeID)
+	if err!=nil{
+		return ""
+	}
+
+	return fmt.Sprintf("localhost:%d",port+1000)
+}
+
+//RPCCredentials are the user and password every JSON-RPC request authenticates with
+type RPCCredentials struct{
+	User string
+	Password string
+}
+
+//match compares the credentials in constant time
+func (c RPCCredentials) match(user,password string) bool{
+	a:=sha256.Sum256([]byte(c.User+":"+c.Password))
+	b:=sha256.Sum256([]byte(user+":"+password))
+	return c.Password!=""&&subtle.ConstantTimeCompare(a[:],b[:])==1
+}
+
+//rpcEnvCredentials returns RPC_USER and RPC_PASSWORD if both are set
+func rpcEnvCredentials() (RPCCredentials,bool){
+	credentials:=RPCCredentials{os.Getenv("RPC_USER"),os.Getenv("RPC_PASSWORD")}
+	return credentials,credentials.User!=""&&credentials.Password!=""
+}
+
+//newRPCCredentials returns the credentials of the environment, or writes a random password to the cookie file of the node
+func newRPCCredentials(nodeID string) (RPCCredentials,error){
+	if credentials,ok:=rpcEnvCredentials();ok{
+		return credentials,nil
+	}
+
+	password:=make([]byte,32)
+	_,err:=rand.Read(password)
+	if err!=nil{
+		return RPCCredentials{},err
+	}
+	credentials:=RPCCredentials{rpcCookieUser,hex.EncodeToString(password)}
+
+	err=ioutil.WriteFile(fmt.Sprintf(rpcCookieFile,nodeID),[]byte(credentials.User+":"+credentials.Password),0600)
+	if err!=nil{
+		return RPCCredentials{},err
+	}
+	return credentials,nil
+}
+
+//LoadRPCCredentials returns the credentials of the environment, or those of the cookie file of a running node
+func LoadRPCCredentials(nodeID string) (RPCCredentials,error){
+	if credentials,ok:=rpcEnvCredentials();ok{
+		return credentials,nil
+	}
+
+	cookie,err:=ioutil.ReadFile(fmt.Sprintf(rpcCookieFile,nodeID))
+	if err!=nil{
+		return RPCCredentials{},fmt.Errorf("JSON-RPC credentials aren't set in RPC_USER and RPC_PASSWORD and the cookie file isn't readable: %w",err)
+	}
+	user,password,ok:=strings.Cut(strings.TrimSpace(string(cookie)),":")
+	if !ok{
+		return RPCCredentials{},errors.New("JSON-RPC cookie file is malformed")
+	}
+	return RPCCredentials{user,password},nil
+}
+
+//CallRPC sends a JSON-RPC request to the node at address and returns the result
+func CallRPC(address string,credentials RPCCredentials,method string,params []interface{}) (json.RawMessage,error){
+	if params==nil{
+		params=[]interface{}{}
+	}
+
+	request,err:=json.Marshal(map[string]interface{}{
+		"jsonrpc":"2.0",
+		"method":method,
+		"params":params,
+		"id":1})
+	if err!=nil{
+		return nil,err
+	}
+
+	httpRequest,err:=http.NewRequest(http.MethodPost,"http://"+address,bytes.NewReader(request))
+	if err!=nil{
+		return nil,err
+	}
+	httpRequest.Header.Set("Content-Type","application/json")
+	httpRequest.SetBasicAuth(credentials.User,credentials.Password)
+
+	resp,err:=http.DefaultClient.Do(httpRequest)
+	if err!=nil{
+		return nil,err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode==http.StatusUnauthorized{
+		return nil,errors.New("JSON-RPC credentials were rejected")
+	}
+
+	var response struct{
+		Result json.RawMessage `json:"result"`
+		Error *RPCError `json:"error"`
+	}
+	err=json.NewDecoder(resp.Body).Decode(&response)
+	if err!=nil{
+		return nil,err
+	}
+	if response.Error!=nil{
+		return nil,response.Error
+	}
+
+	return response.Result,nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testRPCCredentials = RPCCredentials{"user", "secret"}
+
+// newTestRPCServer serves JSON-RPC for a node of the test blockchain, whose wallet file holds the miner
+func newTestRPCServer(t *testing.T, credentials RPCCredentials) (*Blockchain, *Wallet, *Node, string) {
+	bc, miner := newTestBlockchain(t)
+	wallets := Wallets{Wallets: map[string]*Wallet{string(miner.GetAddress()): miner}}
+	wallets.SaveToFile("test")
+
+	n := NewNode("127.0.0.1:0", "", nil, bc)
+	server := httptest.NewServer(NewRPCServer("test", n, credentials))
+	t.Cleanup(server.Close)
+	return bc, miner, n, strings.TrimPrefix(server.URL, "http://")
+}
+
+func postRPC(t *testing.T, address string, credentials *RPCCredentials, contentType, body string) (int, string) {
+	request, err := http.NewRequest(http.MethodPost, "http://"+address, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	if credentials != nil {
+		request.SetBasicAuth(credentials.User, credentials.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func rpcErrorCode(err error) int {
+	if rpcErr, ok := err.(*RPCError); ok {
+		return rpcErr.Code
+	}
+	return 0
+}
+
+// captureOutput returns what f prints to stdout
+func captureOutput(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	f()
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	return string(out)
+}
+
+func TestRPCServerAuthentication(t *testing.T) {
+	_, _, _, address := newTestRPCServer(t, testRPCCredentials)
+	request := `{"jsonrpc":"2.0","method":"getblockcount","id":1}`
+
+	status, _ := postRPC(t, address, &testRPCCredentials, "application/json", request)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = postRPC(t, address, &testRPCCredentials, "application/json; charset=utf-8", request)
+	assert.Equal(t, http.StatusOK, status, "Media type parameters are allowed")
+
+	status, _ = postRPC(t, address, nil, "application/json", request)
+	assert.Equal(t, http.StatusUnauthorized, status, "Requests without credentials are rejected")
+	status, _ = postRPC(t, address, &RPCCredentials{"user", "wrong"}, "application/json", request)
+	assert.Equal(t, http.StatusUnauthorized, status, "Requests with a wrong password are rejected")
+	status, _ = postRPC(t, address, &testRPCCredentials, "text/plain", request)
+	assert.Equal(t, http.StatusUnsupportedMediaType, status, "Requests must be JSON")
+	status, _ = postRPC(t, address, &testRPCCredentials, "", request)
+	assert.Equal(t, http.StatusUnsupportedMediaType, status, "Requests must have a Content-Type")
+
+	resp, err := http.Get("http://" + address)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	_, err = CallRPC(address, RPCCredentials{"user", "wrong"}, "getblockcount", nil)
+	assert.EqualError(t, err, "JSON-RPC credentials were rejected")
+
+	_, _, _, open := newTestRPCServer(t, RPCCredentials{"user", ""})
+	status, _ = postRPC(t, open, &RPCCredentials{"user", ""}, "application/json", request)
+	assert.Equal(t, http.StatusUnauthorized, status, "Empty password never matches")
+}
+
+func TestRPCMethods(t *testing.T) {
+	bc, miner, n, address := newTestRPCServer(t, testRPCCredentials)
+	genesis := bc.Iterator().Next()
+	other := string(NewWallet().GetAddress())
+	call := func(method string, params ...interface{}) (json.RawMessage, error) {
+		return CallRPC(address, testRPCCredentials, method, params)
+	}
+
+	result, err := call("getblockcount")
+	assert.Nil(t, err)
+	assert.JSONEq(t, "0", string(result))
+
+	var block rpcBlock
+	result, err = call("getblock", 0)
+	assert.Nil(t, err)
+	json.Unmarshal(result, &block)
+	assert.Equal(t, hex.EncodeToString(genesis.Hash), block.Hash, "Blocks are found by height")
+	result, err = call("getblock", block.Hash)
+	assert.Nil(t, err)
+	json.Unmarshal(result, &block)
+	assert.Equal(t, []string{hex.EncodeToString(genesis.Transactions[0].ID)}, block.Transactions, "Blocks are found by hash")
+
+	var tx rpcTransaction
+	result, err = call("getrawtransaction", block.Transactions[0], true)
+	assert.Nil(t, err)
+	json.Unmarshal(result, &tx)
+	assert.Equal(t, subsidy, tx.Vout[0].Value)
+	assert.Equal(t, string(miner.GetAddress()), tx.Vout[0].Address)
+
+	result, err = call("getbalance", string(miner.GetAddress()))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"balance":10,"immature":0}`, string(result))
+
+	result, err = call("sendtoaddress", string(miner.GetAddress()), other, 3, 1)
+	assert.Nil(t, err)
+	var txid string
+	json.Unmarshal(result, &txid)
+	assert.Equal(t, 1, n.mempool.Count(), "Sent transaction is pending")
+
+	var infos []mempoolInfo
+	result, err = call("getmempool")
+	assert.Nil(t, err)
+	json.Unmarshal(result, &infos)
+	assert.Len(t, infos, 1)
+	assert.Equal(t, txid, infos[0].ID)
+	assert.Equal(t, 1, infos[0].Fee)
+	result, err = call("getrawtransaction", txid)
+	assert.Nil(t, err, "Pending transactions are found in the mempool")
+
+	_, err = call("getblockcounts")
+	assert.Equal(t, rpcMethodNotFound, rpcErrorCode(err))
+	_, err = call("getblockcount", 1)
+	assert.Equal(t, rpcInvalidParams, rpcErrorCode(err), "Extra params are invalid")
+	_, err = call("getblock")
+	assert.Equal(t, rpcInvalidParams, rpcErrorCode(err), "Missing params are invalid")
+	_, err = call("getblock", "zz")
+	assert.Equal(t, rpcInvalidParams, rpcErrorCode(err))
+	_, err = call("sendtoaddress", string(miner.GetAddress()), other, 0)
+	assert.Equal(t, rpcInvalidParams, rpcErrorCode(err), "Amount must be positive")
+	_, err = call("sendtoaddress", string(miner.GetAddress()), other, 100)
+	assert.Equal(t, rpcMiscError, rpcErrorCode(err), "Other errors have the misc code")
+}
+
+func TestRPCSubmissionsAreMined(t *testing.T) {
+	bc, miner, n, address := newTestRPCServer(t, testRPCCredentials)
+	other := NewWallet()
+	mineCoinbase(t, bc, other)
+	wallets := Wallets{Wallets: map[string]*Wallet{string(miner.GetAddress()): miner, string(other.GetAddress()): other}}
+	wallets.SaveToFile("test")
+	n.miningAddress = string(miner.GetAddress())
+	t.Cleanup(n.Stop)
+
+	receiver := NewWallet()
+	to := string(receiver.GetAddress())
+	_, err := CallRPC(address, testRPCCredentials, "sendtoaddress", []interface{}{string(miner.GetAddress()), to, 3, 1})
+	assert.Nil(t, err)
+	_, err = CallRPC(address, testRPCCredentials, "sendtoaddress", []interface{}{string(other.GetAddress()), to, 4, 1})
+	assert.Nil(t, err)
+
+	waitFor(t, "transactions sent over RPC weren't mined", func() bool { return bc.GetBestHeight() == 2 })
+	waitFor(t, "mined transactions weren't removed from the mempool", func() bool { return n.mempool.Count() == 0 })
+	assert.Equal(t, 7, balanceOf(bc, receiver))
+}
+
+func TestRPCPeerInfo(t *testing.T) {
+	_, _, n, address := newTestRPCServer(t, testRPCCredentials)
+	n.addKnownNode("localhost:3009")
+
+	outbound, remote := net.Pipe()
+	defer remote.Close()
+	n.registerPeer(newPeer(n, outbound, "localhost:3001", false))
+	inbound, remote := net.Pipe()
+	defer remote.Close()
+	p := newPeer(n, inbound, "", true)
+	p.identify("localhost:3002")
+	p.misbehave(unknownCommandScore, errors.New("unknown command"))
+
+	// Both pipes have the remote host "pipe", so they share its score
+	result, err := CallRPC(address, testRPCCredentials, "getpeerinfo", nil)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[
+		{"addr":"localhost:3001","inbound":false,"banscore":10},
+		{"addr":"localhost:3002","inbound":true,"banscore":10}
+	]`, string(result), "Connected peers are listed, known nodes aren't")
+}
+
+func TestRPCBatchRequests(t *testing.T) {
+	_, _, _, address := newTestRPCServer(t, testRPCCredentials)
+	post := func(body string) (int, string) {
+		return postRPC(t, address, &testRPCCredentials, "application/json", body)
+	}
+
+	status, body := post(`[
+		{"jsonrpc":"2.0","method":"getblockcount","id":1},
+		{"jsonrpc":"2.0","method":"getmempoolinfo"},
+		{"jsonrpc":"2.0","method":"getblockcounts","id":"b"}
+	]`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `[
+		{"jsonrpc":"2.0","result":0,"id":1},
+		{"jsonrpc":"2.0","result":null,"error":{"code":-32601,"message":"Method not found"},"id":"b"}
+	]`, body, "Notifications in a batch get no response")
+
+	status, _ = post(`[{"jsonrpc":"2.0","method":"getblockcount"},{"jsonrpc":"2.0","method":"getmempoolinfo"}]`)
+	assert.Equal(t, http.StatusNoContent, status, "Batch of notifications gets no response")
+	status, _ = post(`{"jsonrpc":"2.0","method":"getblockcount"}`)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	_, body = post(`[]`)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","result":null,"error":{"code":-32600,"message":"Invalid batch request"},"id":null}`, body)
+	_, body = post(`[1]`)
+	assert.JSONEq(t, `[{"jsonrpc":"2.0","result":null,"error":{"code":-32600,"message":"Invalid request"},"id":null}]`, body)
+	_, body = post(`{"jsonrpc":"1.0","method":"getblockcount","id":2}`)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","result":null,"error":{"code":-32600,"message":"Invalid request"},"id":2}`, body)
+
+	var response rpcResponse
+	_, body = post(`{"jsonrpc":"2.0","method":`)
+	json.Unmarshal([]byte(body), &response)
+	assert.Equal(t, rpcParseError, response.Error.Code)
+}
+
+func TestRPCWalletRequestsAreSerialized(t *testing.T) {
+	_, _, _, address := newTestRPCServer(t, testRPCCredentials)
+
+	var keys []*Wallet
+	for i := 0; i < 8; i++ {
+		keys = append(keys, NewWallet())
+	}
+
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key *Wallet) {
+			defer wg.Done()
+			_, err := CallRPC(address, testRPCCredentials, "importprivkey", []interface{}{EncodePrivateKey(key), false})
+			assert.Nil(t, err)
+		}(key)
+	}
+	wg.Wait()
+
+	wallets, err := NewWallets("test")
+	assert.Nil(t, err)
+	for _, key := range keys {
+		assert.Contains(t, wallets.GetAddresses(), string(key.GetAddress()), "Concurrent imports don't lose each other's keys")
+	}
+}
+
+func TestRPCClientCommands(t *testing.T) {
+	bc, miner, _, _ := newTestRPCServer(t, testRPCCredentials)
+	credentials, err := newRPCCredentials("test")
+	assert.Nil(t, err)
+	loaded, err := LoadRPCCredentials("test")
+	assert.Nil(t, err)
+	assert.Equal(t, credentials, loaded, "Clients read the credentials of the cookie file")
+
+	server := httptest.NewServer(NewRPCServer("test", NewNode("127.0.0.1:0", "", nil, bc), credentials))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+	cli := CLI{}
+
+	assert.Equal(t, "0\n", captureOutput(t, func() { cli.rpc(address, "getblockcount", nil, "test") }))
+	assert.Equal(t, "Mempool is empty\n", captureOutput(t, func() { cli.getMempool(address, "test") }))
+
+	out := captureOutput(t, func() {
+		cli.rpc(address, "sendtoaddress", []string{string(miner.GetAddress()), string(NewWallet().GetAddress()), "3", "2"}, "test")
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	var txid string
+	assert.Nil(t, json.Unmarshal([]byte(lines[len(lines)-1]), &txid), "Result is printed as JSON")
+
+	out = captureOutput(t, func() { cli.getMempool(address, "test") })
+	assert.Contains(t, out, "Transaction "+txid+"\n")
+	assert.Contains(t, out, "  Fee: 2\n")
+}
@@ -0,0 +1,727 @@
+package main
+
+import(
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//Outputs are locked with a script and inputs unlock them with another script.
+//A script is a sequence of opcodes run on a stack of byte strings. An input is valid
+//when its unlocking script, which may only push data, followed by the locking script
+//of the output it spends leaves a true value on top of the stack.
+//When the locking script is pay-to-script-hash, the last item pushed by the unlocking
+//script is the redeem script. It must hash to the locking script's hash and is then
+//run on the rest of the unlocking data.
+
+//Opcodes of the script language
+const(
+	//Op0 pushes an empty byte string, opcodes 0x01-0x4b push the next that many bytes
+	Op0=0x00
+	//OpPushData1 pushes the number of bytes given by the next byte
+	OpPushData1=0x4c
+	//OpPushData2 pushes the number of bytes given by the next 2 bytes, little endian
+	OpPushData2=0x4d
+	//Op1 to Op16 push the numbers 1 to 16
+	Op1=0x51
+	Op16=0x60
+	OpVerify=0x69
+	OpReturn=0x6a
+	OpDrop=0x75
+	OpDup=0x76
+	OpEqual=0x87
+	OpEqualVerify=0x88
+	OpHash160=0xa9
+	OpCheckSig=0xac
+	OpCheckSigVerify=0xad
+	OpCheckMultiSig=0xae
+	OpCheckMultiSigVerify=0xaf
+	//OpCheckLockTimeVerify fails unless the transaction locktime has passed the number on top of the stack
+	OpCheckLockTimeVerify=0xb1
+	//OpCheckSequenceVerify fails unless the input is locked relative to its output for the number on top of the stack
+	OpCheckSequenceVerify=0xb2
+)
+
+//Script limits
+const(
+	maxScriptSize=10000
+	maxScriptElementSize=520
+	maxStackSize=1000
+	maxMultiSigKeys=20
+)
+
+var(
+	//ErrBadScript is returned for a script that can't be parsed or run
+	ErrBadScript=errors.New("script is invalid")
+	//ErrScriptFailed is returned when a script runs but doesn't unlock the output
+	ErrScriptFailed=errors.New("script evaluated to false")
+	//ErrCannotSign is returned when a key can't sign the input of an output
+	ErrCannotSign=errors.New("key can't sign for the output")
+)
+
+//ScriptClass is the standard template a locking script follows
+type ScriptClass int
+
+const(
+	NonStandardScript ScriptClass=iota
+	//PubKeyHashScript is pay-to-pubkey-hash: OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+	PubKeyHashScript
+	//ScriptHashScript is pay-to-script-hash: OP_HASH160 <hash> OP_EQUAL
+	ScriptHashScript
+	//MultiSigScript is m-of-n multisig: OP_m <pubkey>... OP_n OP_CHECKMULTISIG
+	MultiSigScript
+)
+
+func (c ScriptClass) String() string{
+	switch c{
+	case PubKeyHashScript:
+		return "pubkeyhash"
+	case ScriptHashScript:
+		return "scripthash"
+	case MultiSigScript:
+		return "multisig"
+	}
+	return "nonstandard"
+}
+
+//scriptOp is an opcode with the data it pushes
+type scriptOp struct{
+	code byte
+	data []byte
+}
+
+//isPush reports whether the opcode only pushes data or a number
+func (op scriptOp) isPush() bool{
+	return op.code<=OpPushData2||(op.code>=Op1&&op.code<=Op16)
+}
+
+//parseScript splits a script into its opcodes
+func parseScript(script []byte) ([]scriptOp,error){
+	if len(script)>maxScriptSize{
+		return nil,fmt.Errorf("%w: script is longer than %d bytes",ErrBadScript,maxScriptSize)
+	}
+
+	var ops []scriptOp
+	for i:=0;i<len(script);{
+		op:=scriptOp{code:script[i]}
+		i++
+
+		length:=0
+		switch{
+		case op.code<OpPushData1:
+			length=int(op.code)
+		case op.code==OpPushData1:
+			if i+1>len(script){
+				return nil,fmt.Errorf("%w: push is truncated",ErrBadScript)
+			}
+			length=int(script[i])
+			i++
+		case op.code==OpPushData2:
+			if i+2>len(script){
+				return nil,fmt.Errorf("%w: push is truncated",ErrBadScript)
+			}
+			length=int(binary.LittleEndian.Uint16(script[i:]))
+			i+=2
+		}
+		if i+length>len(script){
+			return nil,fmt.Errorf("%w: push is truncated",ErrBadScript)
+		}
+		if length>0{
+			op.data=script[i:i+length]
+		}
+		i+=length
+
+		ops=append(ops,op)
+	}
+	return ops,nil
+}
+
+//scriptWriter appends opcodes to a script
+type scriptWriter struct{
+	script []byte
+}
+
+func (w *scriptWriter) op(code byte){
+	w.script=append(w.script,code)
+}
+
+//push appends data with the shortest push opcode
+func (w *scriptWriter) push(data []byte){
+	switch{
+	case len(data)<OpPushData1:
+		w.script=append(w.script,byte(len(data)))
+	case len(data)<=0xff:
+		w.script=append(w.script,OpPushData1,byte(len(data)))
+	default:
+		w.script=append(w.script,OpPushData2)
+		w.script=binary.LittleEndian.AppendUint16(w.script,uint16(len(data)))
+	}
+	w.script=append(w.script,data...)
+}
+
+//smallInt appends the opcode pushing n, which is 0 to 16
+func (w *scriptWriter) smallInt(n int){
+	if n==0{
+		w.op(Op0)
+		return
+	}
+	w.op(byte(Op1+n-1))
+}
+
+//pushScript returns a script pushing each item, as used for unlocking scripts
+func pushScript(items [][]byte) []byte{
+	var w scriptWriter
+	for _,item:=range items{
+		w.push(item)
+	}
+	return w.script
+}
+
+//NewP2PKHScript returns the script locking an output to the owner of the public key hash
+func NewP2PKHScript(pubKeyHash []byte) []byte{
+	var w scriptWriter
+	w.op(OpDup)
+	w.op(OpHash160)
+	w.push(pubKeyHash)
+	w.op(OpEqualVerify)
+	w.op(OpCheckSig)
+
+	return w.script
+}
+
+//NewP2SHScript returns the script locking an output to the redeem script with the hash
+func NewP2SHScript(scriptHash []byte) []byte{
+	var w scriptWriter
+	w.op(OpHash160)
+	w.push(scriptHash)
+	w.op(OpEqual)
+
+	return w.script
+}
+
+//NewMultiSigScript returns the script locking an output to required signatures of the public keys
+func NewMultiSigScript(required int,pubKeys [][]byte) ([]byte,error){
+	if len(pubKeys)==0||len(pubKeys)>16{
+		return nil,errors.New("multisig needs 1 to 16 public keys")
+	}
+	if required<1||required>len(pubKeys){
+		return nil,errors.New("required signatures must be between 1 and the number of keys")
+	}
+
+	var w scriptWriter
+	w.smallInt(required)
+	for _,pubKey:=range pubKeys{
+		_,err:=ParsePublicKey(pubKey)
+		if err!=nil{
+			return nil,err
+		}
+		w.push(pubKey)
+	}
+	w.smallInt(len(pubKeys))
+	w.op(OpCheckMultiSig)
+
+	return w.script,nil
+}
+
+//extractPubKeyHash returns the hash of a pay-to-pubkey-hash script, or nil for other scripts
+func extractPubKeyHash(script []byte) []byte{
+	ops,err:=parseScript(script)
+	if err!=nil||len(ops)!=5{
+		return nil
+	}
+	if ops[0].code!=OpDup||ops[1].code!=OpHash160||ops[2].code!=20||ops[3].code!=OpEqualVerify||ops[4].code!=OpCheckSig{
+		return nil
+	}
+	return ops[2].data
+}
+
+//extractScriptHash returns the hash of a pay-to-script-hash script, or nil for other scripts
+func extractScriptHash(script []byte) []byte{
+	ops,err:=parseScript(script)
+	if err!=nil||len(ops)!=3{
+		return nil
+	}
+	if ops[0].code!=OpHash160||ops[1].code!=20||ops[2].code!=OpEqual{
+		return nil
+	}
+	return ops[1].data
+}
+
+//extractMultiSig returns the required signatures and the public keys of a multisig script
+func extractMultiSig(script []byte) (int,[][]byte,bool){
+	ops,err:=parseScript(script)
+	if err!=nil||len(ops)<4||ops[len(ops)-1].code!=OpCheckMultiSig{
+		return 0,nil,false
+	}
+
+	smallInt:=func(op scriptOp) int{
+		if op.code<Op1||op.code>Op16{
+			return 0
+		}
+		return int(op.code-Op1+1)
+	}
+	required:=smallInt(ops[0])
+	keys:=ops[1:len(ops)-2]
+	if required==0||smallInt(ops[len(ops)-2])!=len(keys)||required>len(keys){
+		return 0,nil,false
+	}
+
+	var pubKeys [][]byte
+	for _,op:=range keys{
+		if op.code==Op0||op.code>OpPushData2{
+			return 0,nil,false
+		}
+		pubKeys=append(pubKeys,op.data)
+	}
+	return required,pubKeys,true
+}
+
+//ClassifyScript returns the standard template of a locking script
+func ClassifyScript(script []byte) ScriptClass{
+	switch{
+	case extractPubKeyHash(script)!=nil:
+		return PubKeyHashScript
+	case extractScriptHash(script)!=nil:
+		return ScriptHashScript
+	}
+	if _,_,ok:=extractMultiSig(script);ok{
+		return MultiSigScript
+	}
+	return NonStandardScript
+}
+
+//scriptNum encodes a number as a stack item, little endian with the sign in the top bit
+func scriptNum(n int) []byte{
+	if n==0{
+		return nil
+	}
+
+	negative:=n<0
+	if negative{
+		n=-n
+	}
+	var result []byte
+	for n>0{
+		result=append(result,byte(n&0xff))
+		n>>=8
+	}
+	if result[len(result)-1]&0x80!=0{
+		result=append(result,0)
+	}
+	if negative{
+		result[len(result)-1]|=0x80
+	}
+	return result
+}
+
+//asBool reports whether a stack item is true. Empty items and zeros, including negative zero, are false.
+func asBool(item []byte) bool{
+	for i,b:=range item{
+		if b!=0{
+			return i!=len(item)-1||b!=0x80
+		}
+	}
+	return false
+}
+
+//scriptEngine runs the scripts of input inID of tx
+type scriptEngine struct{
+	tx *Transaction
+	inID int
+	stack [][]byte
+}
+
+func (e *scriptEngine) push(item []byte) error{
+	if len(e.stack)>=maxStackSize{
+		return fmt.Errorf("%w: stack has more than %d items",ErrBadScript,maxStackSize)
+	}
+	e.stack=append(e.stack,item)
+	return nil
+}
+
+func (e *scriptEngine) pop() ([]byte,error){
+	if len(e.stack)==0{
+		return nil,fmt.Errorf("%w: stack is empty",ErrBadScript)
+	}
+	item:=e.stack[len(e.stack)-1]
+	e.stack=e.stack[:len(e.stack)-1]
+	return item,nil
+}
+
+//popN removes n items and returns them in the order they were pushed
+func (e *scriptEngine) popN(n int) ([][]byte,error){
+	if n>len(e.stack){
+		return nil,fmt.Errorf("%w: stack has less than %d items",ErrBadScript,n)
+	}
+	items:=append([][]byte{},e.stack[len(e.stack)-n:]...)
+	e.stack=e.stack[:len(e.stack)-n]
+	return items,nil
+}
+
+//scriptInt decodes a number of up to maxLen bytes
+func scriptInt(item []byte,maxLen int) (int64,error){
+	if len(item)>maxLen{
+		return 0,fmt.Errorf("%w: number is longer than %d bytes",ErrBadScript,maxLen)
+	}
+
+	var n int64
+	for i:=len(item)-1;i>=0;i--{
+		n=n<<8|int64(item[i])
+	}
+	if len(item)>0&&item[len(item)-1]&0x80!=0{
+		n=-(n&^(0x80<<(8*(len(item)-1))))
+	}
+	return n,nil
+}
+
+//popInt removes a number of up to 4 bytes
+func (e *scriptEngine) popInt() (int,error){
+	item,err:=e.pop()
+	if err!=nil{
+		return 0,err
+	}
+	n,err:=scriptInt(item,4)
+	return int(n),err
+}
+
+//peekInt returns the number of up to 5 bytes on top of the stack, which is large enough for locktimes
+func (e *scriptEngine) peekInt() (int64,error){
+	if len(e.stack)==0{
+		return 0,fmt.Errorf("%w: stack is empty",ErrBadScript)
+	}
+	return scriptInt(e.stack[len(e.stack)-1],5)
+}
+
+//result pushes the outcome of a check, or fails the script if the opcode verifies it
+func (e *scriptEngine) result(ok,verify bool) error{
+	if verify{
+		if !ok{
+			return ErrScriptFailed
+		}
+		return nil
+	}
+	if ok{
+		return e.push([]byte{1})
+	}
+	return e.push(nil)
+}
+
+//execute runs a script. Signatures are checked against the signature hash with the script as the subscript.
+func (e *scriptEngine) execute(script []byte) error{
+	ops,err:=parseScript(script)
+	if err!=nil{
+		return err
+	}
+
+	for _,op:=range ops{
+		if len(op.data)>maxScriptElementSize{
+			return fmt.Errorf("%w: pushed data is longer than %d bytes",ErrBadScript,maxScriptElementSize)
+		}
+
+		switch{
+		case op.code<=OpPushData2:
+			err=e.push(op.data)
+		case op.code>=Op1&&op.code<=Op16:
+			err=e.push(scriptNum(int(op.code-Op1+1)))
+		default:
+			err=e.executeOp(op.code,script)
+		}
+		if err!=nil{
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *scriptEngine) executeOp(code byte,script []byte) error{
+	switch code{
+	case OpVerify:
+		item,err:=e.pop()
+		if err!=nil{
+			return err
+		}
+		return e.result(asBool(item),true)
+	case OpReturn:
+		return fmt.Errorf("%w: OP_RETURN",ErrScriptFailed)
+	case OpDrop:
+		_,err:=e.pop()
+		return err
+	case OpDup:
+		if len(e.stack)==0{
+			return fmt.Errorf("%w: stack is empty",ErrBadScript)
+		}
+		return e.push(e.stack[len(e.stack)-1])
+	case OpEqual,OpEqualVerify:
+		items,err:=e.popN(2)
+		if err!=nil{
+			return err
+		}
+		return e.result(bytes.Equal(items[0],items[1]),code==OpEqualVerify)
+	case OpHash160:
+		item,err:=e.pop()
+		if err!=nil{
+			return err
+		}
+		return e.push(HashPubKey(item))
+	case OpCheckSig,OpCheckSigVerify:
+		items,err:=e.popN(2)
+		if err!=nil{
+			return err
+		}
+		return e.result(e.checkSig(items[0],items[1],script),code==OpCheckSigVerify)
+	case OpCheckMultiSig,OpCheckMultiSigVerify:
+		ok,err:=e.checkMultiSig(script)
+		if err!=nil{
+			return err
+		}
+		return e.result(ok,code==OpCheckMultiSigVerify)
+	case OpCheckLockTimeVerify,OpCheckSequenceVerify:
+		//The number stays on the stack, it is usually dropped next
+		n,err:=e.peekInt()
+		if err!=nil{
+			return err
+		}
+		if code==OpCheckLockTimeVerify{
+			return e.result(e.checkLockTime(n),true)
+		}
+		return e.result(e.checkSequence(n),true)
+	}
+	return fmt.Errorf("%w: unknown opcode 0x%02x",ErrBadScript,code)
+}
+
+//checkSig verifies a signature, followed by its hash type, of the input
+func (e *scriptEngine) checkSig(signature,pubKey,script []byte) bool{
+	if len(signature)==0{
+		return false
+	}
+	hash,err:=e.tx.SignatureHash(e.inID,script,SigHashType(signature[len(signature)-1]))
+	if err!=nil{
+		return false
+	}
+	key,err:=ParsePublicKey(pubKey)
+	if err!=nil{
+		return false
+	}
+
+	return ecdsa.VerifyASN1(key,hash,signature[:len(signature)-1])
+}
+
+//checkMultiSig pops <signatures> m <public keys> n and checks that the signatures
+//belong to m of the keys, in the same order as the keys
+func (e *scriptEngine) checkMultiSig(script []byte) (bool,error){
+	n,err:=e.popInt()
+	if err!=nil{
+		return false,err
+	}
+	if n<0||n>maxMultiSigKeys{
+		return false,fmt.Errorf("%w: multisig has %d keys",ErrBadScript,n)
+	}
+	pubKeys,err:=e.popN(n)
+	if err!=nil{
+		return false,err
+	}
+
+	m,err:=e.popInt()
+	if err!=nil{
+		return false,err
+	}
+	if m<0||m>n{
+		return false,fmt.Errorf("%w: multisig requires %d of %d signatures",ErrBadScript,m,n)
+	}
+	signatures,err:=e.popN(m)
+	if err!=nil{
+		return false,err
+	}
+
+	k:=0
+	for _,signature:=range signatures{
+		for k<len(pubKeys)&&!e.checkSig(signature,pubKeys[k],script){
+			k++
+		}
+		if k==len(pubKeys){
+			return false,nil
+		}
+		k++
+	}
+	return true,nil
+}
+
+//checkLockTime reports whether the transaction locktime is at least lockTime, of the same kind, and enabled by the input
+func (e *scriptEngine) checkLockTime(lockTime int64) bool{
+	txLockTime:=int64(e.tx.LockTime)
+	if lockTime<0||(lockTime<lockTimeThreshold)!=(txLockTime<lockTimeThreshold){
+		return false
+	}
+
+	return lockTime<=txLockTime&&e.tx.Vin[e.inID].Sequence!=maxSequence
+}
+
+//checkSequence reports whether the input's relative lock is at least sequence, of the same kind.
+//A sequence with sequenceDisableFlag always passes.
+func (e *scriptEngine) checkSequence(sequence int64) bool{
+	if sequence<0{
+		return false
+	}
+	if sequence&sequenceDisableFlag!=0{
+		return true
+	}
+
+	inputSequence:=int64(e.tx.Vin[e.inID].Sequence)
+	if inputSequence&sequenceDisableFlag!=0||sequence&sequenceTypeFlag!=inputSequence&sequenceTypeFlag{
+		return false
+	}
+	return sequence&sequenceMask<=inputSequence&sequenceMask
+}
+
+//checkResult fails unless the top of the stack is true
+func (e *scriptEngine) checkResult() error{
+	if len(e.stack)==0||!asBool(e.stack[len(e.stack)-1]){
+		return ErrScriptFailed
+	}
+	return nil
+}
+
+//VerifyInput runs the unlocking script of input inID and the locking script of prevOut, the output it spends
+func (tx *Transaction) VerifyInput(inID int,prevOut TXOutput) error{
+	if inID<0||inID>=len(tx.Vin){
+		return errors.New("input index is out of range")
+	}
+
+	scriptSig:=tx.Vin[inID].ScriptSig
+	ops,err:=parseScript(scriptSig)
+	if err!=nil{
+		return err
+	}
+	for _,op:=range ops{
+		if !op.isPush(){
+			return fmt.Errorf("%w: unlocking script may only push data",ErrBadScript)
+		}
+	}
+
+	e:=scriptEngine{tx:tx,inID:inID}
+	err=e.execute(scriptSig)
+	if err!=nil{
+		return err
+	}
+	unlockingStack:=append([][]byte{},e.stack...)
+
+	err=e.execute(prevOut.ScriptPubKey)
+	if err!=nil{
+		return err
+	}
+	err=e.checkResult()
+	if err!=nil||extractScriptHash(prevOut.ScriptPubKey)==nil{
+		return err
+	}
+
+	e.stack=unlockingStack
+	redeemScript,err:=e.pop()
+	if err!=nil{
+		return err
+	}
+	err=e.execute(redeemScript)
+	if err!=nil{
+		return err
+	}
+	return e.checkResult()
+}
+
+//inputSignature signs input inID with the script as the subscript and appends the hash type
+func (tx *Transaction) inputSignature(inID int,privKey ecdsa.PrivateKey,script []byte,hashType SigHashType) ([]byte,error){
+	hash,err:=tx.SignatureHash(inID,script,hashType)
+	if err!=nil{
+		return nil,err
+	}
+
+	//The signature is DER encoded and followed by the hash type
+	signature,err:=ecdsa.SignASN1(rand.Reader,&privKey,hash)
+	if err!=nil{
+		return nil,err
+	}
+	return append(signature,byte(hashType)),nil
+}
+
+//SignInput adds the wallet's signature to input inID, which spends prevOut, and builds its unlocking script.
+//redeemScript is the script a pay-to-script-hash output commits to, and nil for other outputs.
+//Signatures already in a multisig input are kept, so the owners can sign one after another.
+func (tx *Transaction) SignInput(inID int,wallet *Wallet,prevOut TXOutput,redeemScript []byte,hashType SigHashType) error{
+	if inID<0||inID>=len(tx.Vin){
+		return errors.New("input index is out of range")
+	}
+	if wallet.PrivateKey.D==nil{
+		return ErrWalletLocked
+	}
+
+	script:=prevOut.ScriptPubKey
+	scriptHash:=extractScriptHash(script)
+	if scriptHash!=nil{
+		if !bytes.Equal(HashPubKey(redeemScript),scriptHash){
+			return fmt.Errorf("%w: redeem script doesn't match the output",ErrCannotSign)
+		}
+		script=redeemScript
+	}
+
+	signature,err:=tx.inputSignature(inID,wallet.PrivateKey,script,hashType)
+	if err!=nil{
+		return err
+	}
+
+	var items [][]byte
+	switch ClassifyScript(script){
+	case PubKeyHashScript:
+		if !bytes.Equal(extractPubKeyHash(script),HashPubKey(wallet.PublicKey)){
+			return ErrCannotSign
+		}
+		items=[][]byte{signature,wallet.PublicKey}
+	case MultiSigScript:
+		items,err=tx.addMultiSigSignature(inID,script,scriptHash!=nil,wallet.PublicKey,signature)
+		if err!=nil{
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: %s script",ErrCannotSign,ClassifyScript(script))
+	}
+
+	if scriptHash!=nil{
+		items=append(items,redeemScript)
+	}
+	tx.Vin[inID].ScriptSig=pushScript(items)
+	return nil
+}
+
+//addMultiSigSignature merges a signature with those already in the input's unlocking script.
+//The signatures are ordered like their keys, and only as many as required are kept.
+func (tx *Transaction) addMultiSigSignature(inID int,script []byte,p2sh bool,pubKey,signature []byte) ([][]byte,error){
+	required,pubKeys,_:=extractMultiSig(script)
+
+	signatures:=[][]byte{signature}
+	ops,err:=parseScript(tx.Vin[inID].ScriptSig)
+	if err==nil{
+		if p2sh&&len(ops)>0{
+			ops=ops[:len(ops)-1]
+		}
+		for _,op:=range ops{
+			signatures=append(signatures,op.data)
+		}
+	}
+
+	e:=scriptEngine{tx:tx,inID:inID}
+	var ordered [][]byte
+	found:=false
+	for _,key:=range pubKeys{
+		found=found||bytes.Equal(key,pubKey)
+		for _,sig:=range signatures{
+			if len(ordered)<required&&e.checkSig(sig,key,script){
+				ordered=append(ordered,sig)
+				break
+			}
+		}
+	}
+	if !found{
+		return nil,ErrCannotSign
+	}
+	return ordered,nil
+}
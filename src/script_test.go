@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fundScript creates a transaction paying 10 to the locking script
+func fundScript(script []byte) Transaction {
+	prev := Transaction{nil, []TXInput{{[]byte{}, -1, []byte("funding"), 0}}, []TXOutput{{10, script}}, 0}
+	prev.ID = prev.Hash()
+	return prev
+}
+
+// spendOutput creates an unsigned transaction spending the first output of prev
+func spendOutput(prev Transaction) *Transaction {
+	tx := Transaction{nil, []TXInput{{prev.ID, 0, nil, 0}}, []TXOutput{*NewTXOutput(9, string(NewWallet().GetAddress()))}, 0}
+	tx.ID = tx.Hash()
+	return &tx
+}
+
+func TestScriptTemplates(t *testing.T) {
+	w := NewWallet()
+	hash := HashPubKey(w.PublicKey)
+
+	script := w.LockingScript()
+	assert.Equal(t, "76a914"+hex.EncodeToString(hash)+"88ac", hex.EncodeToString(script))
+	assert.Equal(t, PubKeyHashScript, ClassifyScript(script))
+	address, ok := ScriptToAddress(script)
+	assert.True(t, ok)
+	assert.Equal(t, string(w.GetAddress()), address)
+	assert.Equal(t, script, AddressToScript(address))
+
+	script = NewP2SHScript(hash)
+	assert.Equal(t, "a914"+hex.EncodeToString(hash)+"87", hex.EncodeToString(script))
+	assert.Equal(t, ScriptHashScript, ClassifyScript(script))
+	address, _ = ScriptToAddress(script)
+	assert.Equal(t, byte('3'), address[0], "Script hash addresses start with 3")
+	assert.Equal(t, script, AddressToScript(address))
+
+	script, err := NewMultiSigScript(1, [][]byte{w.PublicKey})
+	assert.Nil(t, err)
+	assert.Equal(t, "5121"+hex.EncodeToString(w.PublicKey)+"51ae", hex.EncodeToString(script))
+	assert.Equal(t, MultiSigScript, ClassifyScript(script))
+	_, ok = ScriptToAddress(script)
+	assert.False(t, ok, "Bare multisig has no address")
+
+	_, err = NewMultiSigScript(2, [][]byte{w.PublicKey})
+	assert.NotNil(t, err, "Can't require more signatures than keys")
+	assert.Equal(t, NonStandardScript, ClassifyScript([]byte{OpReturn}))
+	assert.Nil(t, AddressToScript(address[:len(address)-1]+"1"), "Checksum is verified")
+}
+
+func TestScriptInterpreter(t *testing.T) {
+	tx := spendOutput(fundScript(nil))
+
+	for _, test := range []struct {
+		scriptSig, scriptPubKey []byte
+		err                     error
+		name                    string
+	}{
+		{[]byte{0x01, 0x07}, []byte{0x01, 0x07, OpEqual}, nil, "Equal items"},
+		{[]byte{0x01, 0x07}, []byte{0x01, 0x08, OpEqual}, ErrScriptFailed, "Different items"},
+		{[]byte{Op1}, []byte{OpDup, OpDrop}, nil, "True is left on the stack"},
+		{[]byte{Op0}, nil, ErrScriptFailed, "False is left on the stack"},
+		{nil, nil, ErrScriptFailed, "Stack is empty"},
+		{[]byte{Op1}, []byte{OpVerify}, ErrScriptFailed, "Nothing is left after OP_VERIFY"},
+		{[]byte{Op1, OpDup}, []byte{OpEqual}, ErrBadScript, "Unlocking script may only push data"},
+		{[]byte{Op1}, []byte{OpReturn}, ErrScriptFailed, "OP_RETURN outputs can't be spent"},
+		{[]byte{Op1}, []byte{0xff}, ErrBadScript, "Unknown opcode"},
+		{[]byte{0x02, 0x01}, []byte{Op1}, ErrBadScript, "Push is truncated"},
+		{nil, []byte{OpDrop, Op1}, ErrBadScript, "Stack underflow"},
+	} {
+		tx.Vin[0].ScriptSig = test.scriptSig
+		err := tx.VerifyInput(0, TXOutput{10, test.scriptPubKey})
+		if test.err == nil {
+			assert.Nil(t, err, test.name)
+		} else {
+			assert.True(t, errors.Is(err, test.err), "%s: %v", test.name, err)
+		}
+	}
+}
+
+func TestMultiSig(t *testing.T) {
+	alice, bob, carol := NewWallet(), NewWallet(), NewWallet()
+	script, err := NewMultiSigScript(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+	assert.Nil(t, err)
+	prev := fundScript(script)
+
+	tx := spendOutput(prev)
+	assert.True(t, errors.Is(tx.SignInput(0, NewWallet(), prev.Vout[0], nil, SigHashAll), ErrCannotSign), "Only the owners can sign")
+
+	assert.Nil(t, tx.SignInput(0, carol, prev.Vout[0], nil, SigHashAll))
+	assert.NotNil(t, tx.VerifyInput(0, prev.Vout[0]), "One signature isn't enough")
+	assert.Nil(t, tx.SignInput(0, alice, prev.Vout[0], nil, SigHashAll))
+	assert.Nil(t, tx.VerifyInput(0, prev.Vout[0]), "Signatures are ordered like the keys")
+
+	tx.Vout[0].Value = 1
+	assert.True(t, errors.Is(tx.VerifyInput(0, prev.Vout[0]), ErrScriptFailed), "Signatures cover the outputs")
+}
+
+func TestPayToScriptHash(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	wallets := Wallets{Wallets: map[string]*Wallet{string(alice.GetAddress()): alice}}
+	address, redeemScript, err := wallets.MultiSigAddress(2, []string{string(alice.GetAddress()), hex.EncodeToString(bob.PublicKey)})
+	assert.Nil(t, err)
+	assert.True(t, ValidateAddress(address))
+	prev := fundScript(AddressToScript(address))
+
+	tx := spendOutput(prev)
+	assert.True(t, errors.Is(tx.SignInput(0, alice, prev.Vout[0], nil, SigHashAll), ErrCannotSign), "Redeem script is needed")
+	assert.Nil(t, tx.SignInput(0, alice, prev.Vout[0], redeemScript, SigHashAll))
+	assert.Nil(t, tx.SignInput(0, bob, prev.Vout[0], redeemScript, SigHashAll))
+	assert.Nil(t, tx.VerifyInput(0, prev.Vout[0]))
+
+	other, _ := NewMultiSigScript(1, [][]byte{bob.PublicKey})
+	scriptSig, _ := parseScript(tx.Vin[0].ScriptSig)
+	tx.Vin[0].ScriptSig = pushScript([][]byte{scriptSig[1].data, other})
+	assert.True(t, errors.Is(tx.VerifyInput(0, prev.Vout[0]), ErrScriptFailed), "Redeem script must match the hash")
+}
+
+func TestPayToScriptHashIsMined(t *testing.T) {
+	bc, alice := newTestBlockchain(t)
+	bob := NewWallet()
+	genesis := bc.Iterator().Next().Transactions[0]
+	wallets := Wallets{Wallets: map[string]*Wallet{string(alice.GetAddress()): alice, string(bob.GetAddress()): bob}}
+	address, redeemScript, _ := wallets.MultiSigAddress(2, []string{string(alice.GetAddress()), string(bob.GetAddress())})
+
+	escrow := spend(alice, genesis, 0, address, 8, 2)
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(alice.GetAddress()), "", subsidy+2), escrow})
+	assert.Nil(t, err)
+	UTXOSet := UTXOSet{bc}
+	balance, _ := UTXOSet.FindBalance(AddressToScript(address))
+	assert.Equal(t, 8, balance)
+
+	release := Transaction{nil, []TXInput{{escrow.ID, 0, nil, 0}}, []TXOutput{*NewTXOutput(8, string(bob.GetAddress()))}, 0}
+	release.ID = release.Hash()
+	assert.Nil(t, release.SignInput(0, bob, escrow.Vout[0], redeemScript, SigHashAll))
+	assert.Nil(t, release.SignInput(0, alice, escrow.Vout[0], redeemScript, SigHashAll))
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(alice.GetAddress()), "", subsidy), &release})
+	assert.Nil(t, err)
+
+	balance, _ = UTXOSet.FindBalance(AddressToScript(address))
+	assert.Equal(t, 0, balance)
+	assert.Len(t, bc.FindAddressTransactions(AddressToScript(address)), 2, "Funding and spending transactions are indexed")
+}
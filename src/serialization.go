@@ -0,0 +1,315 @@
+package main
+
+import(
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+//Transactions, blocks and UTXO entries are hashed, signed, stored and sent to peers
+//in a canonical binary encoding, so tools written in other languages get the same bytes.
+//Fields are written in a fixed order:
+//  - unsigned integers and lengths are unsigned LEB128 varints
+//  - signed integers are zigzag encoded and written as unsigned varints
+//  - booleans are a single byte, 0 or 1
+//  - byte strings are their length followed by the bytes
+//  - lists are their length followed by the items
+//Varints must use the fewest bytes, so every value has exactly one encoding.
+
+//ErrBadEncoding is returned when data isn't a valid canonical encoding
+var ErrBadEncoding=errors.New("data is not canonically encoded")
+
+//canonicalWriter appends values in the canonical encoding
+type canonicalWriter struct{
+	data []byte
+}
+
+func (w *canonicalWriter) uvarint(v uint64){
+	w.data=binary.AppendUvarint(w.data,v)
+}
+
+func (w *canonicalWriter) varint(v int64){
+	w.data=binary.AppendVarint(w.data,v)
+}
+
+func (w *canonicalWriter) bool(v bool){
+	if v{
+		w.data=append(w.data,1)
+	}else{
+		w.data=append(w.data,0)
+	}
+}
+
+func (w *canonicalWriter) bytes(b []byte){
+	w.uvarint(uint64(len(b)))
+	w.data=append(w.data,b...)
+}
+
+//canonicalReader reads values in the canonical encoding.
+//The first error is kept and later reads return zero values.
+type canonicalReader struct{
+	data []byte
+	err error
+}
+
+func (r *canonicalReader) fail(){
+	if r.err==nil{
+		r.err=ErrBadEncoding
+	}
+	r.data=nil
+}
+
+func (r *canonicalReader) uvarint() uint64{
+	if r.err!=nil{
+		return 0
+	}
+
+	v,n:=binary.Uvarint(r.data)
+	if n<=0||n!=len(binary.AppendUvarint(nil,v)){
+		r.fail()
+		return 0
+	}
+	r.data=r.data[n:]
+	return v
+}
+
+func (r *canonicalReader) varint() int64{
+	if r.err!=nil{
+		return 0
+	}
+
+	v,n:=binary.Varint(r.data)
+	if n<=0||n!=len(binary.AppendVarint(nil,v)){
+		r.fail()
+		return 0
+	}
+	r.data=r.data[n:]
+	return v
+}
+
+//uint32 reads an unsigned varint that fits in 32 bits
+func (r *canonicalReader) uint32() uint32{
+	v:=r.uvarint()
+	if v>0xffffffff{
+		r.fail()
+		return 0
+	}
+	return uint32(v)
+}
+
+//int reads a signed varint that fits in an int
+func (r *canonicalReader) int() int{
+	v:=r.varint()
+	if int64(int(v))!=v{
+		r.fail()
+		return 0
+	}
+	return int(v)
+}
+
+func (r *canonicalReader) bool() bool{
+	if r.err!=nil{
+		return false
+	}
+
+	if len(r.data)==0||r.data[0]>1{
+		r.fail()
+		return false
+	}
+	v:=r.data[0]==1
+	r.data=r.data[1:]
+	return v
+}
+
+//bytes reads a byte string, an empty one is returned as nil
+func (r *canonicalReader) bytes() []byte{
+	length:=r.uvarint()
+	if r.err!=nil{
+		return nil
+	}
+
+	if length>uint64(len(r.data)){
+		r.fail()
+		return nil
+	}
+	if length==0{
+		return nil
+	}
+
+	b:=append([]byte{},r.data[:length]...)
+	r.data=r.data[length:]
+	return b
+}
+
+//count reads a list length. Every item takes at least one byte, so longer lists can't fit in the data.
+func (r *canonicalReader) count() int{
+	length:=r.uvarint()
+	if r.err!=nil{
+		return 0
+	}
+
+	if length>uint64(len(r.data)){
+		r.fail()
+		return 0
+	}
+	return int(length)
+}
+
+//finish returns the first error, or an error if data is left over
+func (r *canonicalReader) finish() error{
+	if r.err==nil&&len(r.data)>0{
+		r.fail()
+	}
+	return r.err
+}
+
+func (in *TXInput) encode(w *canonicalWriter){
+	w.bytes(in.Txid)
+	w.varint(int64(in.Vout))
+	w.bytes(in.ScriptSig)
+	w.uvarint(uint64(in.Sequence))
+}
+
+func (in *TXInput) decode(r *canonicalReader){
+	in.Txid=r.bytes()
+	in.Vout=r.int()
+	in.ScriptSig=r.bytes()
+	in.Sequence=r.uint32()
+}
+
+func (out *TXOutput) encode(w *canonicalWriter){
+	w.varint(int64(out.Value))
+	w.bytes(out.ScriptPubKey)
+}
+
+func (out *TXOutput) decode(r *canonicalReader){
+	out.Value=r.int()
+	out.ScriptPubKey=r.bytes()
+}
+
+//encode writes the inputs, the outputs and the locktime. The ID isn't written, it is the hash of the rest.
+func (tx *Transaction) encode(w *canonicalWriter){
+	w.uvarint(uint64(len(tx.Vin)))
+	for i:=range tx.Vin{
+		tx.Vin[i].encode(w)
+	}
+
+	w.uvarint(uint64(len(tx.Vout)))
+	for i:=range tx.Vout{
+		tx.Vout[i].encode(w)
+	}
+	w.uvarint(uint64(tx.LockTime))
+}
+
+func (tx *Transaction) decode(r *canonicalReader){
+	tx.Vin=make([]TXInput,r.count())
+	for i:=range tx.Vin{
+		tx.Vin[i].decode(r)
+	}
+
+	tx.Vout=make([]TXOutput,r.count())
+	for i:=range tx.Vout{
+		tx.Vout[i].decode(r)
+	}
+	tx.LockTime=r.uint32()
+}
+
+//encode writes the header fields and then each transaction as a byte string
+func (b *Block) encode(w *canonicalWriter){
+	w.bytes(b.PrevBlockHash)
+	w.bytes(b.Hash)
+	w.varint(int64(b.Height))
+	w.varint(b.Timestamp)
+	w.uvarint(uint64(b.Bits))
+	w.varint(int64(b.Nonce))
+
+	w.uvarint(uint64(len(b.Transactions)))
+	for _,tx:=range b.Transactions{
+		w.bytes(tx.Serialize())
+	}
+}
+
+func (b *Block) decode(r *canonicalReader){
+	b.PrevBlockHash=r.bytes()
+	b.Hash=r.bytes()
+	b.Height=r.int()
+	b.Timestamp=r.varint()
+	b.Bits=r.uint32()
+	b.Nonce=r.int()
+
+	b.Transactions=make([]*Transaction,r.count())
+	for i:=range b.Transactions{
+		tx,err:=ParseTransaction(r.bytes())
+		if err!=nil{
+			r.fail()
+			return
+		}
+		b.Transactions[i]=&tx
+	}
+}
+
+//encode writes the height and coinbase flag, then the outputs ordered by index
+func (outs *TXOutputs) encode(w *canonicalWriter){
+	w.varint(int64(outs.Height))
+	w.bool(outs.Coinbase)
+
+	var indexes []int
+	for outIdx:=range outs.Outputs{
+		indexes=append(indexes,outIdx)
+	}
+	sort.Ints(indexes)
+
+	w.uvarint(uint64(len(indexes)))
+	for _,outIdx:=range indexes{
+		out:=outs.Outputs[outIdx]
+		w.uvarint(uint64(outIdx))
+		out.encode(w)
+	}
+}
+
+func (outs *TXOutputs) decode(r *canonicalReader){
+	outs.Height=r.int()
+	outs.Coinbase=r.bool()
+
+	count:=r.count()
+	outs.Outputs=make(map[int]TXOutput,count)
+	previous:=-1
+	for i:=0;i<count;i++{
+		outIdx:=r.uvarint()
+		if r.err==nil&&(outIdx>=1<<31||int(outIdx)<=previous){
+			r.fail()
+		}
+
+		var out TXOutput
+		out.decode(r)
+		if r.err!=nil{
+			return
+		}
+		outs.Outputs[int(outIdx)]=out
+		previous=int(outIdx)
+	}
+}
+
+func (u *BlockUndo) encode(w *canonicalWriter){
+	w.uvarint(uint64(len(u.Spent)))
+	for i:=range u.Spent{
+		spent:=&u.Spent[i]
+		w.bytes(spent.Txid)
+		w.varint(int64(spent.Vout))
+		spent.Output.encode(w)
+		w.varint(int64(spent.Height))
+		w.bool(spent.Coinbase)
+	}
+}
+
+func (u *BlockUndo) decode(r *canonicalReader){
+	u.Spent=make([]SpentOutput,r.count())
+	for i:=range u.Spent{
+		spent:=&u.Spent[i]
+		spent.Txid=r.bytes()
+		spent.Vout=r.int()
+		spent.Output.decode(r)
+		spent.Height=r.int()
+		spent.Coinbase=r.bool()
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTransaction() Transaction {
+	tx := Transaction{nil,
+		[]TXInput{{[]byte{0xaa, 0xbb}, 1, []byte{0x01, 0x02}, 5}},
+		[]TXOutput{{300, []byte{0xcc}}, {-1, nil}}, 100}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func TestTransactionEncoding(t *testing.T) {
+	tx := testTransaction()
+
+	expected := "01" + // one input
+		"02aabb" + "02" + "020102" + "05" + // txid, vout 1, unlocking script, sequence 5
+		"02" + // two outputs
+		"d804" + "01cc" + // value 300, locking script
+		"01" + "00" + // value -1, no locking script
+		"64" // locktime 100
+	assert.Equal(t, expected, hex.EncodeToString(tx.Serialize()))
+
+	decoded, err := ParseTransaction(tx.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, tx, decoded, "Decoding restores the transaction and its ID")
+
+	unsigned := tx
+	unsigned.Vin = []TXInput{{[]byte{0xaa, 0xbb}, 1, nil, 5}}
+	assert.Equal(t, tx.ID, unsigned.Hash(), "ID doesn't depend on unlocking scripts")
+}
+
+func TestBlockEncoding(t *testing.T) {
+	tx := testTransaction()
+	block := &Block{1600000000, []*Transaction{&tx}, []byte{0x01}, []byte{0x02}, 7, 3, 0x1f00ffff}
+
+	encoded := block.Serialize()
+	assert.Equal(t, "0101"+"0102"+"06"+"80c0f0f50b"+"ffff83f801"+"0e"+"01", hex.EncodeToString(encoded[:17]))
+
+	decoded, err := ParseBlock(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, block, decoded)
+	assert.Equal(t, block.HashTransactions(), decoded.HashTransactions())
+}
+
+func TestUTXOEncoding(t *testing.T) {
+	outs := TXOutputs{map[int]TXOutput{5: {1, []byte{0x01}}, 2: {2, []byte{0x02}}}, 4, true}
+
+	assert.Equal(t, "0801"+"02"+"02"+"04"+"0102"+"05"+"02"+"0101", hex.EncodeToString(outs.Serialize()), "Outputs are ordered by index")
+	assert.Equal(t, outs, DeserializeOutputs(outs.Serialize()))
+
+	undo := BlockUndo{[]SpentOutput{{[]byte{0x01}, 0, TXOutput{3, []byte{0x03}}, 2, true}}}
+	assert.Equal(t, undo, DeserializeBlockUndo(undo.Serialize()))
+}
+
+func TestNonCanonicalEncodingIsRejected(t *testing.T) {
+	encoded := testTransaction().Serialize()
+
+	_, err := ParseTransaction(append(encoded, 0x00))
+	assert.True(t, errors.Is(err, ErrBadEncoding), "Trailing data is rejected")
+
+	_, err = ParseTransaction(encoded[:len(encoded)-1])
+	assert.True(t, errors.Is(err, ErrBadEncoding), "Truncated data is rejected")
+
+	padded := append([]byte{0x81, 0x00}, encoded[1:]...)
+	_, err = ParseTransaction(padded)
+	assert.True(t, errors.Is(err, ErrBadEncoding), "Varints must be minimal")
+
+	_, err = ParseTransaction([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	assert.True(t, errors.Is(err, ErrBadEncoding), "Lists can't be longer than the data")
+
+	r := canonicalReader{data: []byte{0x02}}
+	r.bool()
+	assert.True(t, errors.Is(r.finish(), ErrBadEncoding), "Booleans are 0 or 1")
+}
+
+func FuzzParseTransaction(f *testing.F) {
+	f.Add(testTransaction().Serialize())
+	f.Add(testCoinbase().Serialize())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tx, err := ParseTransaction(data)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(tx.Serialize(), data) {
+			t.Fatalf("transaction has another encoding: %x", tx.Serialize())
+		}
+	})
+}
+
+func FuzzParseBlock(f *testing.F) {
+	tx := testTransaction()
+	f.Add((&Block{1600000000, []*Transaction{&tx, testCoinbase()}, nil, []byte{0x02}, 7, 3, 0x1f00ffff}).Serialize())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		block, err := ParseBlock(data)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(block.Serialize(), data) {
+			t.Fatalf("block has another encoding: %x", block.Serialize())
+		}
+	})
+}
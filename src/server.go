@@ -2,11 +2,10 @@ package main
 
 import(
 	"bytes"
+	"context"
 	"encoding/gob"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"net"
 )
@@ -15,12 +14,6 @@ const protocol="tcp"
 const nodeVersion=1
 const commandLength=12
 
-var nodeAddress string
-var knownNodes=[]string{"localhost:3000"}
-var miningAddress string
-var blocksInTransit=[][]byte{}
-var mempool=make(map[string]Transaction)
-
 //version send versin msg
 type verzion struct{
 	Version int
@@ -64,29 +57,27 @@ type addr struct{
 	AddrList []string
 }
 
-//StartServer starts a server
-func StartServer(nodeID,minerAddress string){
-	nodeAddress=fmt.Sprintf("localhost:%s",nodeID)
-	miningAddress=minerAddress
+//StartServer starts a node, and a JSON-RPC server if rpcAddress is set
+func StartServer(nodeID,minerAddress,rpcAddress string){
+	bc:=NewBlockchain(nodeID)
+	n:=NewNode(fmt.Sprintf("localhost:%s",nodeID),minerAddress,[]string{centralNode},bc)
 
-	ln,err:=net.Listen(protocol,nodeAddress)
+	err:=n.Listen()
 	if err!=nil{
 		log.Panic(err)
 	}
-	defer ln.Close()
-
-	bc:=NewBlockchain(nodeID)
-
-	if nodeAddress!=knownNodes[0]{
-		sendVersion(knownNodes[0],bc)
-	}
+	defer n.Stop()
 
-	for{
-		conn,err:=ln.Accept()
+	if rpcAddress!=""{
+		err=StartRPCServer(rpcAddress,nodeID,n)
 		if err!=nil{
-			log.Panic(err)
+			log.Println("JSON-RPC server is not started:",err)
 		}
-		go handleConnection(conn,bc)
+	}
+
+	err=n.Serve()
+	if err!=nil{
+		log.Panic(err)
 	}
 }
 
@@ -103,16 +94,6 @@ func gobEncode(data interface{}) []byte{
 	return buff.Bytes()
 }
 
-//nodeIsKnown
-func nodeIsKnown(addr string) bool {
-	for _,node:=range knownNodes{
-		if node==addr{
-			return true
-		}
-	}
-	return false
-}
-
 //commandToBytes command to bytes
 func commandToBytes(command string) []byte {
 	var bytes [commandLength]byte
@@ -137,352 +118,338 @@ func bytesToCommand(bytes []byte) string {
 	return fmt.Sprintf("%s",command)
 }
 
-//extractCommand
-func extractCommand(request []byte) []byte{
-	return request[:commandLength]
+//requestBlocks
+func (n *Node) requestBlocks(){
+	for _,node:=range n.KnownNodes(){
+		if node!=n.address{
+			n.sendGetBlocks(node)
+		}
+	}
 }
 
-//requestBlocks
-func requestBlocks(){
-	for _,node:=range knownNodes{
-		sendGetBlocks(node)
+//broadcastInv announces items to the known nodes except the listed ones
+func (n *Node) broadcastInv(kind string,items [][]byte,except ...string){
+	for _,node:=range n.KnownNodes(){
+		skip:=node==n.address
+		for _,addr:=range except{
+			skip=skip||node==addr
+		}
+		if !skip{
+			n.sendInv(node,kind,items)
+		}
 	}
 }
 
 //sendAddr
-func sendAddr(address string){
-	nodes:=addr{knownNodes}
-	nodes.AddrList=append(nodes.AddrList,nodeAddress)
+func (n *Node) sendAddr(address string){
+	nodes:=addr{n.KnownNodes()}
+	nodes.AddrList=append(nodes.AddrList,n.address)
 	payload:=gobEncode(nodes)
-	request:=append(commandToBytes("addr"),payload...)
-
-	sendData(address,request)
+	n.sendMessage(address,"addr",payload)
 }
 
 //sendBlock
-func sendBlock(addr string,b *Block){
-	data:=block{nodeAddress,b.Serialize()}
+func (n *Node) sendBlock(addr string,b *Block){
+	data:=block{n.address,b.Serialize()}
 	payload:=gobEncode(data)
-	request:=append(commandToBytes("block"),payload...)
-
-	sendData(addr,request)
+	n.sendMessage(addr,"block",payload)
 }
 
-//sendData
-func sendData(addr string,data []byte){
-	conn,err:=net.Dial(protocol,addr)
+//sendMessage queues a message to the node at addr over its persistent connection
+func (n *Node) sendMessage(addr,command string,payload []byte){
+	p,err:=n.connectPeer(addr)
 	if err!=nil{
 		fmt.Printf("%s is not available\n",addr)
-		var updataNodes []string
-
-		for _,node:=range knownNodes{
-			if node!=addr{
-				updataNodes=append(updataNodes,node)
-			}
-		}
-		knownNodes=updataNodes
+		n.removeKnownNode(addr)
 		return
 	}
-	defer conn.Close()
 
-	_,err=io.Copy(conn,bytes.NewReader(data))
+	err=p.QueueMessage(command,payload)
 	if err!=nil{
-		log.Panic(err)
+		fmt.Println(err)
 	}
 }
 
 //sendInv
-func sendInv(address,kind string,items [][]byte){
-	inventory:=inv{nodeAddress,kind,items}
+func (n *Node) sendInv(address,kind string,items [][]byte){
+	inventory:=inv{n.address,kind,items}
 	payload:=gobEncode(inventory)
-	request:=append(commandToBytes("inv"),payload...)
-
-	sendData(address,request)
+	n.sendMessage(address,"inv",payload)
 }
 
 //sendGetBlocks
-func sendGetBlocks(address string){
-	payload:=gobEncode(getblocks{nodeAddress})
-	request:=append(commandToBytes("getblocks"),payload...)
-
-	sendData(address,request)
+func (n *Node) sendGetBlocks(address string){
+	payload:=gobEncode(getblocks{n.address})
+	n.sendMessage(address,"getblocks",payload)
 }
 
 //sendGetData
-func sendGetData(address,kind string,id []byte){
-	payload:=gobEncode(getdata{nodeAddress,kind,id})
-	request:=append(commandToBytes("getdata"),payload...)
-
-	sendData(address,request)
+func (n *Node) sendGetData(address,kind string,id []byte){
+	payload:=gobEncode(getdata{n.address,kind,id})
+	n.sendMessage(address,"getdata",payload)
 }
 
 //sendTx
-func sendTx(addr string,tnx *Transaction){
-	data:=tx{nodeAddress,tnx.Serialize()}
+func (n *Node) sendTx(addr string,tnx *Transaction){
+	data:=tx{n.address,tnx.Serialize()}
 	payload:=gobEncode(data)
-	request:=append(commandToBytes("tx"),payload...)
-
-	sendData(addr,request)
+	n.sendMessage(addr,"tx",payload)
 }
 
 //sendVersion
-func sendVersion(addr string,bc *Blockchain){
-	bestHeith:=bc.GetBestHeight()
-	payload:=gobEncode(verzion{nodeVersion,bestHeith,nodeAddress})
-
-	request:=append(commandToBytes("version"),payload...)
+func (n *Node) sendVersion(addr string){
+	bestHeith:=n.bc.GetBestHeight()
+	payload:=gobEncode(verzion{nodeVersion,bestHeith,n.address})
 
-	sendData(addr,request)
+	n.sendMessage(addr,"version",payload)
 }
 
 //handleAddr
-func handleAddr(request []byte){
-	var buff bytes.Buffer
-	var payload addr
-
-	buff.Write(request[commandLength:])
-	dec:=gob.NewDecoder(&buff)
-	err:=dec.Decode(&payload)
+func (n *Node) handleAddr(request []byte) error{
+	payload,err:=decodeAddr(request)
 	if err!=nil{
-		log.Panic(err)
+		return err
 	}
 
-	knownNodes=append(knownNodes,payload.AddrList...)
-	fmt.Printf("There are %d knowns nodes now!\n",len(knownNodes))
-	requestBlocks()
+	for _,node:=range payload.AddrList{
+		n.addKnownNode(node)
+	}
+	fmt.Printf("There are %d knowns nodes now!\n",len(n.KnownNodes()))
+	n.requestBlocks()
+	return nil
 }
-//handleConnection handles connection
-func handleConnection(conn net.Conn,bc *Blockchain){
-	request,err:=ioutil.ReadAll(conn)
-	if err!=nil{
-		log.Panic(err)
+
+//handleConnection serves an inbound connection until it is closed
+func (n *Node) handleConnection(conn net.Conn){
+	p:=newPeer(n,conn,"",true)
+	if n.isBanned(p.banKey()){
+		fmt.Printf("Refusing connection from banned %s\n",p)
+		conn.Close()
+		return
 	}
-	command:=bytesToCommand(request[:commandLength])
-	fmt.Printf("Received: %s command\n",command)
 
+	go p.writeLoop()
+	p.readLoop()
+}
+
+//handleMessage dispatches a message received from a peer.
+//A *MessageError is returned when the message itself is at fault.
+func (n *Node) handleMessage(p *Peer,command string,request []byte) error{
 	switch command{
 	case "addr":
-		handleAddr(request)
+		return n.handleAddr(request)
 	case "block":
-		handleBlock(request,bc)
+		return n.handleBlock(request)
 	case "inv":
-		handleInv(request,bc)
+		return n.handleInv(request)
 	case "getblocks":
-		handleGetBlocks(request,bc)
+		return n.handleGetBlocks(request)
 	case "getdata":
-		handleGetData(request,bc)
+		return n.handleGetData(request)
 	case "tx":
-		handleTx(request,bc)
+		return n.handleTx(request)
 	case "version":
-		handleVersion(request,bc)
+		return n.handleVersion(p,request)
 	default:
-		fmt.Println("Unknown command!")
+		return &MessageError{command,unknownCommandScore,errors.New("unknown command")}
 	}
-
-	conn.Close()
 }
 
-//handleVersion handles verion command
-func handleVersion(request []byte,bc *Blockchain){
-	var buff bytes.Buffer
-	var payload verzion
-
-	buff.Write(request[commandLength:])
-	dec:=gob.NewDecoder(&buff)
-	err:=dec.Decode(&payload)
+//handleVersion handles verion command.
+//An inbound connection is registered under the sender's address so replies use the same socket,
+//unless another connection already is.
+func (n *Node) handleVersion(p *Peer,request []byte) error{
+	payload,err:=decodeVersion(request)
 	if err!=nil{
-		log.Panic(err)
+		return err
+	}
+
+	if p.inbound&&payload.AddrFrom!=""{
+		p.identify(payload.AddrFrom)
 	}
 
-	myBestHeight:=bc.GetBestHeight()
+	myBestHeight:=n.bc.GetBestHeight()
 	foreignerBestHeight:=payload.BestHeight
 
 	if myBestHeight<foreignerBestHeight{
-		sendGetBlocks(payload.AddrFrom)
+		n.sendGetBlocks(payload.AddrFrom)
 	}else if myBestHeight>foreignerBestHeight{
-		sendVersion(payload.AddrFrom,bc)
+		n.sendVersion(payload.AddrFrom)
 	}
 
-	if !nodeIsKnown(payload.AddrFrom){
-		knownNodes=append(knownNodes,payload.AddrFrom)
+	if payload.AddrFrom!=""{
+		n.addKnownNode(payload.AddrFrom)
 	}
+	return nil
 }
 
 //handleGetBlocks
-func handleGetBlocks(request []byte,bc *Blockchain){
-	var buff bytes.Buffer
-	var payload getblocks
-
-	buff.Write(request[commandLength:])
-	dec:=gob.NewDecoder(&buff)
-	err:=dec.Decode(&payload)
+func (n *Node) handleGetBlocks(request []byte) error{
+	payload,err:=decodeGetBlocks(request)
 	if err!=nil{
-		log.Panic(err)
+		return err
 	}
-	blocks:=bc.GetBlockHashes()
-	sendInv(payload.AddrFrom,"block",blocks)
+	blocks:=n.bc.GetBlockHashes()
+	n.sendInv(payload.AddrFrom,"block",blocks)
+	return nil
 }
 
 //handleInv
-func handleInv(request []byte,bc *Blockchain){
-	var buff bytes.Buffer
-	var payload inv
-
-	buff.Write(request[commandLength:])
-	dec:=gob.NewDecoder(&buff)
-	err:=dec.Decode(&payload)
+func (n *Node) handleInv(request []byte) error{
+	payload,err:=decodeInv(request)
 	if err!=nil{
-		log.Panic(err)
+		return err
 	}
 
 	fmt.Printf("Recevied inventory with %d %s\n",len(payload.Items),payload.Type)
 
 	if payload.Type=="block"{
-		blocksInTransit=payload.Items
-
-		blockHash:=payload.Items[0]
-		sendGetData(payload.AddrFrom,"block",blockHash)
-
-		newInTransit:=[][]byte{}
+		//Inventory lists the newest block first, but parents have to be added before their children
+		var blocksInTransit [][]byte
+		for i:=len(payload.Items)-1;i>=0;i--{
+			blocksInTransit=append(blocksInTransit,payload.Items[i])
+		}
+		n.setBlocksInTransit(blocksInTransit)
 
-		for _,b:=range blocksInTransit{
-			if bytes.Compare(b,blockHash)!=0{
-				newInTransit=append(newInTransit,b)
-			}
+		if blockHash,ok:=n.nextBlockInTransit();ok{
+			n.sendGetData(payload.AddrFrom,"block",blockHash)
 		}
-		blocksInTransit=newInTransit
 	}
 
 	if payload.Type=="tx"{
 		txID:=payload.Items[0]
-		if mempool[hex.EncodeToString(txID)].ID==nil{
-			sendGetData(payload.AddrFrom,"tx",txID)
+		if _,ok:=n.mempool.Get(txID);!ok{
+			n.sendGetData(payload.AddrFrom,"tx",txID)
 		}
 	}
+	return nil
 }
 
 //handleGetData
-func handleGetData(request []byte,bc *Blockchain){
-	var buff bytes.Buffer
-	var payload getdata
-
-	buff.Write(request[commandLength:])
-	dec:=gob.NewDecoder(&buff)
-	err:=dec.Decode(&payload)
+func (n *Node) handleGetData(request []byte) error{
+	payload,err:=decodeGetData(request)
 	if err!=nil{
-		log.Panic(err)
+		return err
 	}
 
 	if payload.Type=="block"{
-		block,err:=bc.GetBlock([]byte(payload.ID))
+		block,err:=n.bc.GetBlock([]byte(payload.ID))
 		if err!=nil{
-			return
+			return err
 		}
-		sendBlock(payload.AddrFrom,&block)
+		n.sendBlock(payload.AddrFrom,&block)
 	}
 
 	if payload.Type=="tx"{
-		txID:=hex.EncodeToString(payload.ID)
-		tx:=mempool[txID]
+		tx,ok:=n.mempool.Get(payload.ID)
+		if !ok{
+			return errors.New("Transaction is not in the mempool")
+		}
 
-		sendTx(payload.AddrFrom,&tx)
+		n.sendTx(payload.AddrFrom,&tx)
 	}
+	return nil
 }
 
 //handleBlock
-func handleBlock(request []byte,bc *Blockchain){
-	var buff bytes.Buffer
-	var payload block
-
-	buff.Write(request[commandLength:])
-	dec:=gob.NewDecoder(&buff)
-	err:=dec.Decode(&payload)
+func (n *Node) handleBlock(request []byte) error{
+	payload,block,err:=decodeBlock(request)
 	if err!=nil{
-		log.Panic(err)
+		return err
 	}
 
-	blockData:=payload.Block
-	block:=DeserializeBlock(blockData)
-
 	fmt.Println("Recevied a new block!")
-	bc.AddBlock(block)
+	evicted,err:=n.bc.AddBlock(block)
+	if err!=nil{
+		n.setBlocksInTransit(nil)
+		if errors.Is(err,ErrOrphanBlock)||errors.Is(err,ErrInvalidBlock){
+			return err
+		}
+		return &MessageError{"block",invalidBlockScore,err}
+	}
 
 	fmt.Printf("Added block %x\n",block.Hash)
 
-	if len(blocksInTransit)>0{
-		blockHash:=blocksInTransit[0]
-		sendGetData(payload.AddrFrom,"block",blockHash)
+	n.abortMiningAt(block.Height)
+	if bytes.Compare(n.bc.Tip(),block.Hash)==0{
+		n.mempool.Update(n.bc)
+		for _,tx:=range evicted{
+			n.mempool.Add(tx,n.bc)
+		}
+	}
 
-		blocksInTransit=blocksInTransit[1:]
-	}else{
-		UTXOSet:=UTXOSet{bc}
-		UTXOSet.Reindex()
+	if blockHash,ok:=n.nextBlockInTransit();ok{
+		n.sendGetData(payload.AddrFrom,"block",blockHash)
 	}
+	return nil
 }
 
 //handleTx
-func handleTx(request []byte,bc *Blockchain){
-	var buff bytes.Buffer
-	var payload tx
+func (n *Node) handleTx(request []byte) error{
+	payload,tx,err:=decodeTx(request)
+	if err!=nil{
+		return err
+	}
+	err=checkTransaction(tx)
+	if err!=nil{
+		return &MessageError{"tx",invalidTxScore,err}
+	}
 
-	buff.Write(request[commandLength:])
-	dec:=gob.NewDecoder(&buff)
-	err:=dec.Decode(&payload)
+	err=n.mempool.Add(tx,n.bc)
 	if err!=nil{
-		log.Panic(err)
+		if errors.Is(err,ErrValueOverflow)||errors.Is(err,ErrBadSignature){
+			return &MessageError{"tx",invalidTxScore,err}
+		}
+		return err
 	}
 
-	txData:=payload.Transaction
-	tx:=DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)]=tx
+	if n.isCentral(){
+		n.broadcastInv("tx",[][]byte{tx.ID},payload.AddrFrom)
+	}
+	n.minePending()
+	return nil
+}
 
-	if nodeAddress==knownNodes[0]{
-		for _,node:=range knownNodes{
-			if node !=nodeAddress&&node!=payload.AddrFrom{
-				sendInv(node,"tx",[][]byte{tx.ID})
-			}
+//minePending starts mining once there are enough pending transactions. The central node only relays them.
+func (n *Node) minePending(){
+	if !n.isCentral()&&n.mempool.Count()>=2&&len(n.miningAddress)>0{
+		n.startMiner()
+	}
+}
+
+//mineTransactions mines blocks with the mempool transactions, highest fee rate first, until it is empty.
+//The coinbase collects the block subsidy and the fees. Transactions stay in the mempool until a block confirming them is connected,
+//those that fail validation are dropped.
+func (n *Node) mineTransactions() error{
+	for n.mempool.Count()>0{
+		height:=n.bc.GetBestHeight()+1
+		txs,fees:=n.mempool.BlockTemplate(n.bc,maxBlockTemplateSize)
+		if len(txs)==0{
+			return nil
 		}
-	}else{
-		if len(mempool)>=2&&len(miningAddress)>0{
-
-			for {
-				var txs []*Transaction
-
-				for id:=range mempool{
-					tx:=mempool[id]
-					if bc.VerifyTransaction(&tx)==true{
-						txs=append(txs,&tx)
-					}
-					delete(mempool,id)
-				}
-
-				if len(txs)==0{
-					fmt.Println("All transactions are invalid!Waiting for new ones...")
-					return
-				}
-
-				cbTx:=NewCoinbaseTX(miningAddress,"")
-				txs=append(txs,cbTx)
-
-				newBlock:=bc.MineBlock(txs)
-				UTXOSet:=UTXOSet{bc}
-				UTXOSet.Reindex()
-
-				/*for _,tx:=range txs{
-					txID:=hex.EncodeToString(tx.ID)
-					delete(mempool,txID)
-				}*/
-
-				for _,node:=range knownNodes{
-					if node!=nodeAddress{
-						sendInv(node,"block",[][]byte{newBlock.Hash})
-					}
-				}
-
-				if len(mempool)==0{
-					break
-				}
+		cbTx:=NewCoinbaseTX(n.miningAddress,"",blockSubsidy(height)+fees)
+		txs=append([]*Transaction{cbTx},txs...)
+
+		newBlock,err:=n.bc.MineBlock(n.startMining(height),txs)
+		n.abortMining()
+		if errors.Is(err,context.Canceled){
+			fmt.Println("Mining aborted, a peer sent a block at the mined height")
+			return nil
+		}
+		var txErr *TxError
+		if errors.As(err,&txErr){
+			if _,ok:=n.mempool.Get(txErr.ID);ok{
+				fmt.Printf("Dropped transaction %x from the mempool: %s\n",txErr.ID,txErr.Err)
+				n.mempool.Remove(txErr.ID)
+				continue
 			}
 		}
+		if err!=nil{
+			return err
+		}
+
+		n.mempool.Update(n.bc)
+		n.broadcastInv("block",[][]byte{newBlock.Hash})
 	}
-}
\ No newline at end of file
+	return nil
+}
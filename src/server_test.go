@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testHash(data string) []byte {
+	hash := sha256.Sum256([]byte(data))
+	return hash[:]
+}
+
+func testCoinbase() *Transaction {
+	return NewCoinbaseTX(string(NewWallet().GetAddress()), "fuzz", subsidy)
+}
+
+func FuzzDecodeVersion(f *testing.F) {
+	f.Add(gobEncode(verzion{nodeVersion, 3, "localhost:3001"}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decodeVersion(data)
+	})
+}
+
+func FuzzDecodeAddr(f *testing.F) {
+	f.Add(gobEncode(addr{[]string{"localhost:3000", "localhost:3001"}}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decodeAddr(data)
+	})
+}
+
+func FuzzDecodeGetBlocks(f *testing.F) {
+	f.Add(gobEncode(getblocks{"localhost:3001"}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decodeGetBlocks(data)
+	})
+}
+
+func FuzzDecodeInv(f *testing.F) {
+	f.Add(gobEncode(inv{"localhost:3001", "block", [][]byte{testHash("a"), testHash("b")}}))
+	f.Add(gobEncode(inv{"localhost:3001", "tx", [][]byte{testHash("c")}}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		payload, err := decodeInv(data)
+		if err == nil {
+			assert.NotEmpty(t, payload.Items)
+		}
+	})
+}
+
+func FuzzDecodeGetData(f *testing.F) {
+	f.Add(gobEncode(getdata{"localhost:3001", "block", testHash("a")}))
+	f.Add(gobEncode(getdata{"localhost:3001", "tx", testHash("b")}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decodeGetData(data)
+	})
+}
+
+func FuzzDecodeBlock(f *testing.F) {
+	genesis := &Block{time.Now().Unix(), []*Transaction{testCoinbase()}, []byte{}, testHash("a"), 1, 0, initialBits()}
+	f.Add(gobEncode(block{"localhost:3001", genesis.Serialize()}))
+	f.Add(gobEncode(block{"localhost:3001", []byte{}}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, b, err := decodeBlock(data)
+		if err == nil {
+			checkBlock(b)
+		}
+	})
+}
+
+func FuzzDecodeTx(f *testing.F) {
+	f.Add(gobEncode(tx{"localhost:3001", testCoinbase().Serialize()}))
+	f.Add(gobEncode(tx{"localhost:3001", []byte{}}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, transaction, err := decodeTx(data)
+		if err == nil {
+			checkTransaction(transaction)
+		}
+	})
+}
+
+func TestMalformedPayloadIsMessageError(t *testing.T) {
+	n := NewNode("", "", nil, nil)
+	for _, command := range []string{"addr", "block", "inv", "getblocks", "getdata", "tx", "version"} {
+		err := n.handleMessage(nil, command, []byte("garbage"))
+
+		var msgErr *MessageError
+		assert.True(t, errors.As(err, &msgErr), command)
+		assert.Equal(t, malformedMessageScore, msgErr.Score, command)
+	}
+
+	_, err := decodeInv(gobEncode(inv{"localhost:3001", "block", [][]byte{}}))
+	assert.True(t, errors.Is(err, ErrBadPayload))
+
+	_, err = decodeGetData(gobEncode(getdata{"localhost:3001", "wallet", testHash("a")}))
+	assert.True(t, errors.Is(err, ErrBadPayload))
+}
+
+func TestMisbehavingPeerIsBanned(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	n := NewNode("", "", nil, nil)
+	p := newPeer(n, local, "", true)
+	go p.writeLoop()
+	go p.readLoop()
+
+	for i := 0; i < banThreshold/unknownCommandScore; i++ {
+		assert.False(t, n.isBanned(p.banKey()))
+		assert.Nil(t, writeMessage(remote, "bogus", []byte{}))
+	}
+
+	select {
+	case <-p.quit:
+	case <-time.After(time.Second):
+		t.Fatal("peer was not disconnected")
+	}
+	assert.True(t, n.isBanned(p.banKey()))
+
+	_, err := n.connectPeer(p.banKey())
+	assert.NotNil(t, err)
+}
+
+func TestCorruptFrameBansPeer(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	n := NewNode("", "", nil, nil)
+	p := newPeer(n, local, "", true)
+	go p.readLoop()
+
+	var frame bytes.Buffer
+	assert.Nil(t, writeMessage(&frame, "version", []byte("payload")))
+	data := frame.Bytes()
+	data[0] ^= 0xff
+	remote.Write(data[:headerLength])
+
+	select {
+	case <-p.quit:
+	case <-time.After(time.Second):
+		t.Fatal("peer was not disconnected")
+	}
+	assert.True(t, n.isBanned(p.banKey()))
+}
+
+func TestAnnouncedAddressDoesntIdentifyPeerForBans(t *testing.T) {
+	n := NewNode("", "", nil, nil)
+	newPipePeer := func(addr string, inbound bool) *Peer {
+		local, remote := net.Pipe()
+		t.Cleanup(func() { local.Close(); remote.Close() })
+		return newPeer(n, local, addr, inbound)
+	}
+
+	registered := newPipePeer("localhost:3001", false)
+	assert.Equal(t, registered, n.registerPeer(registered))
+
+	p := newPipePeer("", true)
+	p.identify("localhost:3001")
+	assert.Equal(t, registered, n.peers["localhost:3001"], "Announced address doesn't replace a registered peer")
+	p.identify("localhost:3002")
+	assert.Equal(t, p, n.peers["localhost:3002"])
+	assert.Equal(t, "pipe", p.banKey(), "Bans are keyed on the remote host, not the announced address")
+
+	p.misbehave(banThreshold/2, errors.New("bad"))
+	assert.Equal(t, banThreshold/2, newPipePeer("", true).score(), "Reconnecting from the same host keeps the score")
+	p.misbehave(banThreshold/2, errors.New("bad"))
+	assert.True(t, n.isBanned("pipe"))
+	assert.False(t, n.isBanned("localhost:3002"))
+}
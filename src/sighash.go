@@ -0,0 +1,93 @@
+package main
+
+import(
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+//SigHashType selects the parts of a transaction an input signature commits to.
+//It is appended to the signature as a single byte.
+type SigHashType byte
+
+const(
+	//SigHashAll signs all inputs and outputs
+	SigHashAll SigHashType=0x01
+	//SigHashNone signs the inputs but no outputs, anyone may change where the coins go
+	SigHashNone SigHashType=0x02
+	//SigHashSingle signs the inputs and only the output with the same index as the signed input
+	SigHashSingle SigHashType=0x03
+	//SigHashAnyoneCanPay is combined with the other types to sign only the signed input,
+	//so others may add inputs of their own
+	SigHashAnyoneCanPay SigHashType=0x80
+)
+
+//ErrBadSigHashType is returned for a signature hash type that isn't defined
+var ErrBadSigHashType=errors.New("signature hash type is invalid")
+
+//ErrSigHashSingle is returned when SigHashSingle signs an input without a matching output
+var ErrSigHashSingle=errors.New("SIGHASH_SINGLE input has no output with the same index")
+
+//base returns the type without the SigHashAnyoneCanPay flag
+func (t SigHashType) base() SigHashType{
+	return t&^SigHashAnyoneCanPay
+}
+
+//Valid reports whether the type is one of the defined types, optionally with SigHashAnyoneCanPay
+func (t SigHashType) Valid() bool{
+	base:=t.base()
+	return base>=SigHashAll&&base<=SigHashSingle
+}
+
+//SignatureHash returns the digest signed by input inID.
+//It is the double SHA-256 of the canonical encoding of a copy of the transaction
+//without unlocking scripts, where the signed input carries script, the locking
+//script of the output it spends or its redeem script, followed by the hash type as a 4-byte little
+//endian number. The hash type selects the inputs and outputs kept in the copy:
+//  - SigHashAll keeps every output
+//  - SigHashNone drops the outputs and the sequences of the other inputs
+//  - SigHashSingle keeps the outputs up to inID and blanks those before it,
+//    and drops the sequences of the other inputs
+//  - SigHashAnyoneCanPay keeps only the signed input
+func (tx *Transaction) SignatureHash(inID int,script []byte,hashType SigHashType) ([]byte,error){
+	if inID<0||inID>=len(tx.Vin){
+		return nil,errors.New("input index is out of range")
+	}
+	if !hashType.Valid(){
+		return nil,ErrBadSigHashType
+	}
+
+	txCopy:=tx.TrimmedCopy()
+	txCopy.Vin[inID].ScriptSig=script
+
+	if hashType.base()!=SigHashAll{
+		for i:=range txCopy.Vin{
+			if i!=inID{
+				txCopy.Vin[i].Sequence=0
+			}
+		}
+	}
+
+	switch hashType.base(){
+	case SigHashNone:
+		txCopy.Vout=nil
+	case SigHashSingle:
+		if inID>=len(txCopy.Vout){
+			return nil,ErrSigHashSingle
+		}
+		txCopy.Vout=txCopy.Vout[:inID+1]
+		for i:=0;i<inID;i++{
+			txCopy.Vout[i]=TXOutput{-1,nil}
+		}
+	}
+
+	if hashType&SigHashAnyoneCanPay!=0{
+		txCopy.Vin=[]TXInput{txCopy.Vin[inID]}
+	}
+
+	data:=binary.LittleEndian.AppendUint32(txCopy.Serialize(),uint32(hashType))
+	first:=sha256.Sum256(data)
+	second:=sha256.Sum256(first[:])
+
+	return second[:],nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fundingTx creates a transaction paying 10 to each wallet
+func fundingTx(wallets ...*Wallet) Transaction {
+	prev := Transaction{nil, []TXInput{{[]byte{}, -1, []byte("funding"), 0}}, nil, 0}
+	for _, w := range wallets {
+		prev.Vout = append(prev.Vout, *NewTXOutput(10, string(w.GetAddress())))
+	}
+	prev.ID = prev.Hash()
+	return prev
+}
+
+// twoInputTx spends both outputs of prev, paying to two new addresses
+func twoInputTx(alice, bob *Wallet, prev Transaction) *Transaction {
+	tx := Transaction{nil,
+		[]TXInput{{prev.ID, 0, nil, 0}, {prev.ID, 1, nil, 0}},
+		[]TXOutput{*NewTXOutput(9, string(NewWallet().GetAddress())), *NewTXOutput(9, string(NewWallet().GetAddress()))}, 0}
+	tx.ID = tx.Hash()
+	return &tx
+}
+
+func TestSigHashTypes(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	prev := fundingTx(alice, bob)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): prev}
+
+	sign := func(tx *Transaction, hashType SigHashType) {
+		assert.Nil(t, tx.SignInput(0, alice, prev.Vout[0], nil, hashType))
+		assert.Nil(t, tx.SignInput(1, bob, prev.Vout[1], nil, SigHashAll))
+	}
+
+	tx := twoInputTx(alice, bob, prev)
+	sign(tx, SigHashAll)
+	assert.True(t, tx.Verify(prevTXs), "Every owner signs its own input")
+	tx.Vout[1].Value = 8
+	assert.False(t, tx.Verify(prevTXs), "SIGHASH_ALL covers every output")
+
+	tx = twoInputTx(alice, bob, prev)
+	sign(tx, SigHashNone)
+	tx.Vout[0].Value = 1
+	assert.Nil(t, tx.SignInput(1, bob, prev.Vout[1], nil, SigHashAll))
+	assert.True(t, tx.Verify(prevTXs), "SIGHASH_NONE doesn't cover outputs")
+	tx.Vin = tx.Vin[:1]
+	assert.False(t, tx.Verify(prevTXs), "SIGHASH_NONE covers the other inputs")
+
+	tx = twoInputTx(alice, bob, prev)
+	assert.Nil(t, tx.SignInput(0, alice, prev.Vout[0], nil, SigHashSingle))
+	tx.Vout[1].Value = 1
+	assert.Nil(t, tx.SignInput(1, bob, prev.Vout[1], nil, SigHashAll))
+	assert.True(t, tx.Verify(prevTXs), "SIGHASH_SINGLE doesn't cover other outputs")
+	tx.Vout[0].Value = 1
+	assert.Nil(t, tx.SignInput(1, bob, prev.Vout[1], nil, SigHashAll))
+	assert.False(t, tx.Verify(prevTXs), "SIGHASH_SINGLE covers the output with the same index")
+
+	tx = twoInputTx(alice, bob, prev)
+	tx.Vout = tx.Vout[:1]
+	assert.True(t, errors.Is(tx.SignInput(1, bob, prev.Vout[1], nil, SigHashSingle), ErrSigHashSingle))
+
+	assert.True(t, errors.Is(tx.SignInput(0, alice, prev.Vout[0], nil, SigHashType(0x04)), ErrBadSigHashType))
+	assert.Nil(t, tx.SignInput(0, alice, prev.Vout[0], nil, SigHashAll))
+	scriptSig := tx.Vin[0].ScriptSig
+	scriptSig[scriptSig[0]] = 0x04 // last byte of the signature pushed first
+	assert.False(t, tx.Verify(prevTXs), "Unknown hash type is rejected")
+}
+
+func TestSigHashAnyoneCanPay(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	prev := fundingTx(alice, bob)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): prev}
+
+	tx := Transaction{nil,
+		[]TXInput{{prev.ID, 0, nil, 0}},
+		[]TXOutput{*NewTXOutput(15, string(NewWallet().GetAddress()))}, 0}
+	assert.Nil(t, tx.SignInput(0, alice, prev.Vout[0], nil, SigHashAll|SigHashAnyoneCanPay))
+
+	tx.Vin = append(tx.Vin, TXInput{prev.ID, 1, nil, 0})
+	tx.ID = tx.Hash()
+	assert.Nil(t, tx.SignInput(1, bob, prev.Vout[1], nil, SigHashAll))
+	assert.True(t, tx.Verify(prevTXs), "Inputs can be added after an ANYONECANPAY signature")
+
+	tx.Vout[0].Value = 20
+	assert.False(t, tx.Verify(prevTXs), "ANYONECANPAY still covers the outputs")
+}
+
+func TestMultiPartyTransactionIsMined(t *testing.T) {
+	bc, alice := newTestBlockchain(t)
+	bob := NewWallet()
+	genesis := bc.Iterator().Next().Transactions[0]
+	bobFunds := mineCoinbase(t, bc, bob)
+
+	tx := Transaction{nil,
+		[]TXInput{{genesis.ID, 0, nil, 0}, {bobFunds.ID, 0, nil, 0}},
+		[]TXOutput{*NewTXOutput(2*subsidy, string(NewWallet().GetAddress()))}, 0}
+	tx.ID = tx.Hash()
+	assert.Nil(t, tx.SignInput(0, alice, genesis.Vout[0], nil, SigHashAll))
+	assert.Nil(t, tx.SignInput(1, bob, bobFunds.Vout[0], nil, SigHashAll))
+
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(alice.GetAddress()), "", subsidy), &tx})
+	assert.Nil(t, err)
+}
+
+func TestSignatureHashIsDoubleSHA256(t *testing.T) {
+	tx := testTransaction()
+
+	hash, err := tx.SignatureHash(0, []byte{0xcc}, SigHashAll)
+	assert.Nil(t, err)
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[0].ScriptSig = []byte{0xcc}
+	data := append(txCopy.Serialize(), 0x01, 0x00, 0x00, 0x00)
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	assert.Equal(t, second[:], hash)
+
+	_, err = tx.SignatureHash(1, nil, SigHashAll)
+	assert.NotNil(t, err, "Input must exist")
+}
@@ -1,46 +1,97 @@
 package main
 
 import(
-	"bytes"
 	"crypto/sha256"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
-	"math/big"
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
 	"strings"
 )
 
+//subsidy is the reward of the blocks before the first halving
 const subsidy=10
 
+//maxMoney is the largest amount an output, the outputs of a transaction or the fees of a block may add up to
+const maxMoney=21000000*subsidy
+
+var ErrNotEnoughFunds=errors.New("not enough funds")
+
+var(
+	//halvingInterval is the number of blocks after which the block reward halves
+	halvingInterval=210
+	//coinbaseMaturity is the number of blocks that must follow a coinbase before its outputs can be spent
+	coinbaseMaturity=10
+)
+
+//loadRewardParams overrides the reward schedule with the HALVING_INTERVAL and COINBASE_MATURITY env. vars.
+//All nodes of a network must use the same values.
+func loadRewardParams() error{
+	if value:=os.Getenv("HALVING_INTERVAL");value!=""{
+		interval,err:=strconv.Atoi(value)
+		if err!=nil||interval<=0{
+			return errors.New("HALVING_INTERVAL must be a positive number of blocks")
+		}
+		halvingInterval=interval
+	}
+
+	if value:=os.Getenv("COINBASE_MATURITY");value!=""{
+		maturity,err:=strconv.Atoi(value)
+		if err!=nil||maturity<=0{
+			return errors.New("COINBASE_MATURITY must be a positive number of blocks")
+		}
+		coinbaseMaturity=maturity
+	}
+	return nil
+}
+
+//blockSubsidy returns the new coins a block at height may create.
+//The reward halves every halvingInterval blocks until it reaches zero.
+func blockSubsidy(height int) int{
+	halvings:=height/halvingInterval
+	if halvings>=strconv.IntSize-1{
+		return 0
+	}
+	return subsidy>>uint(halvings)
+}
+
 type Transaction struct{
 	ID []byte
 	Vin []TXInput
 	Vout []TXOutput
+	//LockTime is the last block height, or the Unix time, before the transaction can be mined
+	LockTime uint32
 }
 
-//Serialize return a serialized Transaction
+//Serialize returns the canonical encoding of the Transaction
 func (tx Transaction) Serialize() []byte{
-	var encoded bytes.Buffer
+	var w canonicalWriter
+	tx.encode(&w)
+
+	return w.data
+}
 
-	enc:=gob.NewEncoder(&encoded)
-	err:=enc.Encode(tx)
+//ParseTransaction decodes a canonically encoded transaction and computes its ID
+func ParseTransaction(data []byte) (Transaction,error){
+	var transaction Transaction
+
+	r:=canonicalReader{data:data}
+	transaction.decode(&r)
+	err:=r.finish()
 	if err!=nil{
-		log.Panic(err)
+		return Transaction{},err
 	}
+	transaction.ID=transaction.Hash()
 
-	return encoded.Bytes()
+	return transaction,nil
 }
 
 //DeserializeTransaction deserialzes a transaction
 func DeserializeTransaction(data []byte) Transaction{
-	var transaction Transaction
-
-	decoder:=gob.NewDecoder(bytes.NewReader(data))
-	err:=decoder.Decode(&transaction)
+	transaction,err:=ParseTransaction(data)
 	if err!=nil{
 		log.Panic(err)
 	}
@@ -49,12 +100,21 @@ func DeserializeTransaction(data []byte) Transaction{
 }
 
 
-//Hash returns the hash of the Transaction
+//Hash returns the SHA-256 hash of the canonical encoding of the Transaction.
+//Unlocking scripts are left out so the ID set before signing stays valid,
+//except for the coinbase data, which tells coinbases paying the same output apart.
 func (tx *Transaction) Hash()[]byte{
 	var hash [32]byte
 
 	txCopy:=*tx
 	txCopy.ID=[]byte{}
+	if !tx.IsCoinbase(){
+		txCopy.Vin=nil
+		for _,vin:=range tx.Vin{
+			vin.ScriptSig=nil
+			txCopy.Vin=append(txCopy.Vin,vin)
+		}
+	}
 
 	hash=sha256.Sum256(txCopy.Serialize())
 
@@ -65,8 +125,8 @@ func (tx Transaction) IsCoinbase() bool{
 	return len(tx.Vin)==1 && len(tx.Vin[0].Txid)==0 && tx.Vin[0].Vout==-1
 }
 
-//Sign signs each input of a Transaction
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey,prevTXs map[string]Transaction){
+//Sign signs each input of a Transaction with the wallet's key and SigHashAll
+func (tx *Transaction) Sign(wallet *Wallet,prevTXs map[string]Transaction){
 	if tx.IsCoinbase(){
 		return
 	}
@@ -76,26 +136,17 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey,prevTXs map[string]Transact
 			log.Panic("ERROR:previous transaction is not correct")
 		}
 	}
-	txCopy:=tx.TrimmedCopy()
 
-	for inID,vin:=range txCopy.Vin{
+	for inID,vin:=range tx.Vin{
 		prevTx:=prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature=nil
-		txCopy.Vin[inID].PubKey=prevTx.Vout[vin.Vout].PubKeyHash
-		
-		dataToSign:=fmt.Sprintf("%x\n",txCopy)
-		r,s,err:=ecdsa.Sign(rand.Reader,&privKey,[]byte(dataToSign))
+		err:=tx.SignInput(inID,wallet,prevTx.Vout[vin.Vout],nil,SigHashAll)
 		if err!=nil{
 			log.Panic(err)
 		}
-		signature:=append(r.Bytes(),s.Bytes()...)
-
-		tx.Vin[inID].Signature=signature
-		txCopy.Vin[inID].PubKey=nil
 	}
 }
 
-//Verify verifies signatures of Transaction inputs
+//Verify runs the scripts of each Transaction input
 func (tx *Transaction) Verify(prevTXs map[string]Transaction)bool{
 	if tx.IsCoinbase(){
 		return true
@@ -107,59 +158,49 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction)bool{
 		}
 	}
 
-	txCopy:=tx.TrimmedCopy()
-	curve:=elliptic.P256()
-
 	for inID,vin:=range tx.Vin{
 		prevTx:=prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature=nil
-		txCopy.Vin[inID].PubKey=prevTx.Vout[vin.Vout].PubKeyHash
-		//txCopy.ID=txCopy.Hash()
-		//txCopy.Vin[inID].PubKey=nil
-
-		r:=big.Int{}
-		s:=big.Int{}
-		sigLen:=len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen/2)])
-		s.SetBytes(vin.Signature[(sigLen/2):])
-
-		x:=big.Int{}
-		y:=big.Int{}
-		keyLen:=len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen/2)])
-		y.SetBytes(vin.PubKey[(keyLen/2):])
-
-		dataToVerfiy:=fmt.Sprintf("%x\n",txCopy)
-
-		rawPubKey:=ecdsa.PublicKey{Curve:curve,X:&x,Y:&y}
-		if ecdsa.Verify(&rawPubKey,[]byte(dataToVerfiy),&r,&s)==false{
+		if vin.Vout<0||vin.Vout>=len(prevTx.Vout){
+			return false
+		}
+		if tx.VerifyInput(inID,prevTx.Vout[vin.Vout])!=nil{
 			return false
 		}
-		txCopy.Vin[inID].PubKey=nil
 	}
 	return true
 }
 
+//OutputValue returns the sum of the transaction outputs
+func (tx Transaction) OutputValue() int{
+	value:=0
+	for _,out:=range tx.Vout{
+		value+=out.Value
+	}
+
+	return value
+}
+
 //TirmmedCopy Copy a Transaction
 func (tx *Transaction) TrimmedCopy() Transaction{
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	for _,vin:=range tx.Vin{
-		inputs=append(inputs,TXInput{vin.Txid,vin.Vout,nil,nil})
+		inputs=append(inputs,TXInput{vin.Txid,vin.Vout,nil,vin.Sequence})
 	}
 
 	for _,vout:=range tx.Vout{
-		outputs=append(outputs,TXOutput{vout.Value,vout.PubKeyHash})
+		outputs=append(outputs,TXOutput{vout.Value,vout.ScriptPubKey})
 	}
 
-	txCopy:=Transaction{tx.ID,inputs,outputs}
+	txCopy:=Transaction{tx.ID,inputs,outputs,tx.LockTime}
 
 	return txCopy
 }
 
-//NewCoinbaseTX creates a new coinbase transaction
-func NewCoinbaseTX(to,data string) *Transaction {
+//NewCoinbaseTX creates a new coinbase transaction paying value,
+//which is the block subsidy plus the fees of the block's transactions
+func NewCoinbaseTX(to,data string,value int) *Transaction {
 	if data==""{
 		randData:=make([]byte,20)
 		_,err:=rand.Read(randData)
@@ -170,50 +211,82 @@ func NewCoinbaseTX(to,data string) *Transaction {
 		data=fmt.Sprintf("%x'",randData)
 	}
 
-	txin:=TXInput{[]byte{},-1,nil,[]byte(data)}
-	//txout:=TXOutput{subsidy,to}
-	txout:=NewTXOutput(subsidy,to)
-	tx:=Transaction{nil,[]TXInput{txin},[]TXOutput{*txout}}
+	txin:=TXInput{[]byte{},-1,[]byte(data),maxSequence}
+	txout:=NewTXOutput(value,to)
+	tx:=Transaction{nil,[]TXInput{txin},[]TXOutput{*txout},0}
 	tx.ID=tx.Hash()
 
 	return &tx
 }
 
-//NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(wallet *Wallet,to string,amount int,UTXOSet *UTXOSet) *Transaction{
+//TxOptions are the choices of the wallet when it builds a transaction
+type TxOptions struct{
+	//Fee is paid to the miner on top of FeeRate coins per 1000 bytes of the transaction
+	Fee int
+	FeeRate int
+	//LockTime keeps the transaction out of blocks until it passes when it isn't zero
+	LockTime uint32
+	//CoinSelection names one of CoinSelectors, DefaultCoinSelection when empty
+	CoinSelection string
+}
+
+//NewUTXOTransaction creates a new transaction paying amount to the address and the fee to the miner
+func NewUTXOTransaction(wallet *Wallet,to string,amount int,options TxOptions,UTXOSet *UTXOSet) (*Transaction,error){
+	return NewBatchTransaction(wallet,[]Recipient{{to,amount}},options,UTXOSet)
+}
+
+//NewBatchTransaction creates a transaction paying every recipient and the fee to the miner.
+//Change goes back to the wallet's address unless it is worth less than the fee of its output.
+//Wallets without their private key, such as those of a locked wallet file, can't pay.
+func NewBatchTransaction(wallet *Wallet,recipients []Recipient,options TxOptions,UTXOSet *UTXOSet) (*Transaction,error){
 	var inputs []TXInput
-	var outputs []TXOutput
 
-	pubKeyHash:=HashPubKey(wallet.PublicKey	)	
-	acc,validOutputs:=UTXOSet.FindSpendableOutputs(pubKeyHash,amount)
+	if wallet.PrivateKey.D==nil{
+		return nil,ErrWalletLocked
+	}
+	if len(recipients)==0{
+		return nil,errors.New("transaction has no recipients")
+	}
+	selectCoins,err:=options.coinSelector()
+	if err!=nil{
+		return nil,err
+	}
 
-	if acc<amount{
-		log.Panic("ERROR: Not enough funds")
+	tx:=Transaction{nil,nil,nil,options.LockTime}
+	for _,recipient:=range recipients{
+		tx.Vout=append(tx.Vout,*NewTXOutput(recipient.Amount,recipient.Address))
 	}
+	amount:=TotalAmount(recipients)
 
-	for txid,outs:=range validOutputs{
-		txID,err:=hex.DecodeString(txid)
-		if err!=nil{
-			log.Panic(err)
-		}
+	from:=fmt.Sprintf("%s",wallet.GetAddress())
+	change:=NewTXOutput(0,from)
+	fees:=NewTxFees(options.Fee,options.FeeRate,tx,*change,2+maxSignatureSize+len(wallet.PublicKey))
 
-		for _,out:=range outs{
-			input:=TXInput{txID,out,nil,wallet.PublicKey}
-			inputs=append(inputs,input)
+	coins:=selectCoins(UTXOSet.FindSpendableCoins(wallet.LockingScript()),amount,fees)
+	if coins==nil{
+		return nil,ErrNotEnoughFunds
+	}
+
+	acc:=0
+	for _,coin:=range coins{
+		input:=TXInput{coin.Txid,coin.Vout,nil,maxSequence}
+		if options.LockTime>0{
+			input.Sequence=maxSequence-1
 		}
+		inputs=append(inputs,input)
+		acc+=coin.Value
 	}
+	tx.Vin=inputs
 
-	from:=fmt.Sprintf("%s",wallet.GetAddress())
-	outputs=append(outputs,*NewTXOutput(amount,to))
-	if acc>amount{
-		outputs=append(outputs,*NewTXOutput(acc-amount,from))
+	change.Value=acc-amount-fees.For(len(coins),true)
+	if change.Value>0{
+		tx.Vout=append(tx.Vout,*change)
 	}
 
-	tx:=Transaction{nil,inputs,outputs}
 	tx.ID=tx.Hash()
-	UTXOSet.Blockchain.SignTransaction(&tx,wallet.PrivateKey)	
+	UTXOSet.Blockchain.SignTransaction(&tx,wallet)	
 
-	return &tx
+	return &tx,nil
 }
 
 //String returns a human-readable representation of a transaction
@@ -226,14 +299,18 @@ func (tx Transaction) String() string{
 		lines=append(lines,fmt.Sprintf("-Input %d:",i))
 		lines=append(lines,fmt.Sprintf("  TXID:		%x",input.Txid))
 		lines=append(lines,fmt.Sprintf("  Out:		%d",input.Vout))
-		lines=append(lines,fmt.Sprintf("  Signature:		%x",input.Signature))
-		lines=append(lines,fmt.Sprintf("  PubKey:	%x",input.PubKey))
+		lines=append(lines,fmt.Sprintf("  ScriptSig:	%x",input.ScriptSig))
+		lines=append(lines,fmt.Sprintf("  Sequence:	%x",input.Sequence))
 	}
 
 	for i,output:=range tx.Vout{
 		lines=append(lines,fmt.Sprintf("-Output:%d",i))
 		lines=append(lines,fmt.Sprintf("  Value:	%d",output.Value))
-		lines=append(lines,fmt.Sprintf("  Script:	%x",output.PubKeyHash))
+		lines=append(lines,fmt.Sprintf("  Script:	%x",output.ScriptPubKey))
+	}
+
+	if tx.LockTime>0{
+		lines=append(lines,fmt.Sprintf("-LockTime:	%d",tx.LockTime))
 	}
 
 	return strings.Join(lines,"\n")
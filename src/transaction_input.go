@@ -1,17 +1,10 @@
 package main
 
-import "bytes"
-
 // TXInput represents a transaction input
 type TXInput struct{
 	Txid []byte
 	Vout int
-	Signature []byte
-	PubKey []byte
-}
-
-// UsesKey checks whether the address initiated the transaction
-func (in *TXInput) UsesKey(pubKeyHash []byte)bool {
-	lockingHash:=HashPubKey(in.PubKey)
-	return bytes.Compare(lockingHash,pubKeyHash)==0
+	ScriptSig []byte
+	//Sequence enables the transaction locktime unless it is maxSequence, and holds the relative lock of the input
+	Sequence uint32
 }
@@ -2,26 +2,23 @@ package main
 
 import(
 	"bytes"
-	"encoding/gob"
 	"log"
 )
 
 //TXOutput represents a transaction output
 type TXOutput struct{
 	Value int
-	PubKeyHash []byte
+	ScriptPubKey []byte
 }
 
-// Lock signs the output
+// Lock locks the output to the address
 func (out *TXOutput) Lock(address []byte){
-	pubKeyHash:=Base58Decode(address)
-	pubKeyHash=pubKeyHash[1:len(pubKeyHash)-4]
-	out.PubKeyHash=pubKeyHash
+	out.ScriptPubKey=AddressToScript(string(address))
 }
 
-//IsLockedWithKey checks if the output can be used by the owner of the pubkey
-func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash,pubKeyHash)==0
+//IsLockedWith checks if the output is locked with the script
+func (out *TXOutput) IsLockedWith(script []byte) bool {
+	return bytes.Equal(out.ScriptPubKey,script)
 }
 
 // NewTXOutput create a new TXOutput
@@ -32,33 +29,38 @@ func NewTXOutput(value int,address string) *TXOutput{
 	return txo
 }
 
-// TXOutputs collects TXOutput
+// TXOutputs collects the unspent outputs of a transaction keyed by their index,
+// with the height of the block that created them
 type TXOutputs struct{
-	Outputs []TXOutput
+	Outputs map[int]TXOutput
+	Height int
+	Coinbase bool
 }
 
-//Serialize serializes TXOutputsuts
-func (outs TXOutputs) Serialize() []byte{
-	var buff bytes.Buffer
+//IsMature reports whether the outputs can be spent in a block at height.
+//Coinbase outputs have to wait coinbaseMaturity blocks.
+func (outs TXOutputs) IsMature(height int) bool{
+	return !outs.Coinbase||height-outs.Height>=coinbaseMaturity
+}
 
-	enc:=gob.NewEncoder(&buff)
-	err:=enc.Encode(outs)
-	if err!=nil{
-		log.Panic(err)
-	}
+//Serialize returns the canonical encoding of TXOutputs
+func (outs TXOutputs) Serialize() []byte{
+	var w canonicalWriter
+	outs.encode(&w)
 
-	return buff.Bytes()
+	return w.data
 }
 
 //DeserializeOutputs deserializes TXOutputs
 func DeserializeOutputs(data []byte) TXOutputs{
 	var outputs TXOutputs
 
-	 dec:=gob.NewDecoder(bytes.NewReader(data))
-	 err:=dec.Decode(&outputs)
-	 if err!=nil{
-	 	log.Panic(err)
-	 }
+	r:=canonicalReader{data:data}
+	outputs.decode(&r)
+	err:=r.finish()
+	if err!=nil{
+		log.Panic(err)
+	}
 
-	 return outputs
+	return outputs
 }
\ No newline at end of file
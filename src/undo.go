@@ -0,0 +1,44 @@
+package main
+
+import(
+	"log"
+)
+
+const undoBucket="undo"
+
+//SpentOutput is an output spent by a block, kept so the block can be undone
+type SpentOutput struct{
+	Txid []byte
+	Vout int
+	Output TXOutput
+	//Height and Coinbase describe the transaction that created the output
+	Height int
+	Coinbase bool
+}
+
+//BlockUndo holds the outputs spent by a block in the order they were spent
+type BlockUndo struct{
+	Spent []SpentOutput
+}
+
+//Serialize returns the canonical encoding of the undo record
+func (u BlockUndo) Serialize() []byte{
+	var w canonicalWriter
+	u.encode(&w)
+
+	return w.data
+}
+
+//DeserializeBlockUndo deserializes an undo record
+func DeserializeBlockUndo(data []byte) BlockUndo{
+	var undo BlockUndo
+
+	r:=canonicalReader{data:data}
+	undo.decode(&r)
+	err:=r.finish()
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return undo
+}
@@ -1,7 +1,9 @@
 package main
 
 import(
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"log"
 	"github.com/boltdb/bolt"
 )
@@ -57,7 +59,9 @@ func (u UTXOSet) Reindex(){
 	}
 }
 
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte,amount int) (int,map[string][]int){
+//FindSpendableOutputs finds unspent outputs locked with the script worth at least amount.
+//Immature coinbase outputs are left out.
+func (u UTXOSet) FindSpendableOutputs(script []byte,amount int) (int,map[string][]int){
 	unspentOutputs:=make(map[string][]int)
 	accumulated:=0
 	db:=u.Blockchain.db
@@ -65,13 +69,17 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte,amount int) (int,map[str
 	err:=db.View(func(tx *bolt.Tx) error {
 		b:=tx.Bucket([]byte(utxoBucket))
 		c:=b.Cursor()
+		height:=bestHeight(tx)+1
 
 		for k,v:=c.First();k!=nil;k,v=c.Next(){
 			txID:=hex.EncodeToString(k)
 			outs:=DeserializeOutputs(v)
+			if !outs.IsMature(height){
+				continue
+			}
 
 			for outIdx,out:=range outs.Outputs{
-				if out.IsLockedWithKey(pubKeyHash)&&accumulated<amount{
+				if out.IsLockedWith(script)&&accumulated<amount{
 					accumulated+=out.Value
 					unspentOutputs[txID]=append(unspentOutputs[txID],outIdx)
 				}
@@ -86,7 +94,39 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte,amount int) (int,map[str
 	return accumulated,unspentOutputs
 }
 
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput{
+//FindSpendableCoins returns the unspent outputs locked with the script that can be spent in the next block
+func (u UTXOSet) FindSpendableCoins(script []byte) []Coin{
+	var coins []Coin
+	db:=u.Blockchain.db
+
+	err:=db.View(func(tx *bolt.Tx) error {
+		b:=tx.Bucket([]byte(utxoBucket))
+		c:=b.Cursor()
+		height:=bestHeight(tx)+1
+
+		for k,v:=c.First();k!=nil;k,v=c.Next(){
+			outs:=DeserializeOutputs(v)
+			if !outs.IsMature(height){
+				continue
+			}
+
+			for outIdx,out:=range outs.Outputs{
+				if out.IsLockedWith(script){
+					coins=append(coins,Coin{append([]byte{},k...),outIdx,out.Value})
+				}
+			}
+		}
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return coins
+}
+
+//FindUTXO returns the unspent outputs locked with the script
+func (u UTXOSet) FindUTXO(script []byte) []TXOutput{
 	var UTXOs []TXOutput
 	db:=u.Blockchain.db
 
@@ -98,7 +138,7 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput{
 			outs:=DeserializeOutputs(v)
 
 			for _,out:=range outs.Outputs{
-				if out.IsLockedWithKey(pubKeyHash){
+				if out.IsLockedWith(script){
 					UTXOs=append(UTXOs,out)
 				}
 			}
@@ -112,51 +152,231 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput{
 	return UTXOs
 }
 
+//FindBalance returns the value of the outputs locked with the script that can be spent
+//in the next block and the value of the immature coinbase outputs
+func (u UTXOSet) FindBalance(script []byte) (int,int){
+	return u.FindScriptsBalance([][]byte{script})
+}
+
+//FindScriptsBalance is FindBalance of the outputs locked with any of the scripts
+func (u UTXOSet) FindScriptsBalance(scripts [][]byte) (int,int){
+	balance,immature:=0,0
+	db:=u.Blockchain.db
+
+	locked:=make(map[string]bool)
+	for _,script:=range scripts{
+		locked[string(script)]=true
+	}
+
+	err:=db.View(func(tx *bolt.Tx)error{
+		c:=tx.Bucket([]byte(utxoBucket)).Cursor()
+		height:=bestHeight(tx)+1
+
+		for k,v:=c.First();k!=nil;k,v=c.Next(){
+			outs:=DeserializeOutputs(v)
+
+			for _,out:=range outs.Outputs{
+				if !locked[string(out.ScriptPubKey)]{
+					continue
+				}
+				if outs.IsMature(height){
+					balance+=out.Value
+				}else{
+					immature+=out.Value
+				}
+			}
+		}
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return balance,immature
+}
+
+//FindOutput returns the unspent output vout of the transaction txid
+func (u UTXOSet) FindOutput(txid []byte,vout int) (TXOutput,bool){
+	var out TXOutput
+	found:=false
+	db:=u.Blockchain.db
+
+	err:=db.View(func(tx *bolt.Tx)error{
+		out,found=findOutput(tx.Bucket([]byte(utxoBucket)),txid,vout)
+		return nil
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+
+	return out,found
+}
+
+//findOutput looks up an unspent output in the UTXO bucket
+func findOutput(b *bolt.Bucket,txid []byte,vout int) (TXOutput,bool){
+	outs,_:=findOutputs(b,txid)
+	out,ok:=outs.Outputs[vout]
+
+	return out,ok
+}
+
+//findOutputs looks up the unspent outputs of a transaction in the UTXO bucket
+func findOutputs(b *bolt.Bucket,txid []byte) (TXOutputs,bool){
+	outsBytes:=b.Get(txid)
+	if outsBytes==nil{
+		return TXOutputs{},false
+	}
+
+	return DeserializeOutputs(outsBytes),true
+}
+
+//Update updates the UTXO set with transactions from the Block
 func (u UTXOSet) Update(block *Block){
 	db:=u.Blockchain.db
 
 	err:=db.Update(func(tx *bolt.Tx)error{
-		b:=tx.Bucket([]byte(utxoBucket))
+		return connectBlock(tx,block)
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+}
+
+//Revert undoes Update for the Block, which must be the last one applied
+func (u UTXOSet) Revert(block *Block){
+	db:=u.Blockchain.db
+
+	err:=db.Update(func(tx *bolt.Tx)error{
+		return disconnectBlock(tx,block)
+	})
+	if err!=nil{
+		log.Panic(err)
+	}
+}
 
-		for _,tx:=range block.Transactions{
-			if tx.IsCoinbase()==false{
-				for _,vin:=range tx.Vin{
-					updateOuts:=TXOutputs{}
-					outsBytes:=b.Get(vin.Txid)
-					outs:=DeserializeOutputs(outsBytes)
-
-					for outIdx,out:=range outs.Outputs{
-						if outIdx!=vin.Vout{
-							updateOuts.Outputs=append(updateOuts.Outputs,out)
-						}
+//connectBlock removes outputs spent by the block from the UTXO bucket and adds the new ones.
+//The spent outputs are saved in the undo bucket.
+func connectBlock(dbTx *bolt.Tx,block *Block) error{
+	b:=dbTx.Bucket([]byte(utxoBucket))
+	undo:=BlockUndo{}
+
+	for _,tx:=range block.Transactions{
+		if tx.IsCoinbase()==false{
+			for _,vin:=range tx.Vin{
+				outsBytes:=b.Get(vin.Txid)
+				if outsBytes==nil{
+					return fmt.Errorf("output %x:%d is not in the UTXO set",vin.Txid,vin.Vout)
+				}
+				outs:=DeserializeOutputs(outsBytes)
+
+				out,ok:=outs.Outputs[vin.Vout]
+				if !ok{
+					return fmt.Errorf("output %x:%d is not in the UTXO set",vin.Txid,vin.Vout)
+				}
+				undo.Spent=append(undo.Spent,SpentOutput{vin.Txid,vin.Vout,out,outs.Height,outs.Coinbase})
+				delete(outs.Outputs,vin.Vout)
+
+				if len(outs.Outputs)==0{
+					err:=b.Delete(vin.Txid)
+					if err!=nil{
+						return err
 					}
-					if len(updateOuts.Outputs)==0{
-						err:=b.Delete(vin.Txid)
-						if err!=nil{
-							log.Panic(err)
-						}
-					}else{
-						err:=b.Put(vin.Txid,updateOuts.Serialize())
-						if err!=nil{
-							log.Panic(err)
-						}
+				}else{
+					err:=b.Put(vin.Txid,outs.Serialize())
+					if err!=nil{
+						return err
 					}
 				}
-
 			}
-			newOutputs:=TXOutputs{}
+		}
 
-			for _,out:=range tx.Vout{
-				newOutputs.Outputs=append(newOutputs.Outputs,out)
-			}
-			err:=b.Put(tx.ID,newOutputs.Serialize())
+		newOutputs:=TXOutputs{make(map[int]TXOutput),block.Height,tx.IsCoinbase()}
+		for outIdx,out:=range tx.Vout{
+			newOutputs.Outputs[outIdx]=out
+		}
+
+		err:=b.Put(tx.ID,newOutputs.Serialize())
+		if err!=nil{
+			return err
+		}
+	}
+
+	return dbTx.Bucket([]byte(undoBucket)).Put(block.Hash,undo.Serialize())
+}
+
+//disconnectBlock undoes connectBlock: the outputs spent by the block are restored
+//from its undo record and the outputs it created are removed
+func disconnectBlock(tx *bolt.Tx,block *Block) error{
+	var spent []SpentOutput
+	b:=tx.Bucket([]byte(utxoBucket))
+	u:=tx.Bucket([]byte(undoBucket))
+
+	if undoData:=u.Get(block.Hash);undoData!=nil{
+		spent=DeserializeBlockUndo(undoData).Spent
+	}else{
+		//the block was connected before undo records were kept
+		var err error
+		spent,err=findSpentOutputs(tx,block)
+		if err!=nil{
+			return err
+		}
+	}
+
+	for i:=len(spent)-1;i>=0;i--{
+		outs:=TXOutputs{make(map[int]TXOutput),spent[i].Height,spent[i].Coinbase}
+		if outsBytes:=b.Get(spent[i].Txid);outsBytes!=nil{
+			outs=DeserializeOutputs(outsBytes)
+		}
+		outs.Outputs[spent[i].Vout]=spent[i].Output
+
+		err:=b.Put(spent[i].Txid,outs.Serialize())
+		if err!=nil{
+			return err
+		}
+	}
+
+	for _,transaction:=range block.Transactions{
+		err:=b.Delete(transaction.ID)
+		if err!=nil{
+			return err
+		}
+	}
+
+	return u.Delete(block.Hash)
+}
+
+//findSpentOutputs rebuilds the undo record of a block from the transactions it spends
+func findSpentOutputs(tx *bolt.Tx,block *Block) ([]SpentOutput,error){
+	var spent []SpentOutput
+
+	for i,transaction:=range block.Transactions{
+		if transaction.IsCoinbase(){
+			continue
+		}
+
+		for _,vin:=range transaction.Vin{
+			prevTx,height,err:=findSpentTransaction(tx,block,i,vin.Txid)
 			if err!=nil{
-				log.Panic(err)
+				return nil,err
 			}
+			spent=append(spent,SpentOutput{vin.Txid,vin.Vout,prevTx.Vout[vin.Vout],height,prevTx.IsCoinbase()})
 		}
-		return nil
-	})
+	}
+	return spent,nil
+}
+
+//findSpentTransaction finds a transaction spent by the i-th transaction of the block
+//and the height of its block, looking at earlier transactions of the same block first
+func findSpentTransaction(tx *bolt.Tx,block *Block,i int,ID []byte) (Transaction,int,error){
+	for _,prevTx:=range block.Transactions[:i]{
+		if bytes.Compare(prevTx.ID,ID)==0{
+			return *prevTx,block.Height,nil
+		}
+	}
+
+	prevBlock,j,err:=locateTransaction(tx,ID)
 	if err!=nil{
-		log.Panic(err)
+		return Transaction{},0,err
 	}
-}
\ No newline at end of file
+	return *prevBlock.Transactions[j],prevBlock.Height,nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import(
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"github.com/boltdb/bolt"
+)
+
+//maxFutureBlockTime is how far in seconds a block timestamp may be ahead of the local clock
+const maxFutureBlockTime=2*60*60
+
+//Errors returned when a block or transaction breaks a consensus rule
+var(
+	ErrProofOfWork=errors.New("block hash does not satisfy proof of work")
+	ErrOrphanBlock=errors.New("previous block is not found")
+	ErrInvalidBlock=errors.New("block or one of its ancestors was invalidated")
+	ErrBadHeight=errors.New("block height does not follow previous block")
+	ErrBadTimestamp=errors.New("block timestamp is out of range")
+	ErrBadDifficulty=errors.New("block target does not follow the retarget rule")
+	ErrNoTransactions=errors.New("block has no transactions")
+	ErrBadCoinbase=errors.New("block must contain exactly one coinbase transaction")
+	ErrCoinbaseAmount=errors.New("coinbase pays more than allowed")
+	ErrBadTransaction=errors.New("transaction is malformed")
+	ErrDuplicateTx=errors.New("transaction appears twice in block")
+	ErrTxIDInUse=errors.New("transaction ID is already in the main chain")
+	ErrMissingInput=errors.New("transaction input refers to an unknown output")
+	ErrDoubleSpend=errors.New("transaction input is already spent")
+	ErrImmatureSpend=errors.New("transaction spends an immature coinbase output")
+	ErrNonFinal=errors.New("transaction locktime has not passed")
+	ErrSequenceLocked=errors.New("transaction input is still locked relative to its output")
+	ErrValueOverflow=errors.New("transaction outputs exceed its inputs or the money range")
+	ErrBadSignature=errors.New("transaction signature is invalid")
+)
+
+//BlockError reports which block was rejected and why
+type BlockError struct{
+	Hash []byte
+	Err error
+}
+
+func (e *BlockError) Error() string{
+	return fmt.Sprintf("block %x rejected: %s",e.Hash,e.Err)
+}
+
+//Unwrap returns the violated rule
+func (e *BlockError) Unwrap() error{
+	return e.Err
+}
+
+//TxError reports which transaction was rejected and why
+type TxError struct{
+	ID []byte
+	Err error
+}
+
+func (e *TxError) Error() string{
+	return fmt.Sprintf("transaction %x rejected: %s",e.ID,e.Err)
+}
+
+//Unwrap returns the violated rule
+func (e *TxError) Unwrap() error{
+	return e.Err
+}
+
+//checkBlock runs the checks that don't depend on the rest of the chain
+func checkBlock(block *Block) error{
+	if len(block.Transactions)==0{
+		return ErrNoTransactions
+	}
+
+	pow:=NewProofOfWork(block)
+	if !pow.Validate(){
+		return ErrProofOfWork
+	}
+
+	coinbases:=0
+	seen:=make(map[string]bool)
+
+	for _,tx:=range block.Transactions{
+		err:=checkTransaction(tx)
+		if err!=nil{
+			return err
+		}
+
+		txID:=hex.EncodeToString(tx.ID)
+		if seen[txID]{
+			return &TxError{tx.ID,ErrDuplicateTx}
+		}
+		seen[txID]=true
+
+		if tx.IsCoinbase(){
+			coinbases++
+		}
+	}
+
+	if coinbases!=1{
+		return ErrBadCoinbase
+	}
+	return nil
+}
+
+//checkTransaction checks that a transaction is well formed
+func checkTransaction(tx *Transaction) error{
+	if len(tx.Vin)==0||len(tx.Vout)==0{
+		return &TxError{tx.ID,ErrBadTransaction}
+	}
+
+	if bytes.Compare(tx.Hash(),tx.ID)!=0{
+		return &TxError{tx.ID,ErrBadTransaction}
+	}
+
+	for _,out:=range tx.Vout{
+		if out.Value<0{
+			return &TxError{tx.ID,ErrBadTransaction}
+		}
+	}
+	_,err:=outputValue(tx)
+	return err
+}
+
+//moneyRange reports whether the amount is between zero and maxMoney
+func moneyRange(value int) bool{
+	return value>=0&&value<=maxMoney
+}
+
+//addMoney returns the sum of two amounts and whether the amounts and the sum are in the money range
+func addMoney(a,b int) (int,bool){
+	if !moneyRange(a)||!moneyRange(b){
+		return 0,false
+	}
+	return a+b,moneyRange(a+b)
+}
+
+//txFee returns what a transaction spending inputValue leaves to the miner.
+//The input and output totals are range checked first, so the fee is never computed from a sum that wrapped around.
+func txFee(tx *Transaction,inputValue int) (int,error){
+	if !moneyRange(inputValue){
+		return 0,&TxError{tx.ID,ErrValueOverflow}
+	}
+	outputs,err:=outputValue(tx)
+	if err!=nil{
+		return 0,err
+	}
+	if outputs>inputValue{
+		return 0,&TxError{tx.ID,ErrValueOverflow}
+	}
+	return inputValue-outputs,nil
+}
+
+//outputValue returns the sum of the transaction outputs.
+//Every output and every partial sum must be in the money range, so the sum can't overflow.
+func outputValue(tx *Transaction) (int,error){
+	value:=0
+	for _,out:=range tx.Vout{
+		if !moneyRange(out.Value){
+			return 0,&TxError{tx.ID,ErrValueOverflow}
+		}
+		value+=out.Value
+		if !moneyRange(value){
+			return 0,&TxError{tx.ID,ErrValueOverflow}
+		}
+	}
+	return value,nil
+}
+
+//checkBlockContext checks a block against its parent
+func checkBlockContext(tx *bolt.Tx,block,parent *Block) error{
+	if block.Height!=parent.Height+1{
+		return ErrBadHeight
+	}
+
+	if block.Timestamp<parent.Timestamp||block.Timestamp>time.Now().Unix()+maxFutureBlockTime{
+		return ErrBadTimestamp
+	}
+
+	bits,err:=nextBits(tx,parent)
+	if err!=nil{
+		return err
+	}
+	if block.Bits!=bits{
+		return ErrBadDifficulty
+	}
+	return nil
+}
+
+//checkTransactions checks that transactions don't reuse the ID of a main chain transaction, spend existing unspent outputs,
+//don't spend any output twice, a coinbase before it matures or an output before
+//its timelocks pass, and carry valid signatures,
+//and that the coinbase pays no more than the subsidy at height plus the fees of the other transactions.
+//The transactions are checked against the current main chain and UTXO set.
+//Outputs created by earlier transactions of the list may be spent by later ones.
+func checkTransactions(dbTx *bolt.Tx,txs []*Transaction,height int) error{
+	utxos:=dbTx.Bucket([]byte(utxoBucket))
+	txIndex:=dbTx.Bucket([]byte(txIndexBucket))
+	spent:=make(map[string]bool)
+	created:=make(map[string]*Transaction)
+	var coinbases []*Transaction
+	fees:=0
+	parentTime:=blockTimeAt(dbTx,height-1)
+
+	for _,tx:=range txs{
+		//a transaction with the ID of one in the main chain would overwrite its unspent outputs and index entry
+		if utxos.Get(tx.ID)!=nil||txIndex.Get(tx.ID)!=nil{
+			return &TxError{tx.ID,ErrTxIDInUse}
+		}
+		if tx.IsCoinbase(){
+			coinbases=append(coinbases,tx)
+			created[hex.EncodeToString(tx.ID)]=tx
+			continue
+		}
+		if !tx.IsFinal(height,parentTime){
+			return &TxError{tx.ID,ErrNonFinal}
+		}
+
+		prevTXs:=make(map[string]Transaction)
+		inputValue:=0
+
+		for _,vin:=range tx.Vin{
+			prevID:=hex.EncodeToString(vin.Txid)
+			outpoint:=fmt.Sprintf("%s:%d",prevID,vin.Vout)
+			if spent[outpoint]{
+				return &TxError{tx.ID,ErrDoubleSpend}
+			}
+			spent[outpoint]=true
+
+			if prevTx,ok:=created[prevID];ok{
+				if vin.Vout<0||vin.Vout>=len(prevTx.Vout){
+					return &TxError{tx.ID,ErrMissingInput}
+				}
+				if prevTx.IsCoinbase(){
+					return &TxError{tx.ID,ErrImmatureSpend}
+				}
+				if sequenceLocked(dbTx,vin.Sequence,height,height,parentTime){
+					return &TxError{tx.ID,ErrSequenceLocked}
+				}
+				var ok bool
+				inputValue,ok=addMoney(inputValue,prevTx.Vout[vin.Vout].Value)
+				if !ok{
+					return &TxError{tx.ID,ErrValueOverflow}
+				}
+				prevTXs[prevID]=*prevTx
+				continue
+			}
+
+			prevTx,err:=findTransaction(dbTx,vin.Txid)
+			if err!=nil{
+				return &TxError{tx.ID,ErrMissingInput}
+			}
+
+			outs,_:=findOutputs(utxos,vin.Txid)
+			out,ok:=outs.Outputs[vin.Vout]
+			if !ok{
+				if vin.Vout<0||vin.Vout>=len(prevTx.Vout){
+					return &TxError{tx.ID,ErrMissingInput}
+				}
+				return &TxError{tx.ID,ErrDoubleSpend}
+			}
+			if !outs.IsMature(height){
+				return &TxError{tx.ID,ErrImmatureSpend}
+			}
+			if sequenceLocked(dbTx,vin.Sequence,outs.Height,height,parentTime){
+				return &TxError{tx.ID,ErrSequenceLocked}
+			}
+			inputValue,ok=addMoney(inputValue,out.Value)
+			if !ok{
+				return &TxError{tx.ID,ErrValueOverflow}
+			}
+			prevTXs[prevID]=prevTx
+		}
+
+		fee,err:=txFee(tx,inputValue)
+		if err!=nil{
+			return err
+		}
+		var ok bool
+		fees,ok=addMoney(fees,fee)
+		if !ok{
+			return &TxError{tx.ID,ErrValueOverflow}
+		}
+
+		if !tx.Verify(prevTXs){
+			return &TxError{tx.ID,ErrBadSignature}
+		}
+
+		created[hex.EncodeToString(tx.ID)]=tx
+	}
+
+	allowed,ok:=addMoney(blockSubsidy(height),fees)
+	for _,coinbase:=range coinbases{
+		value,err:=outputValue(coinbase)
+		if err!=nil{
+			return err
+		}
+		if !ok||value>allowed{
+			return &TxError{coinbase.ID,ErrCoinbaseAmount}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBlockchain(t *testing.T) (*Blockchain, *Wallet) {
+	dir, err := ioutil.TempDir("", "blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := os.Getwd()
+	os.Chdir(dir)
+
+	// Most tests spend the genesis reward in the next block
+	maturity := coinbaseMaturity
+	coinbaseMaturity = 1
+
+	wallet := NewWallet()
+	bc := CreateBlockchain(string(wallet.GetAddress()), "test")
+	UTXOSet{bc}.Reindex()
+
+	t.Cleanup(func() {
+		bc.db.Close()
+		coinbaseMaturity = maturity
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	})
+	return bc, wallet
+}
+
+func TestAddBlockValidation(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	UTXOSet := UTXOSet{bc}
+	minerAddress := string(miner.GetAddress())
+	other := NewWallet()
+
+	tx, _ := NewUTXOTransaction(miner, string(other.GetAddress()), 3, TxOptions{}, &UTXOSet)
+	tip, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), tx})
+	assert.Nil(t, err, "Valid block is accepted")
+
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), tx})
+	assert.True(t, errors.Is(err, ErrTxIDInUse), "Transaction can't be mined twice")
+	genesis, _ := bc.FindTransaction(tx.Vin[0].Txid)
+	double := spend(miner, &genesis, tx.Vin[0].Vout, string(other.GetAddress()), 2, 0)
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), double})
+	assert.True(t, errors.Is(err, ErrDoubleSpend), "Spent output can't be spent again")
+
+	orphan := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)}, []byte("unknown"), 2, tip.Bits)
+	_, err = bc.AddBlock(orphan)
+	assert.True(t, errors.Is(err, ErrOrphanBlock), "Block with unknown parent is rejected")
+
+	wrongHeight := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)}, tip.Hash, 5, tip.Bits)
+	_, err = bc.AddBlock(wrongHeight)
+	assert.True(t, errors.Is(err, ErrBadHeight), "Block with wrong height is rejected")
+
+	tampered := NewBlock([]*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)}, tip.Hash, 2, tip.Bits)
+	tampered.Nonce++
+	_, err = bc.AddBlock(tampered)
+	assert.True(t, errors.Is(err, ErrProofOfWork), "Block with bad proof of work is rejected")
+
+	coinbase := NewCoinbaseTX(minerAddress, "", subsidy)
+	coinbase.Vout[0].Value = subsidy + 1
+	coinbase.ID = coinbase.Hash()
+	greedy := NewBlock([]*Transaction{coinbase}, tip.Hash, 2, tip.Bits)
+	_, err = bc.AddBlock(greedy)
+	assert.True(t, errors.Is(err, ErrCoinbaseAmount), "Coinbase can't exceed the subsidy")
+
+	theft, _ := NewUTXOTransaction(miner, string(other.GetAddress()), 1, TxOptions{}, &UTXOSet)
+	// Signed by other as if the output was locked to its key
+	assert.Nil(t, theft.SignInput(0, other, TXOutput{1, other.LockingScript()}, nil, SigHashAll))
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), theft})
+	assert.True(t, errors.Is(err, ErrBadSignature), "Output can only be spent by its owner")
+
+	assert.Equal(t, tip.Hash, bc.tip, "Rejected blocks don't move the tip")
+}
+
+func TestCoinbaseCollectsFees(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	UTXOSet := UTXOSet{bc}
+	minerAddress := string(miner.GetAddress())
+	other := NewWallet()
+
+	tx, _ := NewUTXOTransaction(miner, string(other.GetAddress()), 3, TxOptions{Fee: 2}, &UTXOSet)
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy+3), tx})
+	assert.True(t, errors.Is(err, ErrCoinbaseAmount), "Coinbase can't exceed the subsidy and the fees")
+
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy+2), tx})
+	assert.Nil(t, err, "Coinbase collects the fees")
+	assert.Equal(t, subsidy+2+subsidy-5, balanceOf(bc, miner))
+	assert.Equal(t, 3, balanceOf(bc, other))
+}
+
+func TestOutputValueOverflow(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	minerAddress := string(miner.GetAddress())
+	genesis := bc.Iterator().Next().Transactions[0]
+
+	// Two outputs of math.MaxInt64 add up to -2, which would pass as a fee of 12
+	tx := Transaction{nil, []TXInput{{genesis.ID, 0, nil, maxSequence}}, []TXOutput{
+		*NewTXOutput(math.MaxInt64, minerAddress),
+		*NewTXOutput(math.MaxInt64, minerAddress),
+	}, 0}
+	tx.ID = tx.Hash()
+	bc.SignTransaction(&tx, miner)
+
+	assert.True(t, errors.Is(checkTransaction(&tx), ErrValueOverflow))
+	assert.True(t, errors.Is(NewMempool(maxMempoolSize, mempoolExpiry).Add(&tx, bc), ErrValueOverflow), "Mempool rejects outputs above the money range")
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy+12), &tx})
+	assert.True(t, errors.Is(err, ErrValueOverflow), "Block with outputs above the money range is rejected")
+	assert.Equal(t, subsidy, balanceOf(bc, miner))
+
+	tx.Vout = []TXOutput{*NewTXOutput(maxMoney, minerAddress), *NewTXOutput(1, minerAddress)}
+	tx.ID = tx.Hash()
+	assert.True(t, errors.Is(checkTransaction(&tx), ErrValueOverflow), "Sum of the outputs must be in the money range")
+}
+
+func TestTransactionIDCantBeReused(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	minerAddress := string(miner.GetAddress())
+	other := NewWallet()
+
+	first := NewCoinbaseTX(minerAddress, "same", subsidy)
+	_, err := bc.MineBlock(context.Background(), []*Transaction{first})
+	assert.Nil(t, err)
+
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "same", subsidy)})
+	assert.True(t, errors.Is(err, ErrTxIDInUse), "Coinbase can't overwrite the unspent outputs of an earlier one")
+	assert.Equal(t, subsidy*2, balanceOf(bc, miner))
+
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), spend(miner, first, 0, string(other.GetAddress()), subsidy, 0)})
+	assert.Nil(t, err)
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "same", subsidy)})
+	assert.True(t, errors.Is(err, ErrTxIDInUse), "Spent transaction keeps its ID in the index")
+	found, err := bc.FindTransaction(first.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, first.Serialize(), found.Serialize())
+	assert.Equal(t, 2, bc.GetBestHeight())
+}
+
+func TestFeesAreRangeChecked(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	tx := Transaction{nil, nil, []TXOutput{*NewTXOutput(3, address), *NewTXOutput(4, address)}, 0}
+
+	fee, err := txFee(&tx, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, fee)
+	_, err = txFee(&tx, 6)
+	assert.True(t, errors.Is(err, ErrValueOverflow), "Outputs can't exceed the inputs")
+	_, err = txFee(&tx, maxMoney+1)
+	assert.True(t, errors.Is(err, ErrValueOverflow), "Inputs must be in the money range")
+
+	tx.Vout = append(tx.Vout, *NewTXOutput(math.MaxInt64, address))
+	_, err = txFee(&tx, 10)
+	assert.True(t, errors.Is(err, ErrValueOverflow), "Fee isn't computed from a wrapped sum")
+
+	sum, ok := addMoney(maxMoney-1, 1)
+	assert.True(t, ok)
+	assert.Equal(t, maxMoney, sum)
+	_, ok = addMoney(maxMoney, 1)
+	assert.False(t, ok, "Sum above the money range overflows")
+	_, ok = addMoney(math.MaxInt64, math.MaxInt64)
+	assert.False(t, ok)
+	_, ok = addMoney(-1, 2)
+	assert.False(t, ok)
+}
+
+func TestBlockSubsidyHalves(t *testing.T) {
+	defer func(interval int) { halvingInterval = interval }(halvingInterval)
+	halvingInterval = 2
+
+	assert.Equal(t, subsidy, blockSubsidy(0))
+	assert.Equal(t, subsidy, blockSubsidy(1))
+	assert.Equal(t, subsidy/2, blockSubsidy(2))
+	assert.Equal(t, subsidy/4, blockSubsidy(5))
+	assert.Equal(t, 0, blockSubsidy(1000))
+
+	bc, miner := newTestBlockchain(t)
+	minerAddress := string(miner.GetAddress())
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)})
+	assert.Nil(t, err)
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy)})
+	assert.True(t, errors.Is(err, ErrCoinbaseAmount), "Reward halves at the halving interval")
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy/2)})
+	assert.Nil(t, err)
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	coinbaseMaturity = 3
+	UTXOSet := UTXOSet{bc}
+	minerAddress := string(miner.GetAddress())
+	genesis := bc.Iterator().Next().Transactions[0]
+	other := NewWallet()
+
+	acc, _ := UTXOSet.FindSpendableOutputs(miner.LockingScript(), 1)
+	assert.Equal(t, 0, acc, "Immature reward isn't spendable")
+	balance, immature := UTXOSet.FindBalance(miner.LockingScript())
+	assert.Equal(t, 0, balance)
+	assert.Equal(t, subsidy, immature)
+
+	early := spend(miner, genesis, 0, string(other.GetAddress()), 5, 0)
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), early})
+	assert.True(t, errors.Is(err, ErrImmatureSpend), "Reward can't be spent before it matures")
+	assert.True(t, errors.Is(NewMempool(maxMempoolSize, mempoolExpiry).Add(early, bc), ErrImmatureSpend), "Mempool rejects immature spends")
+
+	coinbase := NewCoinbaseTX(minerAddress, "", subsidy)
+	sameBlock := spend(miner, coinbase, 0, string(other.GetAddress()), 5, 0)
+	_, err = bc.MineBlock(context.Background(), []*Transaction{coinbase, sameBlock})
+	assert.True(t, errors.Is(err, ErrImmatureSpend), "Reward can't be spent in its own block")
+
+	mineCoinbase(t, bc, miner)
+	mineCoinbase(t, bc, miner)
+	balance, immature = UTXOSet.FindBalance(miner.LockingScript())
+	assert.Equal(t, subsidy, balance, "Genesis reward matures after 3 blocks")
+	assert.Equal(t, 2*subsidy, immature)
+
+	_, err = bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(minerAddress, "", subsidy), early})
+	assert.Nil(t, err, "Mature reward can be spent")
+	assert.Equal(t, 5, balanceOf(bc, other))
+}
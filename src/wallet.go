@@ -6,13 +6,25 @@ import(
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
+	"math/big"
 	"log"
 	"golang.org/x/crypto/ripemd160"
 	"fmt"
 )
 
 const walletversion=byte(0x00)
+//scriptHashVersion is the version byte of pay-to-script-hash addresses
+const scriptHashVersion=byte(0x05)
 const addressChecksumLen=4
+//privateKeyVersion is the version byte of exported private keys
+const privateKeyVersion=byte(0x80)
+
+//Flags after the key of an exported private key, for the encoding of the public key the address hashes
+const(
+	privateKeyLegacy=byte(0x00)
+	privateKeyCompressed=byte(0x01)
+)
 
 type Wallet struct{
 	PrivateKey ecdsa.PrivateKey
@@ -39,6 +51,18 @@ func (w Wallet) GetAddress() []byte{
 	return address
 }
 
+//LockingScript returns the pay-to-pubkey-hash script of the wallet's address
+func (w Wallet) LockingScript() []byte{
+	return NewP2PKHScript(HashPubKey(w.PublicKey))
+}
+
+//encodeAddress returns the address of a public key or script hash with the version
+func encodeAddress(version byte,hash []byte) string{
+	versionedPayload:=append([]byte{version},hash...)
+
+	return string(Base58Encode(append(versionedPayload,checksum(versionedPayload)...)))
+}
+
 func HashPubKey(pubKey []byte) []byte{
 	publicSHA256:=sha256.Sum256(pubKey)
 
@@ -55,17 +79,94 @@ func HashPubKey(pubKey []byte) []byte{
 }
 
 func ValidateAddress(address string) bool{
+	return AddressToScript(address)!=nil
+}
+
+//AddressToPubKeyHash extracts the public key hash from an address
+func AddressToPubKeyHash(address string) []byte{
 	pubKeyHash:=Base58Decode([]byte(address))
-	//fmt.Printf("%x\n",pubKeyHash)
-	actualChecksum:=pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	//fmt.Printf("actual=%x\n",actualChecksum)
-	//version:=pubKeyHash[0]
-	pubKeyHash=pubKeyHash[1:len(pubKeyHash)-addressChecksumLen]
-	//fmt.Printf("pubKeyHash=%x\n",pubKeyHash)
-	targetChecksum:=checksum(append([]byte{walletversion},pubKeyHash...))
-	//fmt.Printf("target=%x\n",targetChecksum)
 
-	return bytes.Compare(actualChecksum,targetChecksum)==0
+	return pubKeyHash[1:len(pubKeyHash)-addressChecksumLen]
+}
+
+//AddressToScript returns the locking script of an address, or nil if the address isn't valid.
+//Addresses with walletversion pay to a public key hash and those with scriptHashVersion to a script hash.
+func AddressToScript(address string) []byte{
+	payload:=Base58Decode([]byte(address))
+	if len(payload)!=1+20+addressChecksumLen{
+		return nil
+	}
+
+	versionedPayload:=payload[:len(payload)-addressChecksumLen]
+	if !bytes.Equal(payload[len(versionedPayload):],checksum(versionedPayload)){
+		return nil
+	}
+
+	switch versionedPayload[0]{
+	case walletversion:
+		return NewP2PKHScript(versionedPayload[1:])
+	case scriptHashVersion:
+		return NewP2SHScript(versionedPayload[1:])
+	}
+	return nil
+}
+
+//ScriptToAddress returns the address of a pay-to-pubkey-hash or pay-to-script-hash script
+func ScriptToAddress(script []byte) (string,bool){
+	if hash:=extractPubKeyHash(script);hash!=nil{
+		return encodeAddress(walletversion,hash),true
+	}
+	if hash:=extractScriptHash(script);hash!=nil{
+		return encodeAddress(scriptHashVersion,hash),true
+	}
+	return "",false
+}
+
+//EncodePrivateKey exports the private key of the wallet in Base58Check with privateKeyVersion,
+//like WIF. The flag after the key tells whether the address is of the compressed public key
+//or of X followed by Y, which wallets created before compressed keys use.
+func EncodePrivateKey(w *Wallet) string{
+	flag:=privateKeyCompressed
+	if len(w.PublicKey)!=33{
+		flag=privateKeyLegacy
+	}
+
+	payload:=append([]byte{privateKeyVersion},newWalletKey(w).PrivateKey...)
+	payload=append(payload,flag)
+
+	return string(Base58Encode(append(payload,checksum(payload)...)))
+}
+
+//DecodePrivateKey imports a private key exported by EncodePrivateKey
+func DecodePrivateKey(key string) (*Wallet,error){
+	payload:=Base58Decode([]byte(key))
+	if len(payload)!=1+32+1+addressChecksumLen{
+		return nil,errors.New("private key is invalid")
+	}
+
+	versionedPayload:=payload[:len(payload)-addressChecksumLen]
+	if !bytes.Equal(payload[len(versionedPayload):],checksum(versionedPayload)){
+		return nil,errors.New("private key checksum doesn't match")
+	}
+	if versionedPayload[0]!=privateKeyVersion{
+		return nil,errors.New("private key version is invalid")
+	}
+
+	privKey:=versionedPayload[1:33]
+	if !validScalar(privKey){
+		return nil,errors.New("private key is out of range")
+	}
+	wallet:=newWalletFromKey(privKey)
+
+	switch versionedPayload[33]{
+	case privateKeyCompressed:
+	case privateKeyLegacy:
+		wallet.PublicKey=append(wallet.PrivateKey.X.Bytes(),wallet.PrivateKey.Y.Bytes()...)
+	default:
+		return nil,errors.New("private key flag is invalid")
+	}
+
+	return wallet,nil
 }
 
 func checksum(payload []byte) []byte{
@@ -75,13 +176,54 @@ func checksum(payload []byte) []byte{
 	return secondSHA[:addressChecksumLen]
 }
 
+//newKeyPair generates a P-256 key pair. The public key is in compressed SEC1 form.
 func newKeyPair() (ecdsa.PrivateKey,[]byte){
 	curve:=elliptic.P256()
 	private,err:=ecdsa.GenerateKey(curve,rand.Reader)
 	if err!=nil{
 		log.Panic(err)
 	}
-	pubKey:=append(private.PublicKey.X.Bytes(),private.PublicKey.Y.Bytes()...)
+	pubKey:=elliptic.MarshalCompressed(curve,private.PublicKey.X,private.PublicKey.Y)
 
 	return *private,pubKey
-}
\ No newline at end of file
+}
+
+//newWalletFromKey returns the key pair of a P-256 private key, the public key is compressed
+func newWalletFromKey(privKey []byte) *Wallet{
+	curve:=elliptic.P256()
+	private:=ecdsa.PrivateKey{D:new(big.Int).SetBytes(privKey)}
+	private.PublicKey.Curve=curve
+	private.PublicKey.X,private.PublicKey.Y=curve.ScalarBaseMult(privKey)
+
+	return &Wallet{private,elliptic.MarshalCompressed(curve,private.PublicKey.X,private.PublicKey.Y)}
+}
+
+//ParsePublicKey decodes a P-256 public key in compressed or uncompressed SEC1 form.
+//Wallets created before compressed keys used X followed by Y without padding, which is accepted
+//as well. A short coordinate makes the split ambiguous, so each split is tried against the curve.
+func ParsePublicKey(data []byte) (*ecdsa.PublicKey,error){
+	curve:=elliptic.P256()
+	size:=(curve.Params().BitSize+7)/8
+
+	var x,y *big.Int
+	switch{
+	case len(data)==size+1:
+		x,y=elliptic.UnmarshalCompressed(curve,data)
+	case len(data)==2*size+1:
+		x,y=elliptic.Unmarshal(curve,data)
+	case len(data)>size+1&&len(data)<=2*size:
+		for split:=len(data)-size;split<=size;split++{
+			x=new(big.Int).SetBytes(data[:split])
+			y=new(big.Int).SetBytes(data[split:])
+			if curve.IsOnCurve(x,y){
+				break
+			}
+			x,y=nil,nil
+		}
+	}
+	if x==nil{
+		return nil,errors.New("public key is invalid")
+	}
+
+	return &ecdsa.PublicKey{Curve:curve,X:x,Y:y},nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import(
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+)
+
+//An encrypted wallet file keeps the public keys, so addresses and balances can be listed,
+//and seals the private keys and the seed with AES-256-GCM. The AES key is derived from
+//the passphrase with scrypt and is kept in memory while the wallets are unlocked.
+
+//scrypt cost parameters of newly encrypted wallets, about 100ms and 32MB
+const(
+	walletScryptN=1<<15
+	walletScryptR=8
+	walletScryptP=1
+	walletKeySize=32
+)
+
+var(
+	ErrWalletLocked=errors.New("wallet is locked")
+	ErrWalletNotEncrypted=errors.New("wallet is not encrypted")
+	ErrWrongPassphrase=errors.New("wallet passphrase is wrong")
+)
+
+//walletCrypto is the sealed part of an encrypted wallet file
+type walletCrypto struct{
+	Salt []byte
+	N,R,P int
+	Nonce []byte
+	Secrets []byte
+}
+
+//walletSecrets is what walletCrypto seals
+type walletSecrets struct{
+	Keys []walletKey
+	Seed []byte
+}
+
+//IsEncrypted reports whether the wallet file is encrypted
+func (ws *Wallets) IsEncrypted() bool{
+	return ws.crypto!=nil
+}
+
+//IsLocked reports whether the wallets are encrypted and the private keys aren't available
+func (ws *Wallets) IsLocked() bool{
+	return ws.crypto!=nil&&ws.key==nil
+}
+
+//Encrypt encrypts the private keys and the seed with the passphrase, the wallets stay unlocked
+func (ws *Wallets) Encrypt(passphrase string) error{
+	if ws.IsEncrypted(){
+		return errors.New("wallet is already encrypted")
+	}
+	if passphrase==""{
+		return errors.New("passphrase is empty")
+	}
+
+	salt:=make([]byte,16)
+	_,err:=rand.Read(salt)
+	if err!=nil{
+		return err
+	}
+
+	crypto:=&walletCrypto{Salt:salt,N:walletScryptN,R:walletScryptR,P:walletScryptP}
+	key,err:=crypto.deriveKey(passphrase)
+	if err!=nil{
+		return err
+	}
+
+	ws.crypto=crypto
+	ws.key=key
+	return ws.seal()
+}
+
+//Unlock decrypts the private keys and the seed with the passphrase
+func (ws *Wallets) Unlock(passphrase string) error{
+	if !ws.IsEncrypted(){
+		return ErrWalletNotEncrypted
+	}
+
+	key,err:=ws.crypto.deriveKey(passphrase)
+	if err!=nil{
+		return err
+	}
+	return ws.unlockWithKey(key)
+}
+
+//Lock drops the private keys and the seed from memory
+func (ws *Wallets) Lock(){
+	if !ws.IsEncrypted(){
+		return
+	}
+
+	for address,wallet:=range ws.Wallets{
+		ws.Wallets[address]=&Wallet{ecdsa.PrivateKey{PublicKey:wallet.PrivateKey.PublicKey},wallet.PublicKey}
+	}
+	ws.Seed=nil
+	for i:=range ws.key{
+		ws.key[i]=0
+	}
+	ws.key=nil
+}
+
+//unlockWithKey decrypts the private keys and the seed with a key derived from the passphrase
+func (ws *Wallets) unlockWithKey(key []byte) error{
+	gcm,err:=newWalletCipher(key)
+	if err!=nil{
+		return err
+	}
+	plaintext,err:=gcm.Open(nil,ws.crypto.Nonce,ws.crypto.Secrets,nil)
+	if err!=nil{
+		return ErrWrongPassphrase
+	}
+
+	var secrets walletSecrets
+	err=gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&secrets)
+	if err!=nil{
+		return fmt.Errorf("wallet secrets are not readable: %w",err)
+	}
+
+	for _,walletKey:=range secrets.Keys{
+		wallet,err:=walletKey.wallet()
+		if err!=nil{
+			return err
+		}
+		ws.Wallets[string(wallet.GetAddress())]=wallet
+	}
+	ws.Seed=secrets.Seed
+	ws.key=append([]byte{},key...)
+
+	return nil
+}
+
+//seal encrypts the private keys and the seed of unlocked wallets with a new nonce
+func (ws *Wallets) seal() error{
+	var secrets walletSecrets
+	for _,wallet:=range ws.Wallets{
+		if wallet.PrivateKey.D!=nil{
+			secrets.Keys=append(secrets.Keys,newWalletKey(wallet))
+		}
+	}
+	secrets.Seed=ws.Seed
+
+	var plaintext bytes.Buffer
+	err:=gob.NewEncoder(&plaintext).Encode(secrets)
+	if err!=nil{
+		return err
+	}
+
+	gcm,err:=newWalletCipher(ws.key)
+	if err!=nil{
+		return err
+	}
+	nonce:=make([]byte,gcm.NonceSize())
+	_,err=rand.Read(nonce)
+	if err!=nil{
+		return err
+	}
+
+	ws.crypto.Nonce=nonce
+	ws.crypto.Secrets=gcm.Seal(nil,nonce,plaintext.Bytes(),nil)
+	return nil
+}
+
+//deriveKey derives the AES key from the passphrase with the scrypt parameters of the file
+func (c *walletCrypto) deriveKey(passphrase string) ([]byte,error){
+	return scrypt.Key([]byte(passphrase),c.Salt,c.N,c.R,c.P,walletKeySize)
+}
+
+func newWalletCipher(key []byte) (cipher.AEAD,error){
+	block,err:=aes.NewCipher(key)
+	if err!=nil{
+		return nil,err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newEncryptedWallets saves an encrypted wallet file with a seed and a random key and returns the unlocked wallets
+func newEncryptedWallets(t *testing.T, passphrase string) *Wallets {
+	inTempDir(t)
+	wallets := &Wallets{Wallets: map[string]*Wallet{}}
+	wallets.CreateWallet()
+	wallets.SetSeed([]byte("encryption test seed"))
+	newAddress(t, wallets)
+	assert.Nil(t, wallets.Encrypt(passphrase))
+	wallets.SaveToFile("test")
+	return wallets
+}
+
+func TestEncryptedWalletFile(t *testing.T) {
+	wallets := newEncryptedWallets(t, "secret")
+	assert.False(t, wallets.IsLocked(), "Wallets stay unlocked after encryption")
+	assert.NotNil(t, wallets.Encrypt("other"), "Wallet is only encrypted once")
+
+	fileContent, _ := ioutil.ReadFile(fmt.Sprintf(walletFile, "test"))
+	for _, wallet := range wallets.Wallets {
+		assert.False(t, bytes.Contains(fileContent, newWalletKey(wallet).PrivateKey), "Private keys are encrypted")
+		assert.True(t, bytes.Contains(fileContent, wallet.PublicKey), "Public keys are not")
+	}
+	assert.False(t, bytes.Contains(fileContent, wallets.Seed), "Seed is encrypted")
+
+	locked, err := NewWallets("test")
+	assert.Nil(t, err)
+	assert.True(t, locked.IsLocked())
+	assert.ElementsMatch(t, wallets.GetAddresses(), locked.GetAddresses(), "Addresses are listed while locked")
+	assert.Nil(t, locked.Seed)
+	_, err = locked.CreateWallet()
+	assert.True(t, errors.Is(err, ErrWalletLocked))
+
+	w := locked.Wallets[locked.GetAddresses()[0]]
+	prev := fundingTx(w)
+	tx := spendOutput(prev)
+	assert.True(t, errors.Is(tx.SignInput(0, w, prev.Vout[0], nil, SigHashAll), ErrWalletLocked), "Locked wallet can't sign")
+	_, err = NewUTXOTransaction(w, string(w.GetAddress()), 1, TxOptions{}, nil)
+	assert.True(t, errors.Is(err, ErrWalletLocked))
+
+	assert.True(t, errors.Is(locked.Unlock("wrong"), ErrWrongPassphrase))
+	assert.True(t, locked.IsLocked())
+	assert.Nil(t, locked.Unlock("secret"))
+	assert.Equal(t, wallets.Wallets, locked.Wallets)
+	assert.Equal(t, wallets.Seed, locked.Seed)
+
+	address := newAddress(t, locked)
+	locked.SaveToFile("test")
+	locked.Lock()
+	assert.Nil(t, locked.Wallets[address].PrivateKey.D, "Lock drops the private keys")
+
+	reloaded, _ := NewWallets("test")
+	assert.Nil(t, reloaded.Unlock("secret"))
+	assert.Equal(t, wallets.Seed, reloaded.Seed)
+	assert.True(t, verifiesSpend(reloaded.Wallets[address]), "Keys added while unlocked are saved encrypted")
+}
+
+func TestWalletPassphraseUnlocksNodeForTimeout(t *testing.T) {
+	newEncryptedWallets(t, "secret")
+	s := &RPCServer{nodeID: "test"}
+	param := func(v interface{}) json.RawMessage {
+		data, _ := json.Marshal(v)
+		return data
+	}
+
+	wallets, _ := s.openWallets()
+	assert.True(t, wallets.IsLocked())
+
+	_, err := s.walletPassphrase([]json.RawMessage{param("wrong"), param(60)})
+	assert.Equal(t, rpcWalletWrongPassphrase, err.(*RPCError).Code)
+	_, err = s.walletPassphrase([]json.RawMessage{param("secret"), param(60)})
+	assert.Nil(t, err)
+	wallets, _ = s.openWallets()
+	assert.False(t, wallets.IsLocked())
+
+	_, err = s.walletLock(nil)
+	assert.Nil(t, err)
+	wallets, _ = s.openWallets()
+	assert.True(t, wallets.IsLocked(), "walletlock locks before the timeout")
+
+	_, err = s.walletPassphrase([]json.RawMessage{param("secret"), param(1)})
+	assert.Nil(t, err)
+	time.Sleep(1200 * time.Millisecond)
+	wallets, _ = s.openWallets()
+	assert.True(t, wallets.IsLocked(), "Wallet locks itself after the timeout")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// inTempDir runs the test in an empty directory
+func inTempDir(t *testing.T) {
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+// verifiesSpend reports whether a transaction signed by the wallet verifies
+func verifiesSpend(w *Wallet) bool {
+	prev := fundingTx(w)
+	tx := spend(w, &prev, 0, string(w.GetAddress()), 5, 1)
+	return tx.Verify(map[string]Transaction{hex.EncodeToString(prev.ID): prev})
+}
+
+func TestEveryKeySignsVerifiableTransactions(t *testing.T) {
+	for i := 0; i < 300; i++ {
+		w := NewWallet()
+		assert.Len(t, w.PublicKey, 33, "Public keys are compressed")
+		if !assert.True(t, verifiesSpend(w)) {
+			t.Fatalf("signature of key %x doesn't verify", w.PublicKey)
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	curve := elliptic.P256()
+	w := NewWallet()
+	x, y := w.PrivateKey.PublicKey.X, w.PrivateKey.PublicKey.Y
+
+	for _, encoded := range [][]byte{
+		w.PublicKey,
+		elliptic.Marshal(curve, x, y),
+		append(x.FillBytes(make([]byte, 32)), y.FillBytes(make([]byte, 32))...),
+	} {
+		pubKey, err := ParsePublicKey(encoded)
+		assert.Nil(t, err)
+		assert.Equal(t, x, pubKey.X)
+		assert.Equal(t, y, pubKey.Y)
+	}
+
+	_, err := ParsePublicKey([]byte{0x02, 0x03})
+	assert.NotNil(t, err)
+	_, err = ParsePublicKey(append([]byte{0x04}, make([]byte, 64)...))
+	assert.NotNil(t, err, "Point must be on the curve")
+}
+
+func TestLegacyKeyWithShortCoordinate(t *testing.T) {
+	w := NewWallet()
+	for len(w.PrivateKey.X.Bytes()) == 32 && len(w.PrivateKey.Y.Bytes()) == 32 {
+		w = NewWallet()
+	}
+	w.PublicKey = append(w.PrivateKey.X.Bytes(), w.PrivateKey.Y.Bytes()...)
+
+	pubKey, err := ParsePublicKey(w.PublicKey)
+	assert.Nil(t, err)
+	assert.Equal(t, w.PrivateKey.X, pubKey.X)
+	assert.True(t, verifiesSpend(w), "Funds sent to an unpadded key can be spent")
+}
+
+func TestWalletFileRoundTrip(t *testing.T) {
+	inTempDir(t)
+
+	wallets, err := NewWallets("test")
+	assert.True(t, os.IsNotExist(err))
+	for i := 0; i < 3; i++ {
+		wallets.CreateWallet()
+	}
+	wallets.SaveToFile("test")
+
+	loaded, err := NewWallets("test")
+	assert.Nil(t, err)
+	assert.Equal(t, wallets.Wallets, loaded.Wallets)
+
+	loaded.SetSeed([]byte("seed"))
+	loaded.CreateWallet()
+	loaded.SaveToFile("test")
+	reloaded, err := NewWallets("test")
+	assert.Nil(t, err)
+	assert.Equal(t, loaded, reloaded, "Seed and derived addresses are saved")
+}
+
+func TestLegacyWalletFileIsConverted(t *testing.T) {
+	legacy, err := ioutil.ReadFile("testdata/wallet_legacy.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inTempDir(t)
+	ioutil.WriteFile("wallet_legacy.dat", legacy, 0600)
+
+	wallets, err := NewWallets("legacy")
+	assert.Nil(t, err)
+	addresses := wallets.GetAddresses()
+	assert.NotEmpty(t, addresses)
+	for _, address := range addresses {
+		w := wallets.GetWallet(address)
+		assert.Equal(t, address, string(w.GetAddress()), "Addresses don't change")
+		assert.True(t, verifiesSpend(&w))
+	}
+
+	backup, _ := ioutil.ReadFile("wallet_legacy.dat.bak")
+	assert.Equal(t, legacy, backup, "Old file is kept")
+
+	converted, err := NewWallets("legacy")
+	assert.Nil(t, err)
+	convertedAddresses := converted.GetAddresses()
+	sort.Strings(addresses)
+	sort.Strings(convertedAddresses)
+	assert.Equal(t, addresses, convertedAddresses, "File is saved in the new format")
+}
+
+func TestPrivateKeyExport(t *testing.T) {
+	legacy := NewWallet()
+	legacy.PublicKey = append(legacy.PrivateKey.X.Bytes(), legacy.PrivateKey.Y.Bytes()...)
+
+	for _, w := range []*Wallet{NewWallet(), legacy} {
+		key := EncodePrivateKey(w)
+		imported, err := DecodePrivateKey(key)
+		assert.Nil(t, err)
+		assert.Equal(t, w.GetAddress(), imported.GetAddress(), "Address is kept")
+		assert.Equal(t, w.PrivateKey.D, imported.PrivateKey.D)
+	}
+
+	key := EncodePrivateKey(NewWallet())
+	last := "1"
+	if strings.HasSuffix(key, last) {
+		last = "2"
+	}
+	_, err := DecodePrivateKey(key[:len(key)-1] + last)
+	assert.NotNil(t, err, "Checksum is verified")
+	_, err = DecodePrivateKey(string(NewWallet().GetAddress()))
+	assert.NotNil(t, err, "Address isn't a private key")
+}
+
+func TestImportPrivateKey(t *testing.T) {
+	source := &Wallets{Wallets: map[string]*Wallet{}}
+	address := newAddress(t, source)
+	key, err := source.DumpPrivateKey(address)
+	assert.Nil(t, err)
+
+	wallets := &Wallets{Wallets: map[string]*Wallet{}}
+	wallets.ImportAddress(address)
+	_, err = wallets.DumpPrivateKey(address)
+	assert.True(t, errors.Is(err, ErrWatchOnly), "Watch-only address has no key to dump")
+
+	imported, err := wallets.ImportPrivateKey(key)
+	assert.Nil(t, err)
+	assert.Equal(t, address, imported)
+	assert.False(t, wallets.IsWatchOnly(address), "Imported key makes the address spendable")
+	assert.True(t, verifiesSpend(wallets.Wallets[address]))
+
+	assert.Nil(t, wallets.Encrypt("secret"))
+	wallets.Lock()
+	_, err = wallets.ImportPrivateKey(EncodePrivateKey(NewWallet()))
+	assert.True(t, errors.Is(err, ErrWalletLocked))
+	_, err = wallets.DumpPrivateKey(address)
+	assert.True(t, errors.Is(err, ErrWalletLocked))
+}
@@ -2,8 +2,12 @@ package main
 
 import(
 	"bytes"
+	"crypto/ecdsa"
 	"crypto/elliptic"
+	"errors"
+	"math/big"
 	"encoding/gob"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -12,35 +16,206 @@ import(
 
 const walletFile="wallet_%s.dat"
 
+//walletFileVersion is the version of the wallet file format.
+//Version 2 added the seed, version 3 encryption and version 4 watch-only addresses,
+//older files are read as wallets without them.
+const walletFileVersion=4
+
+//walletKey is a key pair as it is saved in the wallet file, the curve is always P-256
+type walletKey struct{
+	PrivateKey []byte
+	PublicKey []byte
+}
+
+type walletFileContent struct{
+	Version int
+	Keys []walletKey
+	Seed []byte
+	HDIndex uint32
+	Crypto *walletCrypto
+	WatchOnly []watchOnlyEntry
+}
+
+//watchOnlyEntry is a watch-only address as it is saved, with its public key if it was imported
+type watchOnlyEntry struct{
+	Address string
+	PublicKey []byte
+}
+
+//legacyWallets mirrors Wallets as it was saved before walletFileVersion,
+//without the Curve fields of the keys
+type legacyWallets struct{
+	Wallets map[string]*legacyWallet
+}
+
+type legacyWallet struct{
+	PrivateKey legacyPrivateKey
+	PublicKey []byte
+}
+
+type legacyPrivateKey struct{
+	PublicKey struct{
+		X,Y *big.Int
+	}
+	D *big.Int
+}
+
+//Wallets holds the key pairs by address. Keys derived from Seed are included, HDIndex is the
+//number of addresses derived so far. While an encrypted wallet is locked, the wallets have
+//no private keys and Seed is nil. WatchOnly maps the addresses that are tracked without a key
+//to their public key, which is nil if only the address was imported.
 type Wallets struct{
 	Wallets map[string]*Wallet
+	Seed []byte
+	HDIndex uint32
+	WatchOnly map[string][]byte
+
+	crypto *walletCrypto
+	key []byte
 }
 
 //NewWallets creates Wallets and fills it from a file if it exists
 func NewWallets(nodeID string) (*Wallets,error){
 	wallets:=Wallets{}
 	wallets.Wallets=make(map[string]*Wallet)
+	wallets.WatchOnly=make(map[string][]byte)
 
 	err:=wallets.LoadFromFile(nodeID)
 
 	return &wallets,err
 }
 
-//CreateWallet adds a wallet to Wallets
-func (ws *Wallets) CreateWallet() string{
-	wallet:=NewWallet()
+//CreateWallet adds a wallet to Wallets, derived from the seed if there is one and random otherwise
+func (ws *Wallets) CreateWallet() (string,error){
+	if ws.IsLocked(){
+		return "",ErrWalletLocked
+	}
+
+	var wallet *Wallet
+	if ws.Seed!=nil{
+		wallet=ws.deriveWallet(ws.HDIndex)
+		ws.HDIndex++
+	}else{
+		wallet=NewWallet()
+	}
+
+	return ws.addWallet(wallet),nil
+}
+
+//addWallet adds a key pair and stops watching its address, which is spendable now
+func (ws *Wallets) addWallet(wallet *Wallet) string{
 	address:=fmt.Sprintf("%s",wallet.GetAddress())
 
 	ws.Wallets[address]=wallet
+	delete(ws.WatchOnly,address)
 
 	return address
 }
 
+//SetSeed makes CreateWallet derive keys from the seed. Wallets keep their first seed.
+func (ws *Wallets) SetSeed(seed []byte) error{
+	if ws.IsLocked(){
+		return ErrWalletLocked
+	}
+	if ws.Seed!=nil{
+		return errors.New("wallet already has a seed")
+	}
+	ws.Seed=seed
+	ws.HDIndex=0
+
+	return nil
+}
+
+//ScanAddresses derives the addresses of the seed until gapLimit of them in a row aren't used
+//and adds those up to the last used one. It returns the used addresses.
+func (ws *Wallets) ScanAddresses(used func(script []byte) bool,gapLimit int) []string{
+	var found []string
+	if ws.Seed==nil{
+		return found
+	}
+
+	account:=NewMasterKey(ws.Seed).Derive(hdAccountPath)
+	for index,gap:=uint32(0),0;gap<gapLimit;index++{
+		wallet:=account.Child(index).Wallet()
+		if !used(wallet.LockingScript()){
+			gap++
+			continue
+		}
+
+		gap=0
+		found=append(found,string(wallet.GetAddress()))
+		for ;ws.HDIndex<=index;ws.HDIndex++{
+			ws.addWallet(account.Child(ws.HDIndex).Wallet())
+		}
+	}
+
+	return found
+}
+
+//deriveWallet returns the key pair of the seed at index of hdAccountPath
+func (ws *Wallets) deriveWallet(index uint32) *Wallet{
+	return NewMasterKey(ws.Seed).Derive(hdAccountPath).Child(index).Wallet()
+}
+
+//ImportPrivateKey adds the key pair of a private key exported by DumpPrivateKey and returns its address
+func (ws *Wallets) ImportPrivateKey(key string) (string,error){
+	if ws.IsLocked(){
+		return "",ErrWalletLocked
+	}
+
+	wallet,err:=DecodePrivateKey(key)
+	if err!=nil{
+		return "",err
+	}
+
+	return ws.addWallet(wallet),nil
+}
+
+//DumpPrivateKey exports the private key of an address of the wallet
+func (ws *Wallets) DumpPrivateKey(address string) (string,error){
+	wallet,err:=ws.SigningWallet(address)
+	if err!=nil{
+		return "",err
+	}
+
+	return EncodePrivateKey(wallet),nil
+}
+
 //GetWallet returns a wallet by its address
 func (ws Wallets) GetWallet(address string) Wallet{
 	return *ws.Wallets[address]
 }
 
+//MultiSigAddress returns the pay-to-script-hash address and the redeem script of a multisig
+//of the required number of keys. Keys are addresses of the wallets, watch-only addresses with an
+//imported public key or public keys in hex.
+func (ws *Wallets) MultiSigAddress(required int,keys []string) (string,[]byte,error){
+	var pubKeys [][]byte
+	for _,key:=range keys{
+		if wallet,ok:=ws.Wallets[key];ok{
+			pubKeys=append(pubKeys,wallet.PublicKey)
+			continue
+		}
+		if pubKey:=ws.WatchOnly[key];pubKey!=nil{
+			pubKeys=append(pubKeys,pubKey)
+			continue
+		}
+
+		pubKey,err:=hex.DecodeString(key)
+		if err!=nil{
+			return "",nil,fmt.Errorf("%s is neither an address of the wallet nor a public key",key)
+		}
+		pubKeys=append(pubKeys,pubKey)
+	}
+
+	redeemScript,err:=NewMultiSigScript(required,pubKeys)
+	if err!=nil{
+		return "",nil,err
+	}
+
+	return encodeAddress(scriptHashVersion,HashPubKey(redeemScript)),redeemScript,nil
+}
+
 //GetAddresses returns an array of addresses stored in the wallet file
 func (ws *Wallets) GetAddresses() []string{
 	var addresses []string
@@ -52,7 +227,9 @@ func (ws *Wallets) GetAddresses() []string{
 	return addresses
 }
 
-//LoadFromFile loads wallets from the file
+//LoadFromFile loads wallets from the file.
+//Files written before the versioned format are converted and saved again, the original is kept with a .bak suffix.
+//The wallets of an encrypted file are locked.
 func (ws *Wallets) LoadFromFile(nodeID string)error{
 	walletFile:=fmt.Sprintf(walletFile,nodeID)
 
@@ -65,15 +242,45 @@ func (ws *Wallets) LoadFromFile(nodeID string)error{
 		log.Panic(err)
 	}
 
-	var wallets Wallets
-	gob.Register(elliptic.P256())
-	decoder:=gob.NewDecoder(bytes.NewReader(fileContent))
-	err=decoder.Decode(&wallets)
+	var content walletFileContent
+	err=gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&content)
+	if err==nil&&content.Version>=1&&content.Version<=walletFileVersion{
+		ws.Seed=content.Seed
+		ws.HDIndex=content.HDIndex
+		ws.crypto=content.Crypto
+		for _,entry:=range content.WatchOnly{
+			ws.WatchOnly[entry.Address]=entry.PublicKey
+		}
+		for _,key:=range content.Keys{
+			var wallet *Wallet
+			if ws.crypto!=nil{
+				wallet,err=publicWallet(key.PublicKey)
+			}else{
+				wallet,err=key.wallet()
+			}
+			if err!=nil{
+				return err
+			}
+			ws.Wallets[fmt.Sprintf("%s",wallet.GetAddress())]=wallet
+		}
+		return nil
+	}
+	if err==nil{
+		return fmt.Errorf("wallet file version %d is not supported",content.Version)
+	}
+
+	wallets,err:=loadLegacyWallets(fileContent)
 	if err!=nil{
-		log.Panic(err)
+		return err
 	}
+	ws.Wallets=wallets
 
-	ws.Wallets=wallets.Wallets
+	err=ioutil.WriteFile(walletFile+".bak",fileContent,0600)
+	if err!=nil{
+		return err
+	}
+	ws.SaveToFile(nodeID)
+	fmt.Printf("Wallet file converted to version %d, the old file is kept in %s.bak\n",walletFileVersion,walletFile)
 
 	return nil
 }
@@ -83,16 +290,102 @@ func (ws Wallets) SaveToFile(nodeID string){
 	var content bytes.Buffer
 	walletFile:=fmt.Sprintf(walletFile,nodeID)
 
-	gob.Register(elliptic.P256())
+	fileContent:=walletFileContent{Version:walletFileVersion,Seed:ws.Seed,HDIndex:ws.HDIndex}
+	for _,address:=range ws.GetAddresses(){
+		fileContent.Keys=append(fileContent.Keys,newWalletKey(ws.Wallets[address]))
+	}
+	for _,address:=range ws.WatchOnlyAddresses(){
+		fileContent.WatchOnly=append(fileContent.WatchOnly,watchOnlyEntry{address,ws.WatchOnly[address]})
+	}
+
+	//Only the public keys of an encrypted wallet are saved in plain text
+	if ws.IsEncrypted(){
+		if !ws.IsLocked(){
+			err:=ws.seal()
+			if err!=nil{
+				log.Panic(err)
+			}
+		}
+		for i:=range fileContent.Keys{
+			fileContent.Keys[i].PrivateKey=nil
+		}
+		fileContent.Seed=nil
+		fileContent.Crypto=ws.crypto
+	}
 
 	encoder:=gob.NewEncoder(&content)
-	err:=encoder.Encode(ws)
+	err:=encoder.Encode(fileContent)
 	if err!=nil{
 		log.Panic(err)
 	}
 
-	err=ioutil.WriteFile(walletFile,content.Bytes(),0644)
+	err=ioutil.WriteFile(walletFile,content.Bytes(),0600)
 	if err!=nil{
 		log.Panic(err)
 	}
-}
\ No newline at end of file
+}
+
+//newWalletKey returns the key pair of the wallet as it is saved, D is padded to the curve size.
+//Wallets without their private key have none.
+func newWalletKey(wallet *Wallet) walletKey{
+	var privKey []byte
+	if wallet.PrivateKey.D!=nil{
+		privKey=make([]byte,(wallet.PrivateKey.Curve.Params().BitSize+7)/8)
+		wallet.PrivateKey.D.FillBytes(privKey)
+	}
+
+	return walletKey{privKey,wallet.PublicKey}
+}
+
+//publicWallet returns a wallet of the public key without the private key
+func publicWallet(pubKey []byte) (*Wallet,error){
+	key,err:=ParsePublicKey(pubKey)
+	if err!=nil{
+		return nil,err
+	}
+
+	return &Wallet{ecdsa.PrivateKey{PublicKey:*key},pubKey},nil
+}
+
+//wallet rebuilds the key pair. The public key is kept as saved, since the address is its hash.
+func (key walletKey) wallet() (*Wallet,error){
+	curve:=elliptic.P256()
+	privKey:=ecdsa.PrivateKey{D:new(big.Int).SetBytes(key.PrivateKey)}
+	privKey.PublicKey.Curve=curve
+	privKey.PublicKey.X,privKey.PublicKey.Y=curve.ScalarBaseMult(key.PrivateKey)
+
+	pubKey,err:=ParsePublicKey(key.PublicKey)
+	if err!=nil{
+		return nil,err
+	}
+	if pubKey.X.Cmp(privKey.PublicKey.X)!=0||pubKey.Y.Cmp(privKey.PublicKey.Y)!=0{
+		return nil,errors.New("wallet public key doesn't match its private key")
+	}
+
+	return &Wallet{privKey,key.PublicKey},nil
+}
+
+//loadLegacyWallets decodes a wallet file written before walletFileVersion,
+//which holds the gob encoded ecdsa keys of the Wallets map.
+//The curve is always P-256, so the Curve fields are skipped.
+func loadLegacyWallets(fileContent []byte) (map[string]*Wallet,error){
+	var legacy legacyWallets
+	err:=gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&legacy)
+	if err!=nil{
+		return nil,fmt.Errorf("wallet file is not readable: %w",err)
+	}
+
+	wallets:=make(map[string]*Wallet)
+	for address,w:=range legacy.Wallets{
+		if w==nil||w.PrivateKey.D==nil{
+			return nil,fmt.Errorf("wallet %s has no private key",address)
+		}
+		wallet,err:=walletKey{w.PrivateKey.D.Bytes(),w.PublicKey}.wallet()
+		if err!=nil{
+			return nil,fmt.Errorf("wallet %s: %w",address,err)
+		}
+		wallets[address]=wallet
+	}
+
+	return wallets,nil
+}
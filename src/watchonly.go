@@ -0,0 +1,98 @@
+package main
+
+import(
+	"errors"
+	"fmt"
+	"sort"
+)
+
+//Watch-only addresses are tracked for the wallet balance and history like the addresses
+//of the key pairs, but there is no private key to spend from them.
+
+var ErrWatchOnly=errors.New("address is watch-only, the wallet has no private key for it")
+
+//ImportAddress adds a watch-only address, which may pay to a public key hash or a script hash
+func (ws *Wallets) ImportAddress(address string) error{
+	if !ValidateAddress(address){
+		return fmt.Errorf("%s is not a valid address",address)
+	}
+	if _,ok:=ws.Wallets[address];ok{
+		return fmt.Errorf("%s is already a spendable address of the wallet",address)
+	}
+	if _,ok:=ws.WatchOnly[address];ok{
+		return nil
+	}
+
+	if ws.WatchOnly==nil{
+		ws.WatchOnly=make(map[string][]byte)
+	}
+	ws.WatchOnly[address]=nil
+	return nil
+}
+
+//ImportPubKey adds the pay-to-pubkey-hash address of a public key as watch-only and returns it.
+//The key can be used in multisig addresses afterwards.
+func (ws *Wallets) ImportPubKey(pubKey []byte) (string,error){
+	_,err:=ParsePublicKey(pubKey)
+	if err!=nil{
+		return "",err
+	}
+
+	address:=encodeAddress(walletversion,HashPubKey(pubKey))
+	err=ws.ImportAddress(address)
+	if err!=nil{
+		return "",err
+	}
+	ws.WatchOnly[address]=pubKey
+
+	return address,nil
+}
+
+//IsWatchOnly reports whether the address is tracked without a private key
+func (ws *Wallets) IsWatchOnly(address string) bool{
+	_,ok:=ws.WatchOnly[address]
+	return ok
+}
+
+//WatchOnlyAddresses returns the watch-only addresses, sorted
+func (ws *Wallets) WatchOnlyAddresses() []string{
+	var addresses []string
+
+	for address:=range ws.WatchOnly{
+		addresses=append(addresses,address)
+	}
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+//SigningWallet returns the key pair of an address that the wallet can spend from
+func (ws *Wallets) SigningWallet(address string) (*Wallet,error){
+	if ws.IsWatchOnly(address){
+		return nil,fmt.Errorf("%w: %s",ErrWatchOnly,address)
+	}
+
+	wallet,ok:=ws.Wallets[address]
+	if !ok{
+		return nil,fmt.Errorf("%s is not an address of the wallet",address)
+	}
+	if wallet.PrivateKey.D==nil{
+		return nil,ErrWalletLocked
+	}
+
+	return wallet,nil
+}
+
+//Scripts returns the locking scripts of the spendable and of the watch-only addresses
+func (ws *Wallets) Scripts() ([][]byte,[][]byte){
+	var spendable,watchOnly [][]byte
+
+	for _,wallet:=range ws.Wallets{
+		spendable=append(spendable,wallet.LockingScript())
+	}
+	for address:=range ws.WatchOnly{
+		watchOnly=append(watchOnly,AddressToScript(address))
+	}
+
+	return spendable,watchOnly
+}
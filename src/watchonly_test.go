@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportWatchOnly(t *testing.T) {
+	inTempDir(t)
+	wallets, _ := NewWallets("test")
+	mine := newAddress(t, wallets)
+	cold, treasury := NewWallet(), NewWallet()
+
+	assert.NotNil(t, wallets.ImportAddress("1invalid"))
+	assert.NotNil(t, wallets.ImportAddress(mine), "Spendable address isn't watch-only")
+	assert.Nil(t, wallets.ImportAddress(string(cold.GetAddress())))
+	address, err := wallets.ImportPubKey(treasury.PublicKey)
+	assert.Nil(t, err)
+	assert.Equal(t, string(treasury.GetAddress()), address)
+	_, err = wallets.ImportPubKey([]byte{0x02, 0x01})
+	assert.NotNil(t, err, "Public key must be on the curve")
+
+	_, err = wallets.SigningWallet(address)
+	assert.True(t, errors.Is(err, ErrWatchOnly), "Watch-only address can't sign")
+	_, err = wallets.SigningWallet(mine)
+	assert.Nil(t, err)
+
+	_, _, err = wallets.MultiSigAddress(2, []string{mine, address})
+	assert.Nil(t, err, "Imported public key can be used in a multisig")
+	_, _, err = wallets.MultiSigAddress(2, []string{mine, string(cold.GetAddress())})
+	assert.NotNil(t, err, "Imported address has no public key")
+
+	assert.Nil(t, wallets.Encrypt("secret"))
+	wallets.SaveToFile("test")
+	loaded, _ := NewWallets("test")
+	assert.True(t, loaded.IsLocked())
+	assert.Equal(t, wallets.WatchOnly, loaded.WatchOnly, "Watch-only addresses are listed while locked")
+	assert.Equal(t, []string{mine}, loaded.GetAddresses())
+}
+
+func TestWalletBalanceAndHistory(t *testing.T) {
+	bc, miner := newTestBlockchain(t)
+	genesis := bc.Iterator().Next().Transactions[0]
+	cold := NewWallet()
+	wallets := Wallets{Wallets: map[string]*Wallet{string(miner.GetAddress()): miner}}
+	wallets.ImportAddress(string(cold.GetAddress()))
+
+	tx := spend(miner, genesis, 0, string(cold.GetAddress()), 3, 1)
+	_, err := bc.MineBlock(context.Background(), []*Transaction{NewCoinbaseTX(string(NewWallet().GetAddress()), "", subsidy+1), tx})
+	assert.Nil(t, err)
+
+	spendable, watchOnly := wallets.Scripts()
+	UTXOSet := UTXOSet{bc}
+	balance, _ := UTXOSet.FindScriptsBalance(spendable)
+	assert.Equal(t, subsidy-4, balance)
+	balance, _ = UTXOSet.FindScriptsBalance(watchOnly)
+	assert.Equal(t, 3, balance, "Watch-only balance is tracked")
+
+	history := bc.FindWalletTransactions(append(spendable, watchOnly...))
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, genesis.ID, history[0].Transaction.ID)
+		assert.Equal(t, subsidy, history[0].Received)
+		assert.Equal(t, tx.ID, history[1].Transaction.ID)
+		assert.Equal(t, 1, history[1].Height)
+		assert.Equal(t, subsidy-1, history[1].Received)
+		assert.Equal(t, subsidy, history[1].Sent)
+	}
+
+	history = bc.FindWalletTransactions(watchOnly)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, 3, history[0].Received)
+		assert.Equal(t, 0, history[0].Sent)
+	}
+}